@@ -0,0 +1,375 @@
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+	"siploadbalancer/rotate"
+)
+
+const (
+	QueueSize  = 10000
+	maxHistory = 50 // stopped captures kept for listing
+
+	StopAPI        = "api"
+	StopDuration   = "duration"
+	StopMaxPackets = "maxPackets"
+	StopDisabled   = "disabled"
+	StopError      = "error"
+)
+
+var (
+	ErrDisabled   = errors.New("packet capture is disabled")
+	ErrTooMany    = errors.New("too many captures running")
+	ErrNotFound   = errors.New("capture not found")
+	ErrNotRunning = errors.New("capture is not running")
+)
+
+type (
+	// Request starts a capture
+	Request struct {
+		Filter
+		Duration   int   `json:"duration"`   // seconds, 0 runs until stopped
+		MaxPackets int64 `json:"maxPackets"` // 0 is unlimited
+	}
+
+	// Info is the API view of a capture
+	Info struct {
+		ID         string     `json:"id"`
+		Filter     Filter     `json:"filter"`
+		Duration   int        `json:"duration,omitempty"`
+		MaxPackets int64      `json:"maxPackets,omitempty"`
+		Path       string     `json:"path"`
+		Running    bool       `json:"running"`
+		StartTime  time.Time  `json:"startTime"`
+		StopTime   *time.Time `json:"stopTime,omitempty"`
+		StopReason string     `json:"stopReason,omitempty"`
+		Packets    int64      `json:"packets"`
+		Bytes      int64      `json:"bytes"`
+	}
+
+	capture struct {
+		mu     sync.Mutex
+		info   Info
+		writer *rotate.Writer
+		timer  *time.Timer
+	}
+
+	packet struct {
+		time     time.Time
+		src, dst netip.AddrPort
+		outbound bool
+		data     []byte
+	}
+)
+
+var (
+	logger = logging.For("capture")
+
+	queue   = make(chan packet, QueueSize)
+	running atomic.Int32
+
+	config   Config
+	captures []*capture // oldest first
+	active   atomic.Pointer[[]*capture]
+	mu       sync.Mutex
+)
+
+// Start runs the capture writer with the settings configured in data
+func Start(data []byte) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid capture configuration", logging.Err(err))
+	}
+	setConfig(cfg)
+	RegisterReloader("capture", reloadConfig)
+
+	go run()
+}
+
+func reloadConfig(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { setConfig(cfg) }, nil
+}
+
+func setConfig(cfg Config) {
+	mu.Lock()
+	config = cfg
+	var stopping []*capture
+	if !cfg.Enabled {
+		stopping = runningCaptures()
+	}
+	mu.Unlock()
+
+	for _, c := range stopping {
+		c.stop(StopDisabled)
+	}
+}
+
+// Active reports whether any capture is running, datagrams need not be
+// passed to Received and Sent otherwise
+func Active() bool {
+	return running.Load() > 0
+}
+
+// Received queues a datagram read from the SIP socket
+func Received(src, local *net.UDPAddr, data []byte) {
+	enqueue(src, local, false, data)
+}
+
+// Sent queues a datagram written to the SIP socket
+func Sent(local, dst *net.UDPAddr, data []byte) {
+	enqueue(local, dst, true, data)
+}
+
+// enqueue copies data, which may belong to a pooled buffer, without ever blocking
+func enqueue(src, dst *net.UDPAddr, outbound bool, data []byte) {
+	p := packet{
+		time:     time.Now(),
+		src:      addrPort(src),
+		dst:      addrPort(dst),
+		outbound: outbound,
+		data:     slices.Clone(data),
+	}
+	select {
+	case queue <- p:
+	default:
+		Prometrics.DroppedCapturePackets.Inc()
+	}
+}
+
+func addrPort(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+func run() {
+	for p := range queue {
+		caps := active.Load()
+		if caps == nil {
+			continue
+		}
+		var callID, method string
+		if slices.ContainsFunc(*caps, func(c *capture) bool { return c.info.Filter.needsHeaders() }) {
+			callID, method = PeekCallID(p.data)
+		}
+		var record []byte
+		for _, c := range *caps {
+			if !c.info.Filter.match(&p, callID, method) {
+				continue
+			}
+			if record == nil {
+				record = encodeRecord(p.time, p.src, p.dst, p.outbound, p.data)
+			}
+			c.write(record)
+		}
+	}
+}
+
+// ==========================================================================
+
+// Begin starts a capture writing to a new file in the configured directory
+func Begin(rqst Request) (Info, error) {
+	if err := rqst.Filter.parse(); err != nil {
+		return Info{}, err
+	}
+	if rqst.Duration < 0 || rqst.MaxPackets < 0 {
+		return Info{}, errors.New("duration and maxPackets must not be negative")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !config.Enabled {
+		return Info{}, ErrDisabled
+	}
+	if len(runningCaptures()) >= config.MaxCaptures {
+		return Info{}, ErrTooMany
+	}
+
+	id := newID()
+	if err := os.MkdirAll(config.Directory, 0o755); err != nil {
+		return Info{}, err
+	}
+	path := filepath.Join(config.Directory, "capture-"+id+".pcap")
+	w, err := rotate.Open(path, config.rotateConfig())
+	if err != nil {
+		return Info{}, err
+	}
+
+	c := &capture{
+		writer: w,
+		info: Info{
+			ID:         id,
+			Filter:     rqst.Filter,
+			Duration:   rqst.Duration,
+			MaxPackets: rqst.MaxPackets,
+			Path:       path,
+			Running:    true,
+			StartTime:  time.Now().UTC(),
+		},
+	}
+	if rqst.Duration > 0 {
+		c.timer = time.AfterFunc(time.Duration(rqst.Duration)*time.Second, func() { c.stop(StopDuration) })
+	}
+
+	captures = append(captures, c)
+	pruneHistory()
+	publishActive()
+	logger.Info("Capture started", "id", id, "path", path, "callId", rqst.CallID, "peerIp", rqst.PeerIP, "method", rqst.Method)
+	return c.snapshot(), nil
+}
+
+// End stops a running capture
+func End(id string) (Info, error) {
+	c := find(id)
+	if c == nil {
+		return Info{}, ErrNotFound
+	}
+	if !c.stop(StopAPI) {
+		return c.snapshot(), ErrNotRunning
+	}
+	return c.snapshot(), nil
+}
+
+func Get(id string) (Info, bool) {
+	c := find(id)
+	if c == nil {
+		return Info{}, false
+	}
+	return c.snapshot(), true
+}
+
+// List returns the running and the recently stopped captures, oldest first
+func List() []Info {
+	mu.Lock()
+	caps := slices.Clone(captures)
+	mu.Unlock()
+
+	infos := make([]Info, 0, len(caps))
+	for _, c := range caps {
+		infos = append(infos, c.snapshot())
+	}
+	return infos
+}
+
+func find(id string) *capture {
+	mu.Lock()
+	defer mu.Unlock()
+
+	idx := slices.IndexFunc(captures, func(c *capture) bool { return c.info.ID == id })
+	if idx == -1 {
+		return nil
+	}
+	return captures[idx]
+}
+
+func newID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(b))
+}
+
+// runningCaptures must be called while holding mu
+func runningCaptures() []*capture {
+	return slices.DeleteFunc(slices.Clone(captures), func(c *capture) bool { return !c.isRunning() })
+}
+
+// publishActive hands the running captures to the writer goroutine, must be
+// called while holding mu
+func publishActive() {
+	caps := runningCaptures()
+	active.Store(&caps)
+	running.Store(int32(len(caps)))
+}
+
+// pruneHistory drops the oldest stopped captures, must be called while holding mu
+func pruneHistory() {
+	for stopped := len(captures) - len(runningCaptures()); stopped > maxHistory; stopped-- {
+		idx := slices.IndexFunc(captures, func(c *capture) bool { return !c.isRunning() })
+		captures = slices.Delete(captures, idx, idx+1)
+	}
+}
+
+// ==========================================================================
+
+func (c *capture) isRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info.Running
+}
+
+func (c *capture) snapshot() Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info
+}
+
+func (c *capture) write(record []byte) {
+	c.mu.Lock()
+	if !c.info.Running {
+		c.mu.Unlock()
+		return
+	}
+	_, err := c.writer.Write(record)
+	if err == nil {
+		c.info.Packets++
+		c.info.Bytes += int64(len(record))
+		Prometrics.CapturedPackets.Inc()
+	}
+	full := c.info.MaxPackets > 0 && c.info.Packets >= c.info.MaxPackets
+	c.mu.Unlock()
+
+	switch {
+	case err != nil:
+		logger.Error("Failed to write capture, stopping it", "id", c.info.ID, logging.Err(err))
+		c.stop(StopError)
+	case full:
+		c.stop(StopMaxPackets)
+	}
+}
+
+// stop closes the capture file and reports whether the capture was running
+func (c *capture) stop(reason string) bool {
+	c.mu.Lock()
+	if !c.info.Running {
+		c.mu.Unlock()
+		return false
+	}
+	now := time.Now().UTC()
+	c.info.Running = false
+	c.info.StopTime = &now
+	c.info.StopReason = reason
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if err := c.writer.Close(); err != nil {
+		logger.Error("Failed to close capture", "id", c.info.ID, logging.Err(err))
+	}
+	info := c.info
+	c.mu.Unlock()
+
+	mu.Lock()
+	publishActive()
+	mu.Unlock()
+
+	logger.Info("Capture stopped", "id", info.ID, "reason", reason, "packets", info.Packets)
+	return true
+}
@@ -0,0 +1,159 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/prometheus"
+)
+
+var (
+	local = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	peer  = &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 5060}
+)
+
+func TestMain(m *testing.M) {
+	Prometrics = prometheus.NewMetrics()
+	go run()
+	os.Exit(m.Run())
+}
+
+// withConfig runs captures with cfg, in a directory of the test, until the end of the test
+func withConfig(t *testing.T, cfg Config) {
+	cfg.Enabled = true
+	cfg.Directory = t.TempDir()
+	cfg.setDefaults()
+	setConfig(cfg)
+	t.Cleanup(func() { setConfig(Config{}) })
+}
+
+func sipMessage(callID string, size int) []byte {
+	msg := fmt.Sprintf("OPTIONS sip:probe@198.51.100.7 SIP/2.0\r\nCall-ID: %s\r\nCSeq: 1 OPTIONS\r\n\r\n", callID)
+	return append([]byte(msg), bytes.Repeat([]byte("x"), max(size-len(msg), 0))...)
+}
+
+// waitPackets waits until the capture holds n packets, or stopped
+func waitPackets(t *testing.T, id string, n int64) Info {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		info, _ := Get(id)
+		if info.Packets >= n || !info.Running {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("capture %s holds %d packets, want %d", id, info.Packets, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMaxPackets(t *testing.T) {
+	withConfig(t, Config{})
+	info, err := Begin(Request{Filter: Filter{CallID: "wanted"}, MaxPackets: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 5 {
+		Received(peer, local, sipMessage("other", 100))
+		Sent(local, peer, sipMessage("wanted", 100+i))
+	}
+	info = waitPackets(t, info.ID, 3)
+	if info.Running || info.StopReason != StopMaxPackets || info.Packets != 3 {
+		t.Fatalf("capture %+v, want it stopped at 3 packets", info)
+	}
+
+	data, err := os.ReadFile(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, fileHeader()) || int64(len(data)) != int64(len(fileHeader()))+info.Bytes {
+		t.Errorf("file of %d bytes for %d captured", len(data), info.Bytes)
+	}
+	if bytes.Contains(data, []byte("Call-ID: other")) {
+		t.Error("filtered out datagram captured")
+	}
+	if _, err := End(info.ID); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("End of a stopped capture: %v", err)
+	}
+}
+
+func TestMaxCaptures(t *testing.T) {
+	withConfig(t, Config{MaxCaptures: 1})
+	info, err := Begin(Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Begin(Request{}); !errors.Is(err, ErrTooMany) {
+		t.Errorf("second capture: %v, want ErrTooMany", err)
+	}
+	if _, err := End(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	info, err = Begin(Request{})
+	if err != nil {
+		t.Errorf("capture after the first ended: %v", err)
+	}
+
+	setConfig(Config{})
+	if info, _ := Get(info.ID); info.Running || info.StopReason != StopDisabled {
+		t.Errorf("capture %+v, want it stopped when disabled", info)
+	}
+	if _, err := Begin(Request{}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("capture while disabled: %v, want ErrDisabled", err)
+	}
+}
+
+func TestRotationBySize(t *testing.T) {
+	withConfig(t, Config{MaxSize: 1, MaxBackups: 2})
+	info, err := Begin(Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { End(info.ID) })
+
+	// 60 KB datagrams, 1 MB files: 17 records each
+	const count = 60
+	for i := range count {
+		Received(peer, local, sipMessage(fmt.Sprintf("big-%d", i), 60000))
+		if i%10 == 9 {
+			waitPackets(t, info.ID, int64(i+1)) // never overflow the queue
+		}
+	}
+	waitPackets(t, info.ID, count)
+	if _, err := End(info.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(info.Path), "capture-"+info.ID+"*.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("files %v, want the current one and 2 backups", files)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 1<<20 {
+			t.Errorf("%s: %d bytes, over maxSize", name, len(data))
+		}
+		if !bytes.HasPrefix(data, fileHeader()) {
+			t.Errorf("%s: no pcap header", name)
+		}
+		if name != info.Path && !strings.Contains(string(data), "Call-ID: big-") {
+			t.Errorf("%s: no record", name)
+		}
+	}
+}
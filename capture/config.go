@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"siploadbalancer/logging"
+	"siploadbalancer/rotate"
+)
+
+const (
+	DefaultDirectory   = "captures"
+	DefaultMaxCaptures = 4
+)
+
+type Config struct {
+	Enabled        bool   `json:"enabled"`
+	Directory      string `json:"directory"`
+	MaxCaptures    int    `json:"maxCaptures"`    // captures running at once
+	MaxSize        int    `json:"maxSize"`        // MB
+	RotateInterval int    `json:"rotateInterval"` // seconds
+	Compress       bool   `json:"compress"`
+	MaxBackups     int    `json:"maxBackups"`
+}
+
+func parseConfig(data []byte) (Config, error) {
+	var in struct {
+		Capture Config `json:"capture"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.Capture, err
+	}
+	in.Capture.setDefaults()
+	return in.Capture, in.Capture.validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Directory == "" {
+		c.Directory = DefaultDirectory
+	}
+	if c.MaxCaptures == 0 {
+		c.MaxCaptures = DefaultMaxCaptures
+	}
+}
+
+func (c *Config) validate() error {
+	if c.MaxCaptures < 0 {
+		return fmt.Errorf("capture.maxCaptures [%d] is invalid", c.MaxCaptures)
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("capture.maxSize [%d] is invalid", c.MaxSize)
+	}
+	if c.RotateInterval < 0 {
+		return fmt.Errorf("capture.rotateInterval [%d] is invalid", c.RotateInterval)
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("capture.maxBackups [%d] is invalid", c.MaxBackups)
+	}
+	return nil
+}
+
+func (c *Config) rotateConfig() rotate.Config {
+	return rotate.Config{
+		MaxSize:    int64(c.MaxSize) << 20,
+		Interval:   time.Duration(c.RotateInterval) * time.Second,
+		Compress:   c.Compress,
+		MaxBackups: c.MaxBackups,
+		Header:     fileHeader(),
+		Logger:     logging.For("rotate"),
+	}
+}
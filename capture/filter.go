@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Filter selects the datagrams of a capture, empty fields match everything
+type Filter struct {
+	CallID string `json:"callId,omitempty"`
+	PeerIP string `json:"peerIp,omitempty"` // address or CIDR, matched against both ends
+	Method string `json:"method,omitempty"` // matched against CSeq, so responses are included
+
+	peer netip.Prefix
+}
+
+func (f *Filter) parse() error {
+	f.Method = strings.ToUpper(strings.TrimSpace(f.Method))
+	f.CallID = strings.TrimSpace(f.CallID)
+	if f.PeerIP == "" {
+		return nil
+	}
+	if strings.Contains(f.PeerIP, "/") {
+		prefix, err := netip.ParsePrefix(f.PeerIP)
+		if err != nil {
+			return fmt.Errorf("peerIp [%s] is invalid", f.PeerIP)
+		}
+		f.peer = prefix.Masked()
+		return nil
+	}
+	addr, err := netip.ParseAddr(f.PeerIP)
+	if err != nil {
+		return fmt.Errorf("peerIp [%s] is invalid", f.PeerIP)
+	}
+	addr = addr.Unmap()
+	f.peer = netip.PrefixFrom(addr, addr.BitLen())
+	return nil
+}
+
+func (f *Filter) needsHeaders() bool {
+	return f.CallID != "" || f.Method != ""
+}
+
+func (f *Filter) match(p *packet, callID, method string) bool {
+	if f.peer.IsValid() && !f.peer.Contains(p.src.Addr()) && !f.peer.Contains(p.dst.Addr()) {
+		return false
+	}
+	if f.CallID != "" && f.CallID != callID {
+		return false
+	}
+	return f.Method == "" || f.Method == method
+}
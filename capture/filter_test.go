@@ -0,0 +1,77 @@
+package capture
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestFilterParse(t *testing.T) {
+	tests := []struct {
+		peerIP string
+		want   string // prefix, empty for an error
+	}{
+		{"", "invalid Prefix"},
+		{"192.0.2.1", "192.0.2.1/32"},
+		{"::ffff:192.0.2.1", "192.0.2.1/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"192.0.2.77/24", "192.0.2.0/24"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"192.0.2.300", ""},
+		{"192.0.2.0/33", ""},
+		{"core-a", ""},
+	}
+	for _, tt := range tests {
+		f := Filter{PeerIP: tt.peerIP}
+		err := f.parse()
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("peerIp %q accepted as %s", tt.peerIP, f.peer)
+		case tt.want != "" && err != nil:
+			t.Errorf("peerIp %q: %v", tt.peerIP, err)
+		case tt.want != "" && f.peer.String() != tt.want:
+			t.Errorf("peerIp %q parsed as %s, want %s", tt.peerIP, f.peer, tt.want)
+		}
+	}
+
+	f := Filter{CallID: " a84b4c76e66710 ", Method: " invite"}
+	if err := f.parse(); err != nil || f.CallID != "a84b4c76e66710" || f.Method != "INVITE" {
+		t.Errorf("parsed %+v (%v)", f, err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	p := &packet{src: netip.MustParseAddrPort("192.0.2.1:5060"), dst: netip.MustParseAddrPort("198.51.100.7:5060")}
+	tests := []struct {
+		name   string
+		filter Filter
+		callID string
+		method string
+		want   bool
+	}{
+		{"empty", Filter{}, "", "", true},
+		{"source address", Filter{PeerIP: "192.0.2.1"}, "", "", true},
+		{"destination address", Filter{PeerIP: "198.51.100.7"}, "", "", true},
+		{"other address", Filter{PeerIP: "192.0.2.2"}, "", "", false},
+		{"subnet", Filter{PeerIP: "198.51.100.0/24"}, "", "", true},
+		{"other subnet", Filter{PeerIP: "203.0.113.0/24"}, "", "", false},
+		{"call id", Filter{CallID: "c1"}, "c1", "INVITE", true},
+		{"other call id", Filter{CallID: "c1"}, "c2", "INVITE", false},
+		{"no call id", Filter{CallID: "c1"}, "", "", false},
+		{"method", Filter{Method: "invite"}, "c1", "INVITE", true},
+		{"other method", Filter{Method: "BYE"}, "c1", "INVITE", false},
+		{"all", Filter{PeerIP: "192.0.2.0/24", CallID: "c1", Method: "BYE"}, "c1", "BYE", true},
+		{"all but the address", Filter{PeerIP: "203.0.113.9", CallID: "c1", Method: "BYE"}, "c1", "BYE", false},
+	}
+	for _, tt := range tests {
+		if err := tt.filter.parse(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := tt.filter.match(p, tt.callID, tt.method); got != tt.want {
+			t.Errorf("%s: match = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	if !(&Filter{CallID: "c1"}).needsHeaders() || !(&Filter{Method: "BYE"}).needsHeaders() || (&Filter{PeerIP: "192.0.2.1"}).needsHeaders() {
+		t.Error("needsHeaders only for call id and method filters")
+	}
+}
@@ -0,0 +1,132 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// libpcap file format with nanosecond timestamps and Ethernet link type
+const (
+	pcapMagicNanos = 0xa1b23c4d
+	pcapSnapLen    = 65535
+	linkEthernet   = 1
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	protoUDP      = 17
+	ttl           = 64
+)
+
+// synthesised MAC addresses, locally administered
+var (
+	macLocal = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	macPeer  = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+func fileHeader() []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[0:], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(b[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(b[20:], linkEthernet)
+	return b
+}
+
+// encodeRecord wraps payload in Ethernet, IP and UDP headers as a pcap record
+func encodeRecord(t time.Time, src, dst netip.AddrPort, outbound bool, payload []byte) []byte {
+	ipv6 := src.Addr().Is6() || dst.Addr().Is6()
+	ipHeaderLen := 20
+	if ipv6 {
+		ipHeaderLen = 40
+	}
+	frameLen := 14 + ipHeaderLen + 8 + len(payload)
+
+	b := make([]byte, 16+frameLen)
+	binary.LittleEndian.PutUint32(b[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(b[8:], uint32(frameLen))
+	binary.LittleEndian.PutUint32(b[12:], uint32(frameLen))
+
+	eth := b[16:]
+	if outbound {
+		copy(eth[0:], macPeer)
+		copy(eth[6:], macLocal)
+	} else {
+		copy(eth[0:], macLocal)
+		copy(eth[6:], macPeer)
+	}
+
+	ip := eth[14:]
+	udp := ip[ipHeaderLen:]
+	udpLen := 8 + len(payload)
+	if ipv6 {
+		binary.BigEndian.PutUint16(eth[12:], etherTypeIPv6)
+		srcIP, dstIP := src.Addr().As16(), dst.Addr().As16()
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6] = protoUDP
+		ip[7] = ttl
+		copy(ip[8:], srcIP[:])
+		copy(ip[24:], dstIP[:])
+	} else {
+		binary.BigEndian.PutUint16(eth[12:], etherTypeIPv4)
+		srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipHeaderLen+udpLen))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = ttl
+		ip[9] = protoUDP
+		copy(ip[12:], srcIP[:])
+		copy(ip[16:], dstIP[:])
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip[:20]))
+	}
+
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[8:], payload)
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(ip, ipv6, udp[:udpLen]))
+
+	return b
+}
+
+func udpChecksum(ip []byte, ipv6 bool, udp []byte) uint16 {
+	var pseudo []byte
+	if ipv6 {
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:], ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+		pseudo[39] = protoUDP
+	} else {
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:], ip[12:20])
+		pseudo[9] = protoUDP
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	}
+	sum := checksum(checksumAdd(0, pseudo), udp)
+	if sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
+
+func checksumAdd(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// checksum is the Internet checksum of b, continuing from a partial sum
+func checksum(sum uint32, b []byte) uint16 {
+	sum = checksumAdd(sum, b)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestFileHeader(t *testing.T) {
+	want := []byte{
+		0x4d, 0x3c, 0xb2, 0xa1, // nanosecond magic, little endian
+		0x02, 0x00, 0x04, 0x00, // version 2.4
+		0x00, 0x00, 0x00, 0x00, // thiszone
+		0x00, 0x00, 0x00, 0x00, // sigfigs
+		0xff, 0xff, 0x00, 0x00, // snaplen 65535
+		0x01, 0x00, 0x00, 0x00, // Ethernet
+	}
+	if got := fileHeader(); !bytes.Equal(got, want) {
+		t.Errorf("header = % x\nwant     % x", got, want)
+	}
+}
+
+func TestEncodeRecordIPv4(t *testing.T) {
+	at := time.Date(2025, 1, 2, 15, 4, 5, 123456789, time.UTC)
+	src, dst := netip.MustParseAddrPort("192.0.2.1:5060"), netip.MustParseAddrPort("198.51.100.7:5080")
+	payload := []byte("OPTIONS sip:probe@198.51.100.7 SIP/2.0\r\n\r\n") // odd length
+
+	b := encodeRecord(at, src, dst, false, payload)
+	frame := checkRecordHeader(t, b, at, 14+20+8+len(payload))
+
+	if !bytes.Equal(frame[0:6], macLocal) || !bytes.Equal(frame[6:12], macPeer) {
+		t.Errorf("received frame MACs = % x -> % x", frame[6:12], frame[0:6])
+	}
+	if got := binary.BigEndian.Uint16(frame[12:]); got != etherTypeIPv4 {
+		t.Errorf("ethertype = %#04x", got)
+	}
+
+	ip := frame[14:34]
+	if ip[0] != 0x45 || ip[8] != ttl || ip[9] != protoUDP {
+		t.Errorf("version/ihl %#02x, ttl %d, protocol %d", ip[0], ip[8], ip[9])
+	}
+	if got := binary.BigEndian.Uint16(ip[2:]); int(got) != 20+8+len(payload) {
+		t.Errorf("total length = %d", got)
+	}
+	if netip.AddrFrom4([4]byte(ip[12:16])) != src.Addr() || netip.AddrFrom4([4]byte(ip[16:20])) != dst.Addr() {
+		t.Errorf("addresses = % x", ip[12:20])
+	}
+	if checksum(0, ip) != 0 {
+		t.Error("IPv4 header checksum does not verify")
+	}
+
+	udp := frame[34:]
+	checkUDP(t, udp, src, dst, payload)
+	pseudo := append(append([]byte{}, ip[12:20]...), 0, protoUDP, 0, 0)
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	if checksum(checksumAdd(0, pseudo), udp) != 0 {
+		t.Error("UDP checksum does not verify")
+	}
+}
+
+func TestEncodeRecordIPv6(t *testing.T) {
+	at := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	src, dst := netip.MustParseAddrPort("[2001:db8::1]:5060"), netip.MustParseAddrPort("[2001:db8::2]:5060")
+	payload := []byte("SIP/2.0 200 OK\r\n\r\n")
+
+	b := encodeRecord(at, src, dst, true, payload)
+	frame := checkRecordHeader(t, b, at, 14+40+8+len(payload))
+
+	if !bytes.Equal(frame[0:6], macPeer) || !bytes.Equal(frame[6:12], macLocal) {
+		t.Errorf("sent frame MACs = % x -> % x", frame[6:12], frame[0:6])
+	}
+	if got := binary.BigEndian.Uint16(frame[12:]); got != etherTypeIPv6 {
+		t.Errorf("ethertype = %#04x", got)
+	}
+
+	ip := frame[14:54]
+	if ip[0]>>4 != 6 || ip[6] != protoUDP || ip[7] != ttl {
+		t.Errorf("version %d, next header %d, hop limit %d", ip[0]>>4, ip[6], ip[7])
+	}
+	if got := binary.BigEndian.Uint16(ip[4:]); int(got) != 8+len(payload) {
+		t.Errorf("payload length = %d", got)
+	}
+	if netip.AddrFrom16([16]byte(ip[8:24])) != src.Addr() || netip.AddrFrom16([16]byte(ip[24:40])) != dst.Addr() {
+		t.Errorf("addresses = % x", ip[8:40])
+	}
+
+	udp := frame[54:]
+	checkUDP(t, udp, src, dst, payload)
+	pseudo := make([]byte, 40)
+	copy(pseudo, ip[8:40])
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+	pseudo[39] = protoUDP
+	if checksum(checksumAdd(0, pseudo), udp) != 0 {
+		t.Error("UDP checksum does not verify")
+	}
+}
+
+// checkRecordHeader checks the pcap record header of b and returns its frame
+func checkRecordHeader(t *testing.T, b []byte, at time.Time, frameLen int) []byte {
+	t.Helper()
+	if len(b) != 16+frameLen {
+		t.Fatalf("record of %d bytes, want %d", len(b), 16+frameLen)
+	}
+	sec, nsec := binary.LittleEndian.Uint32(b[0:]), binary.LittleEndian.Uint32(b[4:])
+	if int64(sec) != at.Unix() || int(nsec) != at.Nanosecond() {
+		t.Errorf("timestamp = %d.%09d, want %d.%09d", sec, nsec, at.Unix(), at.Nanosecond())
+	}
+	incl, orig := binary.LittleEndian.Uint32(b[8:]), binary.LittleEndian.Uint32(b[12:])
+	if int(incl) != frameLen || int(orig) != frameLen {
+		t.Errorf("lengths = %d/%d, want %d", incl, orig, frameLen)
+	}
+	return b[16:]
+}
+
+func checkUDP(t *testing.T, udp []byte, src, dst netip.AddrPort, payload []byte) {
+	t.Helper()
+	if binary.BigEndian.Uint16(udp[0:]) != src.Port() || binary.BigEndian.Uint16(udp[2:]) != dst.Port() {
+		t.Errorf("ports = %d -> %d", binary.BigEndian.Uint16(udp[0:]), binary.BigEndian.Uint16(udp[2:]))
+	}
+	if got := binary.BigEndian.Uint16(udp[4:]); int(got) != 8+len(payload) {
+		t.Errorf("UDP length = %d", got)
+	}
+	if !bytes.Equal(udp[8:], payload) {
+		t.Errorf("payload = %q", udp[8:])
+	}
+}
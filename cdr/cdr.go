@@ -0,0 +1,181 @@
+package cdr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+	"siploadbalancer/rotate"
+)
+
+const QueueSize = 10000
+
+// Record is the call detail record of a dialogue, written once it leaves the cache
+type Record struct {
+	CallID           string     `json:"callId"`
+	Method           string     `json:"method"`
+	FromUser         string     `json:"fromUser"`
+	ToUser           string     `json:"toUser"`
+	SourceAddr       string     `json:"sourceAddr"`
+	Direction        string     `json:"direction"`
+	Node             string     `json:"node"`
+	NodeAddr         string     `json:"nodeAddr"`
+	StartTime        time.Time  `json:"startTime"`
+	AnswerTime       *time.Time `json:"answerTime,omitempty"`
+	EndTime          *time.Time `json:"endTime,omitempty"`
+	Status           string     `json:"status"`
+	FinalCode        int        `json:"finalCode"`
+	Duration         float64    `json:"duration"`         // seconds between answer and end
+	FailoverAttempts int        `json:"failoverAttempts"` // servers passed over before the chosen one
+}
+
+var (
+	logger = logging.For("cdr")
+
+	queue = make(chan Record, QueueSize)
+
+	config  Config
+	writer  *rotate.Writer
+	buf     *bufio.Writer
+	writeMu sync.Mutex
+)
+
+// Start writes the records configured in data from a background goroutine
+func Start(data []byte) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid cdr configuration", logging.Err(err))
+	}
+	setConfig(cfg)
+	RegisterReloader("cdr", reloadConfig)
+
+	go run()
+}
+
+func reloadConfig(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { setConfig(cfg) }, nil
+}
+
+func setConfig(cfg Config) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if cfg == config && (writer != nil) == cfg.Enabled {
+		return
+	}
+	closeWriter()
+	config = cfg
+	if !cfg.Enabled {
+		return
+	}
+
+	w, err := rotate.Open(cfg.Path, cfg.rotateConfig())
+	if err != nil {
+		logger.Error("Failed to open CDR file, CDRs are not written", "path", cfg.Path, logging.Err(err))
+		return
+	}
+	writer, buf = w, bufio.NewWriter(w)
+	logger.Info("Writing CDRs", "format", cfg.Format, "path", cfg.Path)
+}
+
+// closeWriter must be called while holding writeMu
+func closeWriter() {
+	if writer == nil {
+		return
+	}
+	if err := buf.Flush(); err != nil {
+		logger.Error("Failed to write CDRs", logging.Err(err))
+	}
+	if err := writer.Close(); err != nil {
+		logger.Error("Failed to close CDR file", logging.Err(err))
+	}
+	writer, buf = nil, nil
+}
+
+func IsEnabled() bool {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	return writer != nil
+}
+
+// Write queues rec without ever blocking, it is dropped when the queue is full
+func Write(rec Record) {
+	select {
+	case queue <- rec:
+	default:
+		Prometrics.DroppedCDRs.Inc()
+	}
+}
+
+func run() {
+	for rec := range queue {
+		writeRecord(rec)
+	}
+}
+
+func writeRecord(rec Record) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if writer == nil {
+		return
+	}
+
+	var err error
+	switch config.Format {
+	case FormatCSV:
+		cw := csv.NewWriter(buf)
+		err = cw.Write(rec.csvRow())
+		cw.Flush()
+		err = errors.Join(err, cw.Error())
+	default:
+		err = json.NewEncoder(buf).Encode(rec)
+	}
+	if err == nil && len(queue) == 0 {
+		err = buf.Flush()
+	}
+	if err != nil {
+		logger.Error("Failed to write CDR", "callId", rec.CallID, logging.Err(err))
+		return
+	}
+	Prometrics.WrittenCDRs.Inc()
+}
+
+// ==========================================================================
+
+var csvColumns = []string{"callId", "method", "fromUser", "toUser", "sourceAddr", "direction", "node", "nodeAddr",
+	"startTime", "answerTime", "endTime", "status", "finalCode", "duration", "failoverAttempts"}
+
+func csvHeader() []byte {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	_ = cw.Write(csvColumns)
+	cw.Flush()
+	return b.Bytes()
+}
+
+func (rec *Record) csvRow() []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return []string{
+		rec.CallID, rec.Method, rec.FromUser, rec.ToUser, rec.SourceAddr, rec.Direction, rec.Node, rec.NodeAddr,
+		rec.StartTime.Format(time.RFC3339Nano), formatTime(rec.AnswerTime), formatTime(rec.EndTime),
+		rec.Status, strconv.Itoa(rec.FinalCode), strconv.FormatFloat(rec.Duration, 'f', 3, 64), strconv.Itoa(rec.FailoverAttempts),
+	}
+}
@@ -0,0 +1,87 @@
+package cdr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"siploadbalancer/logging"
+	"siploadbalancer/rotate"
+)
+
+type Format string
+
+const (
+	FormatJSONL Format = "jsonl"
+	FormatCSV   Format = "csv"
+
+	DefaultPath = "cdr.jsonl"
+)
+
+type Config struct {
+	Enabled        bool   `json:"enabled"`
+	Format         Format `json:"format"`
+	Path           string `json:"path"`
+	MaxSize        int    `json:"maxSize"`        // MB
+	RotateInterval int    `json:"rotateInterval"` // seconds
+	Compress       bool   `json:"compress"`
+	MaxBackups     int    `json:"maxBackups"`
+}
+
+func parseConfig(data []byte) (Config, error) {
+	var in struct {
+		CDR Config `json:"cdr"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.CDR, err
+	}
+	in.CDR.setDefaults()
+	return in.CDR, in.CDR.validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Format == "" {
+		c.Format = FormatJSONL
+	}
+	if c.Path == "" {
+		c.Path = DefaultPath
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Format != FormatJSONL && c.Format != FormatCSV {
+		return fmt.Errorf("cdr.format [%s] is unknown", c.Format)
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("cdr.maxSize [%d] is invalid", c.MaxSize)
+	}
+	if c.RotateInterval < 0 {
+		return fmt.Errorf("cdr.rotateInterval [%d] is invalid", c.RotateInterval)
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("cdr.maxBackups [%d] is invalid", c.MaxBackups)
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if fi, err := os.Stat(filepath.Dir(c.Path)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("cdr.path [%s] is not in an existing directory", c.Path)
+	}
+	return nil
+}
+
+func (c *Config) rotateConfig() rotate.Config {
+	rc := rotate.Config{
+		MaxSize:    int64(c.MaxSize) << 20,
+		Interval:   time.Duration(c.RotateInterval) * time.Second,
+		Compress:   c.Compress,
+		MaxBackups: c.MaxBackups,
+		Logger:     logging.For("rotate"),
+	}
+	if c.Format == FormatCSV {
+		rc.Header = csvHeader()
+	}
+	return rc
+}
@@ -0,0 +1,110 @@
+package cl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// limit is a rate with its bucket size, it never changes once built
+type limit struct {
+	rate      int           // call attempts per second, -1 unlimited
+	mode      Mode          // window or tokenBucket
+	interval  time.Duration // token bucket: time for one token to refill
+	tolerance time.Duration // token bucket: time for the whole bucket to refill
+}
+
+func newLimit(rate, burst int, mode Mode) limit {
+	l := limit{rate: rate, mode: mode}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+		l.tolerance = l.interval * time.Duration(burstOf(rate, burst))
+	}
+	return l
+}
+
+// burstOf returns the bucket size for rate, burst when set
+func burstOf(rate, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(rate, 1)
+}
+
+// bucket counts the call attempts against a limit, without locks
+type bucket struct {
+	callCount atomic.Int64 // call attempts allowed in the current second
+	rejected  atomic.Int64 // call attempts rejected in the current second
+	lastCount atomic.Int64 // call attempts allowed in the last complete second
+	tat       atomic.Int64 // token bucket: theoretical arrival time, in unix nanoseconds
+	calls     atomic.Int64 // dialogues in progress
+	saturated bool         // call attempts were rejected in the last complete second, ticker only
+}
+
+// take reports whether a call attempt is allowed by l. When it is not,
+// retryAfter is how long until one is, 0 when l allows none.
+func (b *bucket) take(l limit) (ok bool, retryAfter time.Duration) {
+	switch {
+	case l.rate == -1:
+		b.callCount.Add(1)
+		return true, 0
+	case l.rate == 0:
+	case l.mode == ModeTokenBucket:
+		if ok, retryAfter = b.takeToken(l); ok {
+			b.callCount.Add(1)
+		}
+	default:
+		ok, retryAfter = b.countInWindow(l)
+	}
+
+	if !ok {
+		b.rejected.Add(1)
+	}
+	return ok, retryAfter
+}
+
+// refund gives back a call attempt taken from l but rejected by another limit
+func (b *bucket) refund(l limit) {
+	b.callCount.Add(-1)
+	if l.mode == ModeTokenBucket && l.rate > 0 {
+		b.tat.Add(-int64(l.interval))
+	}
+}
+
+// countInWindow counts up to rate call attempts per second, the count being
+// reset every second by the ticker
+func (b *bucket) countInWindow(l limit) (bool, time.Duration) {
+	for {
+		n := b.callCount.Load()
+		if n >= int64(l.rate) {
+			return false, time.Second
+		}
+		if b.callCount.CompareAndSwap(n, n+1) {
+			return true, 0
+		}
+	}
+}
+
+// takeToken applies the generic cell rate algorithm: each call attempt moves
+// the theoretical arrival time one interval ahead, and is rejected when that
+// would put it more than a full bucket ahead of now
+func (b *bucket) takeToken(l limit) (bool, time.Duration) {
+	for {
+		now := time.Now().UnixNano()
+		tat := b.tat.Load()
+		next := max(tat, now) + int64(l.interval)
+		if ahead := time.Duration(next - now); ahead > l.tolerance {
+			return false, ahead - l.tolerance
+		}
+		if b.tat.CompareAndSwap(tat, next) {
+			return true, 0
+		}
+	}
+}
+
+// tick closes the current second, returning its allowed and rejected call attempts
+func (b *bucket) tick() (allowed, rejected int64) {
+	allowed = b.callCount.Swap(0)
+	rejected = b.rejected.Swap(0)
+	b.lastCount.Store(allowed)
+	return allowed, rejected
+}
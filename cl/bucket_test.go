@@ -0,0 +1,97 @@
+package cl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	l := newLimit(10, 5, ModeTokenBucket) // a token every 100ms, up to 5
+	var b bucket
+
+	for i := range 5 {
+		if ok, _ := b.take(l); !ok {
+			t.Fatalf("call attempt %d of the burst rejected", i+1)
+		}
+	}
+	ok, retryAfter := b.take(l)
+	if ok {
+		t.Fatal("call attempt beyond the burst allowed")
+	}
+	if retryAfter <= 0 || retryAfter > l.interval {
+		t.Errorf("retryAfter = %v, want within (0, %v]", retryAfter, l.interval)
+	}
+
+	time.Sleep(retryAfter)
+	if ok, _ := b.take(l); !ok {
+		t.Errorf("call attempt rejected after waiting retryAfter")
+	}
+	if ok, _ := b.take(l); ok {
+		t.Errorf("second call attempt allowed with a single token refilled")
+	}
+
+	if allowed, rejected := b.tick(); allowed != 6 || rejected != 2 {
+		t.Errorf("tick = (%d, %d), want (6, 2)", allowed, rejected)
+	}
+}
+
+func TestTokenBucketDefaultBurst(t *testing.T) {
+	l := newLimit(4, 0, ModeTokenBucket)
+	if l.tolerance != time.Second {
+		t.Errorf("tolerance = %v, want a second of tokens", l.tolerance)
+	}
+	var b bucket
+	for i := range 4 {
+		if ok, _ := b.take(l); !ok {
+			t.Fatalf("call attempt %d rejected", i+1)
+		}
+	}
+	if ok, _ := b.take(l); ok {
+		t.Error("fifth call attempt allowed at 4 per second")
+	}
+}
+
+func TestTokenBucketRefund(t *testing.T) {
+	l := newLimit(10, 1, ModeTokenBucket)
+	var b bucket
+
+	b.take(l)
+	b.refund(l)
+	if ok, _ := b.take(l); !ok {
+		t.Error("refunded token not available again")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	l := newLimit(3, 0, ModeWindow)
+	var b bucket
+
+	for i := range 3 {
+		if ok, _ := b.take(l); !ok {
+			t.Fatalf("call attempt %d rejected", i+1)
+		}
+	}
+	if ok, retryAfter := b.take(l); ok || retryAfter != time.Second {
+		t.Errorf("take = (%v, %v), want (false, 1s)", ok, retryAfter)
+	}
+
+	b.tick()
+	if ok, _ := b.take(l); !ok {
+		t.Error("call attempt rejected in a new second")
+	}
+	if got := b.lastCount.Load(); got != 3 {
+		t.Errorf("lastCount = %d, want 3", got)
+	}
+}
+
+func TestUnlimitedAndDisabled(t *testing.T) {
+	var b bucket
+	for _, mode := range []Mode{ModeWindow, ModeTokenBucket} {
+		if ok, _ := b.take(newLimit(-1, 0, mode)); !ok {
+			t.Errorf("%s: unlimited rate rejected a call attempt", mode)
+		}
+		if ok, retryAfter := b.take(newLimit(0, 0, mode)); ok || retryAfter != 0 {
+			t.Errorf("%s: rate 0 = (%v, %v), want (false, 0)", mode, ok, retryAfter)
+		}
+	}
+}
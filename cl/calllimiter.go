@@ -1,71 +1,292 @@
 package cl
 
 import (
-	"fmt"
+	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"siploadbalancer/events"
+	"siploadbalancer/logging"
 	"siploadbalancer/prometheus"
-)
 
-const (
-	reset  = "\033[0m"
-	red    = "\033[31m"
-	green  = "\033[32m"
-	yellow = "\033[33m"
-	blue   = "\033[34m"
-	purple = "\033[35m"
-	cyan   = "\033[36m"
-	white  = "\033[37m"
-	gray   = "\033[90m"
+	prom "github.com/prometheus/client_golang/prometheus"
 )
 
+var logger = logging.For("limiter")
+
+type RejectedEvent struct {
+	Quota    string `json:"quota,omitempty"` // none for the global cap
+	Rejected int    `json:"rejected"`
+	Rate     int    `json:"rate"`
+}
+
+// quota is the running state of a Quota, its bucket is kept across reloads
+type quota struct {
+	Quota
+	limit            limit
+	bucket           *bucket
+	accepted         prom.Counter
+	rejected         prom.Counter
+	calls            prom.Gauge
+	maxCallsRejected prom.Counter
+}
+
+// limits is swapped as a whole so that Admit never takes a lock
+type limits struct {
+	global   limit
+	settings Settings
+	quotas   []*quota
+}
+
 type CallLimiter struct {
-	rate      int          // rate limiter
+	limits atomic.Pointer[limits]
+	global bucket
+	calls  atomic.Int64 // dialogues in progress
+
+	defaultAccepted prom.Counter
+	defaultRejected prom.Counter
+	defaultCalls    prom.Gauge
+	defaultBucket   bucket // dialogues in progress matching no quota
+
+	defaultMaxCallsRejected prom.Counter
+
+	pm        *prometheus.Metrics
 	ticker    *time.Ticker // ticker for timing
-	callCount int          // current call count
-	mu        sync.Mutex   // mutex for thread safety
+	saturated bool         // calls were rejected in the last complete second
+	mu        sync.Mutex   // serializes changes of the limits
 }
 
-func NewCallLimiter(rate int, pm *prometheus.Metrics, wg *sync.WaitGroup) *CallLimiter {
+// Admission is the verdict on a call attempt
+type Admission struct {
+	OK         bool
+	RetryAfter time.Duration // until a call attempt would be allowed, 0 if none will
+	Key        string        // quota the call attempt counts against, DefaultKey for none
+	ByQuota    bool          // rejected by its quota rather than the global cap
+}
+
+func NewCallLimiter(rate int, st Settings, pm *prometheus.Metrics, wg *sync.WaitGroup) *CallLimiter {
 	cl := &CallLimiter{
-		rate:   rate,
-		ticker: time.NewTicker(time.Second),
+		pm:              pm,
+		ticker:          time.NewTicker(time.Second),
+		defaultAccepted: pm.LimiterAccepted.WithLabelValues(DefaultKey),
+		defaultRejected: pm.LimiterRejected.WithLabelValues(DefaultKey),
+		defaultCalls:    pm.LimiterActiveCalls.WithLabelValues(DefaultKey),
+
+		defaultMaxCallsRejected: pm.LimiterMaxCallsRejected.WithLabelValues(DefaultKey),
 	}
+	cl.limits.Store(cl.newLimits(rate, st, nil))
 	wg.Add(1)
 	go cl.resetCount(pm, wg)
 
-	fmt.Printf("Call Limiter set: %s\n", getCLstate(rate))
+	logRate(rate, st)
 	return cl
 }
 
-func getCLstate(rate int) string {
+// newLimits builds the limits of rate and st, reusing the buckets of the
+// quotas of old with the same name
+func (clmtr *CallLimiter) newLimits(rate int, st Settings, old *limits) *limits {
+	lm := &limits{
+		global:   newLimit(rate, st.Burst, st.Mode),
+		settings: st,
+		quotas:   make([]*quota, 0, len(st.Quotas)),
+	}
+
+	buckets := make(map[string]*bucket)
+	if old != nil {
+		for _, q := range old.quotas {
+			buckets[q.Name] = q.bucket
+		}
+	}
+	for _, q := range st.Quotas {
+		b := buckets[q.Name]
+		if b == nil {
+			b = new(bucket)
+		}
+		lm.quotas = append(lm.quotas, &quota{
+			Quota:    q,
+			limit:    newLimit(q.Rate, q.Burst, st.Mode),
+			bucket:   b,
+			accepted: clmtr.pm.LimiterAccepted.WithLabelValues(q.Name),
+			rejected: clmtr.pm.LimiterRejected.WithLabelValues(q.Name),
+			calls:    clmtr.pm.LimiterActiveCalls.WithLabelValues(q.Name),
+
+			maxCallsRejected: clmtr.pm.LimiterMaxCallsRejected.WithLabelValues(q.Name),
+		})
+	}
+	return lm
+}
+
+func logRate(rate int, st Settings) {
 	switch rate {
 	case -1:
-		return fmt.Sprintf("%s%d %s%s", yellow, rate, "(Unlimited CAPS)", reset)
+		logger.Info("Call Limiter set: unlimited CAPS", "rate", rate, "maxCalls", st.MaxCalls, "quotas", len(st.Quotas))
 	case 0:
-		return fmt.Sprintf("%s%d %s%s", red, rate, "(Server Disabled)", reset)
+		logger.Warn("Call Limiter set: server disabled", "rate", rate)
 	default:
-		return fmt.Sprintf("%s%d %s%s", green, rate, "CAPS", reset)
+		args := []any{"rate", rate, "mode", st.Mode, "rejectCode", st.RejectCode, "maxCalls", st.MaxCalls, "quotas", len(st.Quotas)}
+		if st.Mode == ModeTokenBucket {
+			args = append(args, "burst", burstOf(rate, st.Burst))
+		}
+		logger.Info("Call Limiter set", args...)
 	}
 }
 
 func (clmtr *CallLimiter) resetCount(pm *prometheus.Metrics, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for range clmtr.ticker.C {
-		clmtr.mu.Lock()
-		pm.Caps.Set(float64(clmtr.callCount))
-		clmtr.callCount = 0
-		clmtr.mu.Unlock()
+		lm := clmtr.limits.Load()
+		for _, q := range lm.quotas {
+			_, rejected := q.bucket.tick()
+			publishRejections(RejectedEvent{Quota: q.Name, Rejected: int(rejected), Rate: q.Rate}, &q.bucket.saturated)
+		}
+
+		callCount, rejected := clmtr.global.tick()
+		pm.Caps.Set(float64(callCount))
+		publishRejections(RejectedEvent{Rejected: int(rejected), Rate: lm.global.rate}, &clmtr.saturated)
+	}
+}
+
+// publishRejections publishes the call attempts a limit rejected during the
+// last second, and whether it started or stopped rejecting them
+func publishRejections(ev RejectedEvent, saturated *bool) {
+	if ev.Rejected > 0 {
+		events.Publish(events.LimiterRejected, ev)
+	}
+	if *saturated == (ev.Rejected > 0) {
+		return
+	}
+	*saturated = ev.Rejected > 0
+
+	args := []any{"rate", ev.Rate}
+	if ev.Quota != "" {
+		args = append(args, "quota", ev.Quota)
+	}
+	if *saturated {
+		logger.Warn("Call Limiter saturated", append(args, "rejected", ev.Rejected)...)
+		events.Publish(events.LimiterSaturated, ev)
+	} else {
+		logger.Info("Call Limiter recovered", args...)
+		events.Publish(events.LimiterRecovered, ev)
+	}
+}
+
+func (clmtr *CallLimiter) SetRate(rate int) {
+	clmtr.mu.Lock()
+	defer clmtr.mu.Unlock()
+
+	lm := clmtr.limits.Load()
+	if lm.global.rate != rate {
+		clmtr.limits.Store(clmtr.newLimits(rate, lm.settings, lm))
+		logRate(rate, lm.settings)
 	}
 }
 
-func (clmtr *CallLimiter) IsExceeded() bool {
+// SetSettings changes the mode, burst, rejection code and quotas, a new mode
+// starts with full buckets
+func (clmtr *CallLimiter) SetSettings(st Settings) {
 	clmtr.mu.Lock()
 	defer clmtr.mu.Unlock()
-	if clmtr.rate == -1 || clmtr.callCount < clmtr.rate { // it is not <= because i didn't add yet 1 to callCount
-		clmtr.callCount++
-		return false // Call can be attempted
+
+	lm := clmtr.limits.Load()
+	if lm.settings.Equal(st) {
+		return
+	}
+	if lm.settings.Mode != st.Mode {
+		clmtr.global.tat.Store(0)
+		for _, q := range lm.quotas {
+			q.bucket.tat.Store(0)
+		}
+	}
+	next := clmtr.newLimits(lm.global.rate, st, lm)
+	clmtr.limits.Store(next)
+	for _, q := range lm.quotas {
+		if !slices.ContainsFunc(next.quotas, func(x *quota) bool { return x.Name == q.Name }) {
+			clmtr.pm.DeleteQuota(q.Name)
+		}
+	}
+	logRate(lm.global.rate, st)
+}
+
+func (clmtr *CallLimiter) Rate() int {
+	return clmtr.limits.Load().global.rate
+}
+
+func (clmtr *CallLimiter) Settings() Settings {
+	return clmtr.limits.Load().settings
+}
+
+// RejectCode returns the SIP status code rejected call attempts get
+func (clmtr *CallLimiter) RejectCode() int {
+	return clmtr.limits.Load().settings.RejectCode
+}
+
+// Caps returns the call attempts allowed during the last complete second
+func (clmtr *CallLimiter) Caps() int {
+	return int(clmtr.global.lastCount.Load())
+}
+
+// Admit checks a call attempt from src with the given From domain against
+// the first quota it matches, then against the global cap
+func (clmtr *CallLimiter) Admit(src netip.Addr, fromDomain string) Admission {
+	lm := clmtr.limits.Load()
+	q := lm.match(src, fromDomain)
+	if q == nil {
+		ok, retryAfter := clmtr.global.take(lm.global)
+		if ok {
+			clmtr.defaultAccepted.Inc()
+		} else {
+			clmtr.defaultRejected.Inc()
+		}
+		return Admission{OK: ok, RetryAfter: retryAfter, Key: DefaultKey}
+	}
+
+	if ok, retryAfter := q.bucket.take(q.limit); !ok {
+		q.rejected.Inc()
+		return Admission{RetryAfter: retryAfter, Key: q.Name, ByQuota: true}
+	}
+	if ok, retryAfter := clmtr.global.take(lm.global); !ok {
+		q.bucket.refund(q.limit)
+		q.rejected.Inc()
+		return Admission{RetryAfter: retryAfter, Key: q.Name}
+	}
+	q.accepted.Inc()
+	return Admission{OK: true, Key: q.Name}
+}
+
+// match returns the first quota matching src or fromDomain, nil for none
+func (lm *limits) match(src netip.Addr, fromDomain string) *quota {
+	src = src.Unmap()
+	for _, q := range lm.quotas {
+		if q.matches(src, fromDomain) {
+			return q
+		}
+	}
+	return nil
+}
+
+// QuotaUsage is the use of a quota: its call attempts during the last
+// complete second and its dialogues in progress
+type QuotaUsage struct {
+	Name        string `json:"name"`
+	Rate        int    `json:"rate"`
+	Caps        int    `json:"caps"`
+	MaxCalls    int    `json:"maxCalls"`
+	ActiveCalls int    `json:"activeCalls"`
+}
+
+func (clmtr *CallLimiter) QuotaUsage() []QuotaUsage {
+	lm := clmtr.limits.Load()
+	usage := make([]QuotaUsage, 0, len(lm.quotas))
+	for _, q := range lm.quotas {
+		usage = append(usage, QuotaUsage{
+			Name:        q.Name,
+			Rate:        q.Rate,
+			Caps:        int(q.bucket.lastCount.Load()),
+			MaxCalls:    q.MaxCalls,
+			ActiveCalls: int(q.bucket.calls.Load()),
+		})
 	}
-	return true // Rate limit exceeded
+	return usage
 }
@@ -0,0 +1,119 @@
+package cl
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"siploadbalancer/events"
+	"siploadbalancer/prometheus"
+)
+
+func newTestLimiter(t *testing.T, rate int, st Settings) *CallLimiter {
+	st.SetDefaults()
+	if err := st.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	clmtr := NewCallLimiter(rate, st, prometheus.NewMetrics(), &wg)
+	t.Cleanup(clmtr.ticker.Stop)
+	return clmtr
+}
+
+func TestQuotaFirstMatch(t *testing.T) {
+	clmtr := newTestLimiter(t, -1, Settings{Quotas: []Quota{
+		{Name: "carrier", Sources: []string{"10.0.0.0/8"}, Rate: -1},
+		{Name: "tenant", Sources: []string{"10.1.0.0/16", "2001:db8::1"}, FromDomains: []string{"tenant.example"}, Rate: -1},
+		{Name: "domain", FromDomains: []string{"other.example"}, Rate: -1},
+	}})
+
+	tests := []struct {
+		src, fromDomain string
+		want            string
+	}{
+		{"10.1.2.3", "", "carrier"},                // listed before the narrower tenant prefix
+		{"10.1.2.3", "other.example", "carrier"},   // a source match does not beat an earlier quota
+		{"::ffff:10.1.2.3", "", "carrier"},         // IPv4-mapped
+		{"192.0.2.1", "TENANT.example", "tenant"},  // domains match case-insensitively
+		{"2001:db8::1", "other.example", "tenant"}, // tenant comes first
+		{"192.0.2.1", "other.example", "domain"},
+		{"192.0.2.1", "", DefaultKey},
+		{"192.0.2.1", "unknown.example", DefaultKey},
+	}
+	for _, tt := range tests {
+		if got := clmtr.Admit(netip.MustParseAddr(tt.src), tt.fromDomain); !got.OK || got.Key != tt.want {
+			t.Errorf("Admit(%s, %q) = %+v, want key %s", tt.src, tt.fromDomain, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaWithinGlobalCap(t *testing.T) {
+	clmtr := newTestLimiter(t, 3, Settings{Quotas: []Quota{
+		{Name: "tenant", Sources: []string{"10.1.0.0/16"}, Rate: 2},
+	}})
+	tenant, other := netip.MustParseAddr("10.1.0.1"), netip.MustParseAddr("192.0.2.1")
+
+	for i := range 2 {
+		if a := clmtr.Admit(tenant, ""); !a.OK {
+			t.Fatalf("tenant call attempt %d rejected: %+v", i+1, a)
+		}
+	}
+	if a := clmtr.Admit(tenant, ""); a.OK || !a.ByQuota || a.Key != "tenant" {
+		t.Errorf("third tenant call attempt = %+v, want rejected by its quota", a)
+	}
+
+	if a := clmtr.Admit(other, ""); !a.OK || a.Key != DefaultKey {
+		t.Fatalf("other call attempt = %+v, want allowed", a)
+	}
+	if a := clmtr.Admit(other, ""); a.OK || a.ByQuota {
+		t.Errorf("call attempt over the global cap = %+v, want rejected by the global cap", a)
+	}
+}
+
+func TestQuotaGlobalRejectionRefunds(t *testing.T) {
+	clmtr := newTestLimiter(t, 1, Settings{Quotas: []Quota{
+		{Name: "tenant", Sources: []string{"10.1.0.0/16"}, Rate: 1},
+	}})
+	clmtr.Admit(netip.MustParseAddr("192.0.2.1"), "") // uses up the global cap
+
+	tenant := netip.MustParseAddr("10.1.0.1")
+	if a := clmtr.Admit(tenant, ""); a.OK || a.ByQuota {
+		t.Fatalf("tenant call attempt = %+v, want rejected by the global cap", a)
+	}
+	if got := clmtr.limits.Load().quotas[0].bucket.callCount.Load(); got != 0 {
+		t.Errorf("tenant quota counted %d call attempts rejected by the global cap, want 0", got)
+	}
+}
+
+func TestQuotaRejectionEvents(t *testing.T) {
+	sub := events.Subscribe(16, events.LimiterRejected, events.LimiterSaturated, events.LimiterRecovered)
+	defer sub.Close()
+
+	clmtr := newTestLimiter(t, -1, Settings{Quotas: []Quota{
+		{Name: "tenant", Sources: []string{"10.1.0.0/16"}, Rate: 2},
+	}})
+	tenant := netip.MustParseAddr("10.1.0.1")
+	for range 3 {
+		clmtr.Admit(tenant, "")
+	}
+
+	want := []struct {
+		typ events.Type
+		ev  RejectedEvent
+	}{
+		{events.LimiterRejected, RejectedEvent{Quota: "tenant", Rejected: 1, Rate: 2}},
+		{events.LimiterSaturated, RejectedEvent{Quota: "tenant", Rejected: 1, Rate: 2}},
+		{events.LimiterRecovered, RejectedEvent{Quota: "tenant", Rate: 2}}, // a second later
+	}
+	for _, w := range want {
+		select {
+		case ev := <-sub.C:
+			if ev.Type != w.typ || ev.Data != w.ev {
+				t.Errorf("event %s %+v, want %s %+v", ev.Type, ev.Data, w.typ, w.ev)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no %s event", w.typ)
+		}
+	}
+}
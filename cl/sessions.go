@@ -0,0 +1,89 @@
+package cl
+
+import (
+	"net/netip"
+	"sync/atomic"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Session holds a dialogue's place within maxCalls and the maxCalls of its
+// quota, until released
+type Session struct {
+	global   *atomic.Int64
+	quota    *atomic.Int64
+	gauge    prom.Gauge
+	released atomic.Bool
+}
+
+// Reservation is the verdict on a new dialogue
+type Reservation struct {
+	Session *Session // nil when rejected
+	Key     string   // quota the dialogue counts against, DefaultKey for none
+	ByQuota bool     // rejected by its quota rather than the global maxCalls
+}
+
+// Reserve counts a new dialogue with the remote party addr and the given From
+// domain against the maxCalls of the first quota it matches, then against the
+// global maxCalls
+func (clmtr *CallLimiter) Reserve(addr netip.Addr, fromDomain string) Reservation {
+	lm := clmtr.limits.Load()
+	q := lm.match(addr, fromDomain)
+
+	calls, maxCalls, key := &clmtr.defaultBucket.calls, 0, DefaultKey
+	gauge, rejected := clmtr.defaultCalls, clmtr.defaultMaxCallsRejected
+	if q != nil {
+		calls, maxCalls, key = &q.bucket.calls, q.MaxCalls, q.Name
+		gauge, rejected = q.calls, q.maxCallsRejected
+	}
+
+	if !acquire(calls, maxCalls) {
+		rejected.Inc()
+		return Reservation{Key: key, ByQuota: true}
+	}
+	if !acquire(&clmtr.calls, lm.settings.MaxCalls) {
+		calls.Add(-1)
+		rejected.Inc()
+		return Reservation{Key: key}
+	}
+	gauge.Inc()
+	return Reservation{Session: &Session{global: &clmtr.calls, quota: calls, gauge: gauge}, Key: key}
+}
+
+// Release gives the place back once the dialogue ends, later calls do nothing
+func (s *Session) Release() {
+	if s == nil || !s.released.CompareAndSwap(false, true) {
+		return
+	}
+	s.quota.Add(-1)
+	s.global.Add(-1)
+	s.gauge.Dec()
+}
+
+// ActiveCalls returns the dialogues in progress
+func (clmtr *CallLimiter) ActiveCalls() int {
+	return int(clmtr.calls.Load())
+}
+
+// MaxCalls returns the simultaneous dialogues allowed, 0 for unlimited
+func (clmtr *CallLimiter) MaxCalls() int {
+	return clmtr.limits.Load().settings.MaxCalls
+}
+
+// MaxCallsRejectCode returns the SIP status code of dialogues over a limit
+func (clmtr *CallLimiter) MaxCallsRejectCode() int {
+	return clmtr.limits.Load().settings.MaxCallsRejectCode
+}
+
+// acquire counts one more in n unless it would exceed limit, 0 for unlimited
+func acquire(n *atomic.Int64, limit int) bool {
+	for {
+		cur := n.Load()
+		if limit > 0 && cur >= int64(limit) {
+			return false
+		}
+		if n.CompareAndSwap(cur, cur+1) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package cl
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReserve(t *testing.T) {
+	clmtr := newTestLimiter(t, -1, Settings{MaxCalls: 2, Quotas: []Quota{
+		{Name: "tenant", Sources: []string{"10.1.0.0/16"}, Rate: -1, MaxCalls: 1},
+	}})
+	tenant, other := netip.MustParseAddr("10.1.0.1"), netip.MustParseAddr("192.0.2.1")
+
+	first := clmtr.Reserve(tenant, "")
+	if first.Session == nil || first.Key != "tenant" {
+		t.Fatalf("first tenant dialogue = %+v, want reserved", first)
+	}
+	if rsv := clmtr.Reserve(tenant, ""); rsv.Session != nil || !rsv.ByQuota {
+		t.Errorf("second tenant dialogue = %+v, want rejected by its quota", rsv)
+	}
+	if rsv := clmtr.Reserve(other, ""); rsv.Session == nil || rsv.Key != DefaultKey {
+		t.Errorf("other dialogue = %+v, want reserved", rsv)
+	}
+	if rsv := clmtr.Reserve(other, ""); rsv.Session != nil || rsv.ByQuota {
+		t.Errorf("dialogue over the global maxCalls = %+v, want rejected by it", rsv)
+	}
+	if got := clmtr.ActiveCalls(); got != 2 {
+		t.Errorf("ActiveCalls = %d, want 2", got)
+	}
+
+	pm := clmtr.pm
+	for key, want := range map[string]float64{"tenant": 1, DefaultKey: 1} {
+		if got := testutil.ToFloat64(pm.LimiterMaxCallsRejected.WithLabelValues(key)); got != want {
+			t.Errorf("MaxCallsRejected{key=%s} = %v, want %v", key, got, want)
+		}
+		if got := testutil.ToFloat64(pm.LimiterRejected.WithLabelValues(key)); got != 0 {
+			t.Errorf("CallAttemptsRejected{key=%s} = %v, want 0: call attempts were not rate limited", key, got)
+		}
+	}
+	if got := testutil.ToFloat64(pm.LimiterActiveCalls.WithLabelValues("tenant")); got != 1 {
+		t.Errorf("QuotaActiveCalls{key=tenant} = %v, want 1", got)
+	}
+
+	first.Session.Release()
+	first.Session.Release() // once only
+	if got := clmtr.ActiveCalls(); got != 1 {
+		t.Errorf("ActiveCalls after release = %d, want 1", got)
+	}
+	if rsv := clmtr.Reserve(tenant, ""); rsv.Session == nil {
+		t.Errorf("tenant dialogue after release = %+v, want reserved", rsv)
+	}
+}
@@ -0,0 +1,139 @@
+package cl
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type Mode string
+
+const (
+	ModeWindow      Mode = "window"      // rate call attempts per one-second window
+	ModeTokenBucket Mode = "tokenBucket" // rate call attempts per second on average, with bursts of up to burst
+
+	DefaultRejectCode         = 480
+	DefaultMaxCallsRejectCode = 503
+	DefaultKey                = "default" // key of the call attempts matching no quota
+)
+
+// Settings tune how the call limiter enforces maxCallAttemptsPerSecond, and
+// limit the simultaneous dialogues
+type Settings struct {
+	Mode       Mode    `json:"mode"`       // (Default window)
+	Burst      int     `json:"burst"`      // token bucket size (0=Default the rate)
+	RejectCode int     `json:"rejectCode"` // status code of rejected call attempts, 503 carries Retry-After (Default 480)
+	Quotas     []Quota `json:"quotas,omitempty"`
+
+	MaxCalls           int `json:"maxCalls"`           // simultaneous dialogues (0=Default unlimited)
+	MaxCallsRejectCode int `json:"maxCallsRejectCode"` // status code of dialogues over a maxCalls or maxSessions (Default 503)
+}
+
+// Quota caps the call attempts coming from its sources or From domains,
+// within the global cap. A tenant is a quota listing all its trunks.
+type Quota struct {
+	Name        string   `json:"name"`
+	Sources     []string `json:"sources,omitempty"`     // addresses or CIDRs
+	FromDomains []string `json:"fromDomains,omitempty"` // hosts of the From URI
+	Rate        int      `json:"rate"`                  // call attempts per second, -1 unlimited
+	Burst       int      `json:"burst,omitempty"`       // token bucket size (0=Default the rate)
+	MaxCalls    int      `json:"maxCalls,omitempty"`    // simultaneous dialogues to and from it (0=Default unlimited)
+
+	prefixes []netip.Prefix
+}
+
+func (st *Settings) SetDefaults() {
+	if st.Mode == "" {
+		st.Mode = ModeWindow
+	}
+	if st.RejectCode == 0 {
+		st.RejectCode = DefaultRejectCode
+	}
+	if st.MaxCallsRejectCode == 0 {
+		st.MaxCallsRejectCode = DefaultMaxCallsRejectCode
+	}
+}
+
+func (st *Settings) Validate() error {
+	switch {
+	case st.Mode != ModeWindow && st.Mode != ModeTokenBucket:
+		return fmt.Errorf("callLimiter.mode [%s] must be %s or %s", st.Mode, ModeWindow, ModeTokenBucket)
+	case st.Burst < 0:
+		return fmt.Errorf("callLimiter.burst [%d] is invalid", st.Burst)
+	case st.RejectCode < 400 || st.RejectCode > 699:
+		return fmt.Errorf("callLimiter.rejectCode [%d] must be a 4xx, 5xx or 6xx status code", st.RejectCode)
+	case st.MaxCalls < 0:
+		return fmt.Errorf("callLimiter.maxCalls [%d] is invalid", st.MaxCalls)
+	case st.MaxCallsRejectCode < 400 || st.MaxCallsRejectCode > 699:
+		return fmt.Errorf("callLimiter.maxCallsRejectCode [%d] must be a 4xx, 5xx or 6xx status code", st.MaxCallsRejectCode)
+	}
+
+	for i := range st.Quotas {
+		q := &st.Quotas[i]
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("callLimiter.quotas[%d]: %w", i, err)
+		}
+		if slices.ContainsFunc(st.Quotas[:i], func(x Quota) bool { return strings.EqualFold(x.Name, q.Name) }) {
+			return fmt.Errorf("callLimiter.quotas[%d]: duplicate quota [%s]", i, q.Name)
+		}
+	}
+	return nil
+}
+
+// Equal reports whether st and other configure the limiter alike
+func (st Settings) Equal(other Settings) bool {
+	return st.Mode == other.Mode && st.Burst == other.Burst && st.RejectCode == other.RejectCode &&
+		st.MaxCalls == other.MaxCalls && st.MaxCallsRejectCode == other.MaxCallsRejectCode &&
+		slices.EqualFunc(st.Quotas, other.Quotas, func(a, b Quota) bool {
+			return a.Name == b.Name && a.Rate == b.Rate && a.Burst == b.Burst && a.MaxCalls == b.MaxCalls &&
+				slices.Equal(a.Sources, b.Sources) && slices.Equal(a.FromDomains, b.FromDomains)
+		})
+}
+
+func (q *Quota) validate() error {
+	q.Name = strings.TrimSpace(q.Name)
+	switch {
+	case q.Name == "" || strings.EqualFold(q.Name, DefaultKey):
+		return fmt.Errorf("name [%s] is invalid", q.Name)
+	case len(q.Sources) == 0 && len(q.FromDomains) == 0:
+		return fmt.Errorf("quota [%s] needs sources or fromDomains", q.Name)
+	case q.Rate < -1:
+		return fmt.Errorf("quota [%s]: rate [%d] is invalid", q.Name, q.Rate)
+	case q.Burst < 0:
+		return fmt.Errorf("quota [%s]: burst [%d] is invalid", q.Name, q.Burst)
+	case q.MaxCalls < 0:
+		return fmt.Errorf("quota [%s]: maxCalls [%d] is invalid", q.Name, q.MaxCalls)
+	}
+
+	q.prefixes = make([]netip.Prefix, 0, len(q.Sources))
+	for _, src := range q.Sources {
+		prefix, err := parsePrefix(src)
+		if err != nil {
+			return fmt.Errorf("quota [%s]: source [%s] is invalid", q.Name, src)
+		}
+		q.prefixes = append(q.prefixes, prefix)
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (q *Quota) matches(src netip.Addr, fromDomain string) bool {
+	if slices.ContainsFunc(q.prefixes, func(p netip.Prefix) bool { return p.Contains(src) }) {
+		return true
+	}
+	return fromDomain != "" && slices.ContainsFunc(q.FromDomains, func(d string) bool { return strings.EqualFold(d, fromDomain) })
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Type string
+
+const (
+	NodeUp           Type = "node.up"           // a SIP server answered its probe
+	NodeDown         Type = "node.down"         // a SIP server stopped answering its probe
+	NodeState        Type = "node.state"        // a SIP server was enabled, disabled, drained
+	NodesDown        Type = "nodes.down"        // the last alive SIP server went down
+	NodeDegraded     Type = "node.degraded"     // a SIP server gets fewer dialogues for its poor ASR or failure ratio
+	NodeRestored     Type = "node.restored"     // a degraded SIP server gets its full share again
+	CallStart        Type = "call.start"        // an INVITE dialogue was created
+	CallAnswer       Type = "call.answer"       // an INVITE dialogue was answered
+	CallEnd          Type = "call.end"          // an INVITE dialogue ended, whatever the reason
+	LimiterRejected  Type = "limiter.rejected"  // call attempts rejected by the call limiter, once per second
+	LimiterSaturated Type = "limiter.saturated" // the call limiter started rejecting call attempts
+	LimiterRecovered Type = "limiter.recovered" // the call limiter stopped rejecting call attempts
+	ConfigReloaded   Type = "config.reloaded"   // a new configuration was applied
+	ConfigRejected   Type = "config.rejected"   // a new configuration was invalid and ignored
+)
+
+var Types = []Type{NodeUp, NodeDown, NodeState, NodesDown, NodeDegraded, NodeRestored, CallStart, CallAnswer, CallEnd,
+	LimiterRejected, LimiterSaturated, LimiterRecovered, ConfigReloaded, ConfigRejected}
+
+const DefaultBuffer = 256
+
+type (
+	Event struct {
+		ID   uint64    `json:"id"`
+		Type Type      `json:"type"`
+		Time time.Time `json:"time"`
+		Data any       `json:"data,omitempty"`
+	}
+
+	// Subscription receives published events on C. Events are dropped, not
+	// queued, while the subscriber is not keeping up.
+	Subscription struct {
+		C       <-chan Event
+		ch      chan Event
+		types   []Type
+		dropped atomic.Uint64
+	}
+)
+
+var (
+	subscriptions = make(map[*Subscription]struct{})
+	mu            sync.RWMutex
+	lastID        atomic.Uint64
+)
+
+// Subscribe returns a subscription to the given event types, all when none are given
+func Subscribe(buffer int, types ...Type) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, ch: ch, types: types}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	subscriptions[sub] = struct{}{}
+	return sub
+}
+
+func (sub *Subscription) Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := subscriptions[sub]; ok {
+		delete(subscriptions, sub)
+		close(sub.ch)
+	}
+}
+
+// Dropped returns how many events were lost because the subscriber was too slow
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+func (t Type) IsValid() bool {
+	return slices.Contains(Types, t)
+}
+
+func (sub *Subscription) wants(t Type) bool {
+	return len(sub.types) == 0 || slices.Contains(sub.types, t)
+}
+
+// Publish never blocks the publisher
+func Publish(t Type, data any) {
+	ev := Event{ID: lastID.Add(1), Type: t, Time: time.Now().UTC(), Data: data}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for sub := range subscriptions {
+		if !sub.wants(t) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
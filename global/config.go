@@ -0,0 +1,210 @@
+package global
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"siploadbalancer/events"
+	"siploadbalancer/logging"
+)
+
+// ConfigReloader validates a new configuration and returns a function that
+// applies it. It must not change any running state before apply is called.
+type ConfigReloader func(data []byte) (apply func(), err error)
+
+type ConfigEvent struct {
+	Source string `json:"source"` // file, sighup or api
+	Error  string `json:"error,omitempty"`
+}
+
+type namedReloader struct {
+	name string
+	fn   ConfigReloader
+}
+
+const ConfigWatchInterval = 2 * time.Second
+
+var (
+	ConfigPath string
+
+	logger = logging.For("config")
+
+	reloaders []namedReloader
+	reloadMu  sync.Mutex
+
+	configModTime time.Time // last known modification time of ConfigPath
+	modTimeMu     sync.Mutex
+)
+
+func RegisterReloader(name string, fn ConfigReloader) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	reloaders = append(reloaders, namedReloader{name: name, fn: fn})
+}
+
+// ReloadConfig applies data only if every registered reloader accepts it,
+// otherwise the running configuration is kept as is.
+func ReloadConfig(data []byte) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	applies := make([]func(), 0, len(reloaders))
+	for _, rl := range reloaders {
+		apply, err := rl.fn(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rl.name, err)
+		}
+		if apply != nil {
+			applies = append(applies, apply)
+		}
+	}
+
+	for _, apply := range applies {
+		apply()
+	}
+
+	return nil
+}
+
+func ReloadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ReloadConfig(data)
+}
+
+// WatchConfig reloads the configuration file whenever its modification time
+// changes or the process receives SIGHUP.
+func WatchConfig(path string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	setConfigModTime(FileModTime(path))
+	ticker := time.NewTicker(ConfigWatchInterval)
+
+	WtGrp.Add(1)
+	go func() {
+		defer WtGrp.Done()
+		for {
+			var source string
+			select {
+			case <-sighup:
+				source = "sighup"
+				logger.Info("SIGHUP received - Reloading configuration")
+			case <-ticker.C:
+				if !configFileChanged(path) {
+					continue
+				}
+				source = "file"
+				logger.Info("Configuration file changed - Reloading configuration")
+			}
+
+			setConfigModTime(FileModTime(path))
+			if err := ReloadConfigFile(path); err != nil {
+				logger.Error("Configuration rejected, keeping running configuration", "source", source, logging.Err(err))
+				events.Publish(events.ConfigRejected, ConfigEvent{Source: source, Error: err.Error()})
+				continue
+			}
+			logger.Info("Configuration reloaded successfully", "source", source)
+			events.Publish(events.ConfigReloaded, ConfigEvent{Source: source})
+		}
+	}()
+}
+
+func setConfigModTime(mt time.Time) {
+	modTimeMu.Lock()
+	defer modTimeMu.Unlock()
+
+	configModTime = mt
+}
+
+func configFileChanged(path string) bool {
+	modTimeMu.Lock()
+	defer modTimeMu.Unlock()
+
+	return !FileModTime(path).Equal(configModTime)
+}
+
+// FileModTime returns the modification time of path, zero if it cannot be read
+func FileModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// PersistConfig merges the top-level keys of v into the configuration file
+// and replaces it atomically, leaving keys owned by other subsystems intact.
+func PersistConfig(v any) error {
+	if ConfigPath == "" {
+		return fmt.Errorf("no configuration file to persist to")
+	}
+
+	patch, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var patchMap map[string]json.RawMessage
+	if err = json.Unmarshal(patch, &patchMap); err != nil {
+		return err
+	}
+
+	fileMap := make(map[string]json.RawMessage)
+	if data, err := os.ReadFile(ConfigPath); err == nil {
+		if err = json.Unmarshal(data, &fileMap); err != nil {
+			return fmt.Errorf("existing configuration file is invalid: %w", err)
+		}
+	}
+	for k, v := range patchMap {
+		fileMap[k] = v
+	}
+
+	data, err := json.MarshalIndent(fileMap, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	modTimeMu.Lock()
+	defer modTimeMu.Unlock()
+
+	if err = writeFileAtomic(ConfigPath, data); err != nil {
+		return err
+	}
+	// our own write must not be picked up as an external change
+	configModTime = FileModTime(ConfigPath)
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		_ = os.Chmod(tmp.Name(), fi.Mode())
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
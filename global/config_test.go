@@ -0,0 +1,80 @@
+package global
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistConfigKeepsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte(`{"hep": {"enabled": true}, "custom": [1, 2], "servers": [{"ipv4": "192.0.2.1"}], "hitWindow": 60}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prev := ConfigPath
+	ConfigPath = path
+	t.Cleanup(func() { ConfigPath = prev })
+
+	patch := struct {
+		Servers   []string `json:"servers"`
+		HitWindow int      `json:"hitWindow"`
+	}{Servers: []string{}, HitWindow: 120}
+	if err := PersistConfig(patch); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("persisted file %s: %v", data, err)
+	}
+	for key, want := range map[string]string{
+		"hep":       `{"enabled":true}`,
+		"custom":    `[1,2]`,
+		"servers":   `[]`,
+		"hitWindow": `120`,
+	} {
+		if compact(got[key]) != want {
+			t.Errorf("%s = %s, want %s", key, got[key], want)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("persisted keys = %d, want 4", len(got))
+	}
+	if configFileChanged(path) {
+		t.Error("own write seen as an external change")
+	}
+	if leftovers, _ := filepath.Glob(path + ".tmp*"); len(leftovers) > 0 {
+		t.Errorf("temporary files left: %v", leftovers)
+	}
+}
+
+func TestPersistConfigInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte(`{"servers": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prev := ConfigPath
+	ConfigPath = path
+	t.Cleanup(func() { ConfigPath = prev })
+
+	if err := PersistConfig(map[string]int{"hitWindow": 1}); err == nil {
+		t.Error("invalid configuration file overwritten")
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"servers": [` {
+		t.Errorf("file = %s, want it untouched", data)
+	}
+}
+
+func compact(raw json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -99,4 +99,6 @@ const (
 	Max_Forwards   Header = "Max-Forwards"
 	Contact        Header = "Contact"
 	User_Agent     Header = "User-Agent"
+	Reason         Header = "Reason"
+	Retry_After    Header = "Retry-After"
 )
@@ -2,7 +2,6 @@ package global
 
 import (
 	"bytes"
-	"log"
 	"math/rand/v2"
 	"net"
 	"runtime"
@@ -135,6 +134,35 @@ func GetNextIndex(pdu []byte, markstrng string) int {
 	return bytes.Index(pdu, []byte(markstrng))
 }
 
+// PeekCallID reads the Call-ID and the CSeq method of the first SIP message
+// in a datagram without parsing it
+func PeekCallID(data []byte) (callID, method string) {
+	for len(data) > 0 {
+		var line []byte
+		line, data, _ = bytes.Cut(data, []byte("\n"))
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			break // end of headers
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(string(bytes.TrimSpace(name))) {
+		case "call-id", "i":
+			callID = string(bytes.TrimSpace(value))
+		case "cseq":
+			if fields := bytes.Fields(value); len(fields) == 2 {
+				method = strings.ToUpper(string(fields[1]))
+			}
+		}
+		if callID != "" && method != "" {
+			break
+		}
+	}
+	return
+}
+
 func BuildSipUdpSocket(host, port string) (*net.UDPAddr, error) {
 	if port == "" {
 		return net.ResolveUDPAddr("udp", host+":5060")
@@ -147,10 +175,9 @@ func BuildUdpSocket(udpskt string) (*net.UDPAddr, error) {
 }
 
 func LogCallStack(r any) {
-	log.Printf("Panic Recovered! Error:\n%v", r)
 	buf := make([]byte, 1024)
 	n := runtime.Stack(buf, false)
-	log.Printf("Stack trace:\n%s\n", buf[:n])
+	logger.Error("Panic recovered", "panic", r, "stack", string(buf[:n]))
 }
 
 func RandomNumMinMax(min int, max int) int {
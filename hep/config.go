@@ -0,0 +1,55 @@
+package hep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+
+	DefaultCaptureID = 2001
+)
+
+type Config struct {
+	Enabled   bool   `json:"enabled"`
+	Address   string `json:"address"`   // collector host:port
+	Transport string `json:"transport"` // udp or tcp
+	CaptureID uint32 `json:"captureId"` // identifies the balancer to the collector
+	AuthKey   string `json:"authKey"`   // optional
+}
+
+func parseConfig(data []byte) (Config, error) {
+	var in struct {
+		HEP Config `json:"hep"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.HEP, err
+	}
+	in.HEP.setDefaults()
+	return in.HEP, in.HEP.validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Transport == "" {
+		c.Transport = TransportUDP
+	}
+	if c.CaptureID == 0 {
+		c.CaptureID = DefaultCaptureID
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Transport != TransportUDP && c.Transport != TransportTCP {
+		return fmt.Errorf("hep.transport [%s] is unknown", c.Transport)
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("hep.address [%s] is invalid: %w", c.Address, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package hep
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// HEP v3 chunk types, all with the generic vendor id 0
+const (
+	chunkIPFamily      = 0x0001
+	chunkIPProtocol    = 0x0002
+	chunkIPv4Src       = 0x0003
+	chunkIPv4Dst       = 0x0004
+	chunkIPv6Src       = 0x0005
+	chunkIPv6Dst       = 0x0006
+	chunkSrcPort       = 0x0007
+	chunkDstPort       = 0x0008
+	chunkTimeSeconds   = 0x0009
+	chunkTimeMicros    = 0x000a
+	chunkProtocolType  = 0x000b
+	chunkCaptureID     = 0x000c
+	chunkAuthKey       = 0x000e
+	chunkPayload       = 0x000f
+	chunkCorrelationID = 0x0011
+
+	familyIPv4   = 2
+	familyIPv6   = 10
+	protocolUDP  = 17
+	protocolSIP  = 1
+	headerLength = 6 // HEP3 and the total length
+	chunkHeader  = 6 // vendor, type and length
+)
+
+// encode builds the HEP v3 packet of a SIP datagram
+func encode(t time.Time, src, dst netip.AddrPort, payload []byte, callID string, cfg *Config) []byte {
+	b := make([]byte, headerLength, headerLength+128+len(callID)+len(cfg.AuthKey)+len(payload))
+	copy(b, "HEP3")
+
+	if src.Addr().Is4() && dst.Addr().Is4() {
+		b = appendUint8(b, chunkIPFamily, familyIPv4)
+		b = appendChunk(b, chunkIPv4Src, src.Addr().AsSlice())
+		b = appendChunk(b, chunkIPv4Dst, dst.Addr().AsSlice())
+	} else {
+		srcIP, dstIP := src.Addr().As16(), dst.Addr().As16()
+		b = appendUint8(b, chunkIPFamily, familyIPv6)
+		b = appendChunk(b, chunkIPv6Src, srcIP[:])
+		b = appendChunk(b, chunkIPv6Dst, dstIP[:])
+	}
+	b = appendUint8(b, chunkIPProtocol, protocolUDP)
+	b = appendUint16(b, chunkSrcPort, src.Port())
+	b = appendUint16(b, chunkDstPort, dst.Port())
+	b = appendUint32(b, chunkTimeSeconds, uint32(t.Unix()))
+	b = appendUint32(b, chunkTimeMicros, uint32(t.Nanosecond()/1000))
+	b = appendUint8(b, chunkProtocolType, protocolSIP)
+	b = appendUint32(b, chunkCaptureID, cfg.CaptureID)
+	if cfg.AuthKey != "" {
+		b = appendChunk(b, chunkAuthKey, []byte(cfg.AuthKey))
+	}
+	if callID != "" {
+		b = appendChunk(b, chunkCorrelationID, []byte(callID))
+	}
+	b = appendChunk(b, chunkPayload, payload)
+
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b
+}
+
+func appendChunk(b []byte, typ uint16, value []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(chunkHeader+len(value)))
+	return append(b, value...)
+}
+
+func appendUint8(b []byte, typ uint16, v uint8) []byte {
+	return appendChunk(b, typ, []byte{v})
+}
+
+func appendUint16(b []byte, typ uint16, v uint16) []byte {
+	return appendChunk(b, typ, binary.BigEndian.AppendUint16(nil, v))
+}
+
+func appendUint32(b []byte, typ uint16, v uint32) []byte {
+	return appendChunk(b, typ, binary.BigEndian.AppendUint32(nil, v))
+}
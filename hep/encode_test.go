@@ -0,0 +1,97 @@
+package hep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// decodeChunks checks the HEP3 header and returns the chunk values by type
+func decodeChunks(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+	if len(b) < headerLength || string(b[:4]) != "HEP3" {
+		t.Fatalf("no HEP3 header in % x", b)
+	}
+	if total := int(binary.BigEndian.Uint16(b[4:])); total != len(b) {
+		t.Fatalf("total length %d, packet is %d bytes", total, len(b))
+	}
+
+	chunks := make(map[uint16][]byte)
+	for rest := b[headerLength:]; len(rest) > 0; {
+		if len(rest) < chunkHeader {
+			t.Fatalf("truncated chunk header % x", rest)
+		}
+		vendor, typ, length := binary.BigEndian.Uint16(rest), binary.BigEndian.Uint16(rest[2:]), int(binary.BigEndian.Uint16(rest[4:]))
+		if vendor != 0 || length < chunkHeader || length > len(rest) {
+			t.Fatalf("chunk 0x%04x: vendor %d, length %d with %d bytes left", typ, vendor, length, len(rest))
+		}
+		if _, dup := chunks[typ]; dup {
+			t.Fatalf("chunk 0x%04x repeated", typ)
+		}
+		chunks[typ] = rest[chunkHeader:length]
+		rest = rest[length:]
+	}
+	return chunks
+}
+
+func TestEncode(t *testing.T) {
+	at := time.Unix(1700000000, 123456789)
+	payload := []byte("INVITE sip:100@example.com SIP/2.0\r\n\r\n")
+
+	tests := []struct {
+		name               string
+		src, dst           string
+		callID             string
+		authKey            string
+		family             byte
+		srcChunk, dstChunk uint16
+	}{
+		{"ipv4", "192.0.2.1:5060", "198.51.100.7:5080", "a84b4c76e66710", "", familyIPv4, chunkIPv4Src, chunkIPv4Dst},
+		{"ipv6", "[2001:db8::1]:5060", "[2001:db8::2]:5062", "", "k3y", familyIPv6, chunkIPv6Src, chunkIPv6Dst},
+		{"mixed", "192.0.2.1:5060", "[2001:db8::2]:5062", "id", "", familyIPv6, chunkIPv6Src, chunkIPv6Dst},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := netip.MustParseAddrPort(tt.src), netip.MustParseAddrPort(tt.dst)
+			cfg := &Config{CaptureID: 2001, AuthKey: tt.authKey}
+			chunks := decodeChunks(t, encode(at, src, dst, payload, tt.callID, cfg))
+
+			want := map[uint16][]byte{
+				chunkIPFamily:     {tt.family},
+				chunkIPProtocol:   {protocolUDP},
+				chunkSrcPort:      binary.BigEndian.AppendUint16(nil, src.Port()),
+				chunkDstPort:      binary.BigEndian.AppendUint16(nil, dst.Port()),
+				chunkTimeSeconds:  binary.BigEndian.AppendUint32(nil, 1700000000),
+				chunkTimeMicros:   binary.BigEndian.AppendUint32(nil, 123456),
+				chunkProtocolType: {protocolSIP},
+				chunkCaptureID:    binary.BigEndian.AppendUint32(nil, 2001),
+				chunkPayload:      payload,
+			}
+			if tt.family == familyIPv4 {
+				want[tt.srcChunk], want[tt.dstChunk] = src.Addr().AsSlice(), dst.Addr().AsSlice()
+			} else {
+				srcIP, dstIP := src.Addr().As16(), dst.Addr().As16()
+				want[tt.srcChunk], want[tt.dstChunk] = srcIP[:], dstIP[:]
+			}
+			if tt.authKey != "" {
+				want[chunkAuthKey] = []byte(tt.authKey)
+			}
+			if tt.callID != "" {
+				want[chunkCorrelationID] = []byte(tt.callID)
+			}
+
+			for typ, value := range want {
+				if got, ok := chunks[typ]; !ok || !bytes.Equal(got, value) {
+					t.Errorf("chunk 0x%04x = % x, want % x", typ, got, value)
+				}
+			}
+			for typ := range chunks {
+				if _, ok := want[typ]; !ok {
+					t.Errorf("unexpected chunk 0x%04x", typ)
+				}
+			}
+		})
+	}
+}
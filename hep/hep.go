@@ -0,0 +1,162 @@
+package hep
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+const (
+	QueueSize   = 10000
+	DialTimeout = 2 * time.Second
+	RedialDelay = 5 * time.Second
+	maxPacket   = 65535
+)
+
+type packet struct {
+	time     time.Time
+	src, dst netip.AddrPort
+	data     []byte
+}
+
+var (
+	logger = logging.For("hep")
+
+	queue   = make(chan packet, QueueSize)
+	enabled atomic.Bool
+
+	config   Config
+	conn     net.Conn
+	nextDial time.Time // dialling is paused after a failure
+	connMu   sync.Mutex
+)
+
+// Start mirrors SIP datagrams to the collector configured in data
+func Start(data []byte) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid hep configuration", logging.Err(err))
+	}
+	setConfig(cfg)
+	RegisterReloader("hep", reloadConfig)
+
+	go run()
+}
+
+func reloadConfig(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { setConfig(cfg) }, nil
+}
+
+func setConfig(cfg Config) {
+	connMu.Lock()
+	defer connMu.Unlock()
+
+	if cfg == config {
+		return
+	}
+	closeConn()
+	config = cfg
+	nextDial = time.Time{}
+	enabled.Store(cfg.Enabled)
+	if cfg.Enabled {
+		logger.Info("Mirroring SIP to HEP collector", "address", cfg.Address, "transport", cfg.Transport, "captureId", cfg.CaptureID)
+	}
+}
+
+// closeConn must be called while holding connMu
+func closeConn() {
+	if conn != nil {
+		conn.Close()
+		conn = nil
+	}
+}
+
+// Active reports whether datagrams are mirrored, they need not be passed to
+// Received and Sent otherwise
+func Active() bool {
+	return enabled.Load()
+}
+
+// Received queues a datagram read from the SIP socket
+func Received(src, local *net.UDPAddr, data []byte) {
+	enqueue(src, local, data)
+}
+
+// Sent queues a datagram written to the SIP socket
+func Sent(local, dst *net.UDPAddr, data []byte) {
+	enqueue(local, dst, data)
+}
+
+// enqueue copies data, which may belong to a pooled buffer, without ever blocking
+func enqueue(src, dst *net.UDPAddr, data []byte) {
+	p := packet{time: time.Now(), src: addrPort(src), dst: addrPort(dst), data: slices.Clone(data)}
+	select {
+	case queue <- p:
+	default:
+		Prometrics.DroppedHEPPackets.Inc()
+	}
+}
+
+func addrPort(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+func run() {
+	for p := range queue {
+		send(p)
+	}
+}
+
+func send(p packet) {
+	connMu.Lock()
+	defer connMu.Unlock()
+
+	if !config.Enabled {
+		return
+	}
+	callID, _ := PeekCallID(p.data)
+	msg := encode(p.time, p.src, p.dst, p.data, callID, &config)
+	if len(msg) > maxPacket || !dial() {
+		Prometrics.DroppedHEPPackets.Inc()
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(DialTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		logger.Warn("Failed to send to HEP collector", "address", config.Address, logging.Err(err))
+		closeConn()
+		Prometrics.DroppedHEPPackets.Inc()
+		return
+	}
+	Prometrics.SentHEPPackets.Inc()
+}
+
+// dial connects to the collector unless connected already, must be called
+// while holding connMu
+func dial() bool {
+	if conn != nil {
+		return true
+	}
+	if time.Now().Before(nextDial) {
+		return false
+	}
+	c, err := net.DialTimeout(config.Transport, config.Address, DialTimeout)
+	if err != nil {
+		logger.Warn("Failed to connect to HEP collector", "address", config.Address, logging.Err(err))
+		nextDial = time.Now().Add(RedialDelay)
+		return false
+	}
+	conn = c
+	return true
+}
@@ -0,0 +1,51 @@
+package hep
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	Prometrics = prometheus.NewMetrics()
+	os.Exit(m.Run())
+}
+
+func TestSendUDP(t *testing.T) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	setConfig(Config{Enabled: true, Address: collector.LocalAddr().String(), Transport: TransportUDP, CaptureID: 7})
+	t.Cleanup(func() { setConfig(Config{}) })
+
+	payload := []byte("OPTIONS sip:probe@192.0.2.9 SIP/2.0\r\nCall-ID: probe-1\r\n\r\n")
+	src, dst := netip.MustParseAddrPort("192.0.2.1:5060"), netip.MustParseAddrPort("192.0.2.9:5060")
+	send(packet{time: time.Now(), src: src, dst: dst, data: payload})
+
+	buf := make([]byte, maxPacket)
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := collector.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := decodeChunks(t, buf[:n])
+	if !bytes.Equal(chunks[chunkPayload], payload) {
+		t.Errorf("payload = %q", chunks[chunkPayload])
+	}
+	if got := string(chunks[chunkCorrelationID]); got != "probe-1" {
+		t.Errorf("correlation id = %q, want the Call-ID", got)
+	}
+	if !bytes.Equal(chunks[chunkIPv4Src], src.Addr().AsSlice()) || !bytes.Equal(chunks[chunkIPv4Dst], dst.Addr().AsSlice()) {
+		t.Errorf("addresses = % x -> % x", chunks[chunkIPv4Src], chunks[chunkIPv4Dst])
+	}
+}
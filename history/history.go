@@ -0,0 +1,274 @@
+package history
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+const (
+	SecondSlots = 86400 // one day of per-second values
+	MinuteSlots = 1440  // one day of per-minute averages
+	MaxPoints   = 3600  // values per series in a query
+
+	idleExpiry = 24 * time.Hour // counter series without events are dropped after
+)
+
+type Metric string
+
+const (
+	CAPS     Metric = "caps"     // call attempts accepted per second
+	Sessions Metric = "sessions" // dialogues in the cache
+	Rejects  Metric = "rejects"  // requests rejected by the balancer per second, by cause
+	Hits     Metric = "hits"     // dialogues routed per second, by server
+)
+
+var Metrics = []Metric{CAPS, Sessions, Rejects, Hits}
+
+var ErrUnknownMetric = fmt.Errorf("metric must be one of %v", Metrics)
+
+type key struct {
+	metric Metric
+	label  string
+}
+
+// series keeps the values of a metric, counters are accumulated in pending
+// and gauges sampled from sample at every tick. Everything but pending and
+// removed must be accessed while holding mu.
+type series struct {
+	seconds  ring
+	minutes  ring
+	pending  atomic.Int64
+	removed  atomic.Bool // dropped by tick, Inc must count in a new series
+	sample   func() float64
+	lastSeen time.Time
+}
+
+var (
+	all      sync.Map // key to *series, read by Inc without locking
+	started  time.Time
+	lastTick int64 // unix second of the latest value written
+	ticker   *time.Ticker
+	done     chan struct{}
+	mu       sync.Mutex
+)
+
+// Start samples the series every second from a background goroutine, until Stop
+func Start() {
+	mu.Lock()
+	started = time.Now().Truncate(time.Second)
+	lastTick = started.Unix() - 1
+	ticker, done = time.NewTicker(time.Second), make(chan struct{})
+	tc, stop := ticker.C, done
+	mu.Unlock()
+
+	WtGrp.Add(1)
+	go func() {
+		defer WtGrp.Done()
+		for {
+			select {
+			case now := <-tc:
+				tick(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the sampling, the history can still be queried
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if ticker == nil {
+		return
+	}
+	ticker.Stop()
+	close(done)
+	ticker = nil
+}
+
+// Inc counts an event of a counter metric in the current second, it only
+// locks to create the series
+func Inc(metric Metric, label string) {
+	k := key{metric, label}
+	v, ok := all.Load(k)
+	if !ok {
+		v = counter(k)
+	}
+	for s := v.(*series); ; s = counter(k) {
+		s.pending.Add(1)
+		if !s.removed.Load() {
+			return
+		}
+		// tick is dropping s: take the event back, counter waits for the outcome
+		s.pending.Add(-1)
+	}
+}
+
+// counter returns the live series of k, created if missing
+func counter(k key) *series {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if v, ok := all.Load(k); ok && !v.(*series).removed.Load() {
+		return v.(*series)
+	}
+	s := newSeries()
+	all.Store(k, s)
+	return s
+}
+
+// Sample registers fn as the source of a gauge metric, read every second
+func Sample(metric Metric, fn func() float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := newSeries()
+	s.sample = fn
+	all.Store(key{metric, ""}, s)
+}
+
+// newSeries returns a series holding zeros up to now, must be called while holding mu
+func newSeries() *series {
+	return &series{
+		seconds:  newRing(SecondSlots, lastTick),
+		minutes:  newRing(MinuteSlots, (lastTick+1)/60-1),
+		lastSeen: time.Now(),
+	}
+}
+
+// tick writes the values of the second completed before now, and the
+// averages of the minute it completes
+func tick(now time.Time) {
+	sec := now.Unix() - 1
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if sec <= lastTick {
+		return
+	}
+	lastTick = sec
+
+	all.Range(func(k, sv any) bool {
+		s := sv.(*series)
+		if s.sample == nil && now.Sub(s.lastSeen) > idleExpiry {
+			// an Inc racing this either shows in pending or sees removed
+			s.removed.Store(true)
+			if s.pending.Load() == 0 {
+				all.Delete(k)
+				return true
+			}
+			s.removed.Store(false)
+		}
+
+		v := float64(s.pending.Swap(0))
+		if s.sample != nil {
+			v = s.sample()
+		} else if v != 0 {
+			s.lastSeen = now
+		}
+		s.seconds.put(sec, v)
+
+		if (sec+1)%60 == 0 {
+			minute := sec / 60
+			s.minutes.put(minute, s.seconds.mean(minute*60, sec))
+		}
+		return true
+	})
+}
+
+// ==========================================================================
+
+type Series struct {
+	Label  string    `json:"label,omitempty"`
+	Values []float64 `json:"values"`
+}
+
+// History holds the averages of a metric over consecutive steps
+type History struct {
+	Metric     Metric    `json:"metric"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Step       int       `json:"step"`       // seconds
+	Timestamps []int64   `json:"timestamps"` // unix seconds at the start of each step
+	Series     []Series  `json:"series"`
+}
+
+// Query returns the values of metric between from and to, over the last day.
+// Steps of a minute or more are served from the per-minute averages, a zero
+// step picks one giving at most a few hundred values.
+func Query(metric Metric, from, to time.Time, step time.Duration) (History, error) {
+	if !slices.Contains(Metrics, metric) {
+		return History{}, ErrUnknownMetric
+	}
+	if step < 0 {
+		return History{}, errors.New("step must not be negative")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// bounds in unix seconds, to excluded
+	last := lastTick + 1
+	first := max(from.Unix(), started.Unix(), last-MinuteSlots*60)
+	end := min(to.Unix(), last)
+
+	resolution := int64(1)
+	if first < last-SecondSlots || step >= time.Minute {
+		resolution = 60
+		end = min(end, last/60*60) // the current minute is incomplete
+	}
+	stepSecs := int64(step / time.Second)
+	if stepSecs == 0 {
+		stepSecs = (end - first) / 300
+	}
+	stepSecs = max((stepSecs+resolution-1)/resolution*resolution, resolution)
+
+	first -= first % stepSecs
+	points := max((end-first+stepSecs-1)/stepSecs, 0)
+	if points > MaxPoints {
+		return History{}, fmt.Errorf("%d values per series exceed %d, use a larger step", points, MaxPoints)
+	}
+
+	h := History{
+		Metric:     metric,
+		From:       time.Unix(first, 0).UTC(),
+		To:         time.Unix(max(end, first), 0).UTC(),
+		Step:       int(stepSecs),
+		Timestamps: make([]int64, points),
+		Series:     []Series{},
+	}
+	for i := range h.Timestamps {
+		h.Timestamps[i] = first + int64(i)*stepSecs
+	}
+
+	perStep := stepSecs / resolution
+	all.Range(func(k, sv any) bool {
+		s := sv.(*series)
+		if k.(key).metric != metric {
+			return true
+		}
+		r := &s.seconds
+		if resolution == 60 {
+			r = &s.minutes
+		}
+		values := make([]float64, points)
+		for i, ts := range h.Timestamps {
+			slot := ts / resolution
+			values[i] = r.mean(slot, slot+perStep-1)
+		}
+		h.Series = append(h.Series, Series{Label: k.(key).label, Values: values})
+		return true
+	})
+	slices.SortFunc(h.Series, func(a, b Series) int { return cmp.Compare(a.Label, b.Label) })
+	return h, nil
+}
@@ -0,0 +1,163 @@
+package history
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+// at is the start of a minute
+var at = time.Unix(1735725600, 0).UTC()
+
+// reset empties the history, as if started at start
+func reset(start time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	all.Clear()
+	started = start
+	lastTick = start.Unix() - 1
+}
+
+func second(n int) time.Time {
+	return at.Add(time.Duration(n) * time.Second)
+}
+
+func query(t *testing.T, metric Metric, from, to time.Time, step time.Duration) History {
+	t.Helper()
+	h, err := Query(metric, from, to, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestMinuteRollup(t *testing.T) {
+	reset(at)
+	for i := range 90 {
+		for range i % 4 {
+			Inc(Hits, "SR1")
+		}
+		tick(second(i + 1))
+	}
+
+	h := query(t, Hits, at, second(90), time.Minute)
+	if h.Step != 60 || len(h.Timestamps) != 1 || h.Timestamps[0] != at.Unix() {
+		t.Fatalf("step %d, timestamps %v: want the first minute only, the second is incomplete", h.Step, h.Timestamps)
+	}
+	if len(h.Series) != 1 || h.Series[0].Label != "SR1" || h.Series[0].Values[0] != 1.5 {
+		t.Errorf("series %+v, want SR1 at 1.5", h.Series)
+	}
+
+	h = query(t, Hits, at, second(8), time.Second)
+	if got := h.Series[0].Values; len(got) != 8 || got[0] != 0 || got[3] != 3 || got[5] != 1 {
+		t.Errorf("per-second values %v", got)
+	}
+	h = query(t, Hits, at, second(60), 20*time.Second)
+	for _, v := range h.Series[0].Values {
+		if v != 1.5 {
+			t.Errorf("20 s averages %v", h.Series[0].Values)
+			break
+		}
+	}
+}
+
+func TestSecondsKeptADay(t *testing.T) {
+	reset(at)
+	Inc(CAPS, "")
+	Inc(CAPS, "")
+	for i := range SecondSlots {
+		tick(second(i + 1))
+	}
+
+	h := query(t, CAPS, at, second(10), time.Second)
+	if !h.From.Equal(at) || h.Series[0].Values[0] != 2 {
+		t.Errorf("from %s, values %v: want the first second still held a day later", h.From, h.Series[0].Values)
+	}
+
+	tick(second(SecondSlots + 1))
+	if h := query(t, CAPS, at, second(10), time.Second); !h.From.Equal(second(1)) {
+		t.Errorf("from %s, want the first second dropped", h.From)
+	}
+}
+
+func TestIdleSeriesDropped(t *testing.T) {
+	reset(at)
+	Inc(Rejects, "480 Call Limiter Exceeded")
+	tick(second(1))
+
+	end := int(idleExpiry/time.Second) + 2
+	tick(second(end))
+	if h := query(t, Rejects, second(end-60), second(end), time.Minute); len(h.Series) != 0 {
+		t.Errorf("series %+v, want the idle one dropped", h.Series)
+	}
+
+	Inc(Rejects, "480 Call Limiter Exceeded")
+	tick(second(end + 1))
+	if h := query(t, Rejects, second(end), second(end+1), time.Second); len(h.Series) != 1 || h.Series[0].Values[0] != 1 {
+		t.Errorf("series %+v, want it back", h.Series)
+	}
+}
+
+func TestConcurrentInc(t *testing.T) {
+	reset(at)
+	const workers, events, seconds = 8, 5000, 3000
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range events {
+				Inc(Hits, "SR1")
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	ticked := make(chan int)
+	go func() {
+		i := 1
+		for ; i < seconds; i++ {
+			select {
+			case <-stop:
+				ticked <- i
+				return
+			default:
+				tick(second(i))
+			}
+		}
+		<-stop
+		ticked <- i
+	}()
+	wg.Wait()
+	close(stop)
+	last := <-ticked
+	tick(second(last))
+
+	var sum float64
+	for _, v := range query(t, Hits, at, second(last), time.Second).Series[0].Values {
+		sum += v
+	}
+	if sum != workers*events {
+		t.Errorf("%g events recorded, want %d", sum, workers*events)
+	}
+}
+
+func TestStop(t *testing.T) {
+	Start()
+	Stop()
+	Stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		WtGrp.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sampling goroutine still running after Stop")
+	}
+}
@@ -0,0 +1,38 @@
+package history
+
+// ring holds the values of the latest slots, a slot being a unix second or minute
+type ring struct {
+	values []float64
+	last   int64 // slot of the latest value
+}
+
+func newRing(size int, last int64) ring {
+	return ring{values: make([]float64, size), last: last}
+}
+
+// put writes v at slot, slots skipped since the latest one are zeroed
+func (r *ring) put(slot int64, v float64) {
+	size := int64(len(r.values))
+	if slot > r.last+1 {
+		for s := max(r.last+1, slot-size+1); s < slot; s++ {
+			r.values[s%size] = 0
+		}
+	}
+	r.values[slot%size] = v
+	r.last = slot
+}
+
+// mean returns the average of the values held from slot first to last
+func (r *ring) mean(first, last int64) float64 {
+	first = max(first, r.last-int64(len(r.values))+1, 0)
+	last = min(last, r.last)
+	if first > last {
+		return 0
+	}
+
+	var sum float64
+	for s := first; s <= last; s++ {
+		sum += r.values[s%int64(len(r.values))]
+	}
+	return sum / float64(last-first+1)
+}
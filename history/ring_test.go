@@ -0,0 +1,34 @@
+package history
+
+import "testing"
+
+func TestRingWrapAround(t *testing.T) {
+	r := newRing(4, -1)
+	for slot := range int64(10) {
+		r.put(slot, float64(slot))
+	}
+	checks := []struct {
+		first, last int64
+		want        float64
+	}{
+		{6, 9, 7.5},
+		{0, 9, 7.5}, // overwritten slots are not held
+		{0, 5, 0},
+		{8, 20, 8.5}, // slots to come are not held
+	}
+	for _, c := range checks {
+		if got := r.mean(c.first, c.last); got != c.want {
+			t.Errorf("mean(%d, %d) = %g, want %g", c.first, c.last, got, c.want)
+		}
+	}
+
+	// the slots skipped are zeroed, not left with the values of the previous lap
+	r.put(12, 12)
+	if got := r.mean(9, 12); got != (9+0+0+12)/4.0 {
+		t.Errorf("mean after a gap = %g", got)
+	}
+	r.put(100, 1)
+	if got := r.mean(97, 100); got != 0.25 {
+		t.Errorf("mean after a gap longer than the ring = %g", got)
+	}
+}
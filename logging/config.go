@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"siploadbalancer/rotate"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+
+	DefaultSyslogAddress  = "/dev/log"
+	DefaultSyslogFacility = 16 // local0
+	DefaultAppName        = "siploadbalancer"
+)
+
+type (
+	Config struct {
+		Level      string            `json:"level"`      // debug, info, warn or error
+		Format     string            `json:"format"`     // text or json
+		Output     string            `json:"output"`     // stdout, file or syslog
+		File       FileConfig        `json:"file"`       // when output is file
+		Syslog     SyslogConfig      `json:"syslog"`     // when output is syslog
+		Subsystems map[string]string `json:"subsystems"` // level per subsystem, overriding level
+	}
+
+	FileConfig struct {
+		Path           string `json:"path"`
+		MaxSize        int    `json:"maxSize"`        // MB
+		RotateInterval int    `json:"rotateInterval"` // seconds
+		Compress       bool   `json:"compress"`
+		MaxBackups     int    `json:"maxBackups"`
+	}
+
+	SyslogConfig struct {
+		Network  string `json:"network"` // unixgram or unix
+		Address  string `json:"address"`
+		Facility int    `json:"facility"`
+		AppName  string `json:"appName"`
+	}
+)
+
+func parseConfig(data []byte) (Config, error) {
+	var in struct {
+		Logging Config `json:"logging"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.Logging, err
+	}
+	in.Logging.setDefaults()
+	return in.Logging, in.Logging.validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Level == "" {
+		c.Level = "info"
+	}
+	if c.Format == "" {
+		c.Format = FormatText
+	}
+	if c.Output == "" {
+		c.Output = OutputStdout
+	}
+	if c.Syslog.Network == "" {
+		c.Syslog.Network = "unixgram"
+	}
+	if c.Syslog.Address == "" {
+		c.Syslog.Address = DefaultSyslogAddress
+	}
+	if c.Syslog.Facility == 0 {
+		c.Syslog.Facility = DefaultSyslogFacility
+	}
+	if c.Syslog.AppName == "" {
+		c.Syslog.AppName = DefaultAppName
+	}
+}
+
+func (c *Config) validate() error {
+	if _, err := parseLevel(c.Level); err != nil {
+		return err
+	}
+	for sub, lvl := range c.Subsystems {
+		if _, err := parseLevel(lvl); err != nil {
+			return fmt.Errorf("logging.subsystems.%s: %w", sub, err)
+		}
+	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("logging.format [%s] is unknown", c.Format)
+	}
+
+	switch c.Output {
+	case OutputStdout:
+	case OutputFile:
+		if c.File.Path == "" {
+			return fmt.Errorf("logging.file.path is required")
+		}
+		if fi, err := os.Stat(filepath.Dir(c.File.Path)); err != nil || !fi.IsDir() {
+			return fmt.Errorf("logging.file.path [%s] is not in an existing directory", c.File.Path)
+		}
+		if c.File.MaxSize < 0 || c.File.RotateInterval < 0 || c.File.MaxBackups < 0 {
+			return fmt.Errorf("logging.file rotation settings must not be negative")
+		}
+	case OutputSyslog:
+		if c.Syslog.Network != "unixgram" && c.Syslog.Network != "unix" {
+			return fmt.Errorf("logging.syslog.network [%s] must be unixgram or unix", c.Syslog.Network)
+		}
+		if c.Syslog.Facility < 0 || c.Syslog.Facility > 23 {
+			return fmt.Errorf("logging.syslog.facility [%d] is invalid", c.Syslog.Facility)
+		}
+	default:
+		return fmt.Errorf("logging.output [%s] is unknown", c.Output)
+	}
+	return nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return lvl, fmt.Errorf("log level [%s] is unknown", s)
+	}
+	return lvl, nil
+}
+
+func (fc *FileConfig) rotateConfig() rotate.Config {
+	return rotate.Config{
+		MaxSize:    int64(fc.MaxSize) << 20,
+		Interval:   time.Duration(fc.RotateInterval) * time.Second,
+		Compress:   fc.Compress,
+		MaxBackups: fc.MaxBackups,
+		Logger:     For("rotate"),
+	}
+}
@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"siploadbalancer/rotate"
+)
+
+// Attribute keys shared by the subsystems
+const (
+	KeySubsystem = "subsystem"
+	KeyCallID    = "callId"
+	KeyNode      = "node"
+	KeyPeer      = "peer"
+	KeyError     = "error"
+)
+
+type (
+	// output is the running configuration, replaced as a whole on reload
+	output struct {
+		handler    slog.Handler
+		level      slog.Level
+		subsystems map[string]slog.Level
+		closer     io.Closer
+	}
+
+	// handler filters by subsystem level and forwards to the running output
+	handler struct {
+		subsystem string
+		ops       []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, replayed on the output
+		cache     *atomic.Pointer[resolved]
+	}
+
+	resolved struct {
+		out *output
+		h   slog.Handler
+	}
+)
+
+var (
+	current atomic.Pointer[output]
+	setMu   sync.Mutex
+)
+
+func init() {
+	current.Store(&output{handler: newHandler(os.Stdout, FormatText), level: slog.LevelInfo})
+}
+
+// For returns the logger of a subsystem, it follows configuration changes
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem, cache: new(atomic.Pointer[resolved])})
+}
+
+// Err is the attribute of an error
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+func (o *output) levelOf(subsystem string) slog.Level {
+	if lvl, ok := o.subsystems[subsystem]; ok {
+		return lvl
+	}
+	return o.level
+}
+
+func (h *handler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= current.Load().levelOf(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(x slog.Handler) slog.Handler { return x.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(x slog.Handler) slog.Handler { return x.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	return &handler{
+		subsystem: h.subsystem,
+		ops:       append(slices.Clip(h.ops), op),
+		cache:     new(atomic.Pointer[resolved]),
+	}
+}
+
+// resolve derives the handler from the running output once per configuration
+func (h *handler) resolve() slog.Handler {
+	out := current.Load()
+	if r := h.cache.Load(); r != nil && r.out == out {
+		return r.h
+	}
+
+	rh := out.handler.WithAttrs([]slog.Attr{slog.String(KeySubsystem, h.subsystem)})
+	for _, op := range h.ops {
+		rh = op(rh)
+	}
+	h.cache.Store(&resolved{out: out, h: rh})
+	return rh
+}
+
+// ==========================================================================
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // levels are checked per subsystem
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func newOutput(cfg Config) (*output, error) {
+	out := &output{subsystems: make(map[string]slog.Level, len(cfg.Subsystems))}
+	out.level, _ = parseLevel(cfg.Level)
+	for sub, lvl := range cfg.Subsystems {
+		out.subsystems[sub], _ = parseLevel(lvl)
+	}
+
+	switch cfg.Output {
+	case OutputFile:
+		w, err := rotate.Open(cfg.File.Path, cfg.File.rotateConfig())
+		if err != nil {
+			return nil, err
+		}
+		out.handler, out.closer = newHandler(w, cfg.Format), w
+	case OutputSyslog:
+		w, err := dialSyslog(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		out.handler, out.closer = newSyslogHandler(w, cfg.Format), w
+	default:
+		out.handler = newHandler(os.Stdout, cfg.Format)
+	}
+	return out, nil
+}
+
+func setOutput(out *output) {
+	setMu.Lock()
+	defer setMu.Unlock()
+
+	old := current.Swap(out)
+	if old.closer != nil {
+		if err := old.closer.Close(); err != nil {
+			For("logging").Warn("Failed to close the previous log output", Err(err))
+		}
+	}
+}
+
+// Setup applies the logging section of data and routes the standard log
+// package through it, subsystems logging before Setup go to stdout
+func Setup(data []byte) error {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	out, err := newOutput(cfg)
+	if err != nil {
+		return err
+	}
+	setOutput(out)
+
+	logger := For("main")
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return nil
+}
+
+// Reload validates the logging section of data and returns the function applying it
+func Reload(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		out, err := newOutput(cfg)
+		if err != nil {
+			For("logging").Error("Failed to open log output, keeping the current one", "output", cfg.Output, Err(err))
+			return
+		}
+		setOutput(out)
+	}, nil
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// syslogWriter sends RFC 5424 messages to a local syslog socket
+	syslogWriter struct {
+		mu       sync.Mutex
+		cfg      SyslogConfig
+		conn     net.Conn
+		hostname string
+	}
+
+	// syslogHandler formats each record on its own so that its level
+	// sets the severity of the syslog message
+	syslogHandler struct {
+		w      *syslogWriter
+		format string
+		ops    []func(slog.Handler) slog.Handler
+	}
+)
+
+func dialSyslog(cfg SyslogConfig) (*syslogWriter, error) {
+	conn, err := net.Dial(cfg.Network, cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog [%s]: %w", cfg.Address, err)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &syslogWriter{cfg: cfg, conn: conn, hostname: hostname}, nil
+}
+
+// severity maps slog levels to RFC 5424 severities
+func severity(lvl slog.Level) int {
+	switch {
+	case lvl >= slog.LevelError:
+		return 3
+	case lvl >= slog.LevelWarn:
+		return 4
+	case lvl >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func (sw *syslogWriter) write(lvl slog.Level, t time.Time, msg []byte) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - ", sw.cfg.Facility*8+severity(lvl),
+		t.Format("2006-01-02T15:04:05.000000Z07:00"), sw.hostname, sw.cfg.AppName, os.Getpid())
+	b.Write(bytes.TrimRight(msg, "\n"))
+	if sw.cfg.Network == "unix" {
+		b.WriteByte('\n') // stream sockets need a delimiter
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.conn == nil {
+		return net.ErrClosed
+	}
+	if _, err := sw.conn.Write(b.Bytes()); err == nil {
+		return nil
+	}
+	// the syslog daemon may have restarted, reconnect once
+	conn, err := net.Dial(sw.cfg.Network, sw.cfg.Address)
+	if err != nil {
+		return err
+	}
+	sw.conn.Close()
+	sw.conn = conn
+	_, err = conn.Write(b.Bytes())
+	return err
+}
+
+func (sw *syslogWriter) Close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.conn == nil {
+		return nil
+	}
+	err := sw.conn.Close()
+	sw.conn = nil
+	return err
+}
+
+// ==========================================================================
+
+func newSyslogHandler(w *syslogWriter, format string) *syslogHandler {
+	return &syslogHandler{w: w, format: format}
+}
+
+func (h *syslogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	opts := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{} // carried by the syslog header
+			}
+			return a
+		},
+	}
+	var inner slog.Handler
+	if h.format == FormatJSON {
+		inner = slog.NewJSONHandler(&buf, opts)
+	} else {
+		inner = slog.NewTextHandler(&buf, opts)
+	}
+	for _, op := range h.ops {
+		inner = op(inner)
+	}
+	if err := inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.w.write(r.Level, r.Time, buf.Bytes())
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(x slog.Handler) slog.Handler { return x.WithAttrs(attrs) })
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(x slog.Handler) slog.Handler { return x.WithGroup(name) })
+}
+
+func (h *syslogHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	return &syslogHandler{w: h.w, format: h.format, ops: append(slices.Clip(h.ops), op)}
+}
@@ -8,10 +8,62 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const (
+	namespace = "LoadBalancer"
+	nodeLabel = "node"
+)
+
+// delayBuckets suit post-dial delays, in seconds
+var delayBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30}
+
 type Metrics struct {
 	Registry    *prometheus.Registry
 	ConSessions prometheus.Gauge
 	Caps        prometheus.Gauge
+
+	// per SIP server, labelled by its description
+	RequestsForwarded  *prometheus.CounterVec // labels: node, method
+	ResponsesForwarded *prometheus.CounterVec // labels: node, class
+	ActiveDialogs      *prometheus.GaugeVec
+	NodeUp             *prometheus.GaugeVec
+	ProbeRTT           *prometheus.GaugeVec
+	Ejections          *prometheus.CounterVec
+	QualityFactor      *prometheus.GaugeVec
+
+	// call-quality KPIs of the INVITE dialogues routed to each server
+	CallSeizures    *prometheus.CounterVec
+	CallsAnswered   *prometheus.CounterVec
+	NetworkFailures *prometheus.CounterVec
+	TalkTime        *prometheus.CounterVec
+	ASR             *prometheus.GaugeVec
+	NER             *prometheus.GaugeVec
+	ACD             *prometheus.GaugeVec
+	GlobalASR       prometheus.Gauge
+	GlobalNER       prometheus.Gauge
+	GlobalACD       prometheus.Gauge
+	RingDelay       *prometheus.HistogramVec
+	AnswerDelay     *prometheus.HistogramVec
+
+	WrittenCDRs prometheus.Counter
+	DroppedCDRs prometheus.Counter
+
+	CapturedPackets       prometheus.Counter
+	DroppedCapturePackets prometheus.Counter
+	SentHEPPackets        prometheus.Counter
+	DroppedHEPPackets     prometheus.Counter
+	ExportedSpans         prometheus.Counter
+	DroppedSpans          prometheus.Counter
+
+	ParseErrors     prometheus.Counter
+	DroppedMessages prometheus.Counter
+	LocalResponses  *prometheus.CounterVec // labels: code
+
+	// call attempts checked by the call limiter, per quota
+	LimiterAccepted *prometheus.CounterVec // labels: key
+	LimiterRejected *prometheus.CounterVec // labels: key
+	// dialogues in progress and dialogues rejected over a maxCalls, per quota
+	LimiterActiveCalls      *prometheus.GaugeVec   // labels: key
+	LimiterMaxCallsRejected *prometheus.CounterVec // labels: key
 }
 
 func NewMetrics() *Metrics {
@@ -20,14 +72,14 @@ func NewMetrics() *Metrics {
 	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 
 	caps := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "LoadBalancer",
+		Namespace: namespace,
 		Name:      "CallAttemptPerSecond",
-		Help:      "Shows concurrent sessions active",
+		Help:      "Shows call attempts accepted during the last second",
 	})
 	reg.MustRegister(caps)
 
 	concurrentSessions := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "LoadBalancer",
+		Namespace: namespace,
 		Name:      "ConcurrentSessions",
 		Help:      "Shows concurrent sessions active",
 	})
@@ -37,11 +89,238 @@ func NewMetrics() *Metrics {
 		Registry:    reg,
 		ConSessions: concurrentSessions,
 		Caps:        caps,
+
+		RequestsForwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "RequestsForwarded",
+			Help:      "Counts SIP requests forwarded per server and method",
+		}, []string{nodeLabel, "method"}),
+		ResponsesForwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ResponsesForwarded",
+			Help:      "Counts SIP responses forwarded per server and status class",
+		}, []string{nodeLabel, "class"}),
+		ActiveDialogs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ActiveDialogs",
+			Help:      "Shows dialogues active per server",
+		}, []string{nodeLabel}),
+		NodeUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NodeUp",
+			Help:      "Shows whether a server answers its probes (1) or not (0)",
+		}, []string{nodeLabel}),
+		ProbeRTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ProbeRoundTripSeconds",
+			Help:      "Shows the round-trip time of the last answered probe per server",
+		}, []string{nodeLabel}),
+		Ejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "Ejections",
+			Help:      "Counts times a server was taken out of rotation for not answering its probes",
+		}, []string{nodeLabel}),
+		QualityFactor: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NodeQualityFactor",
+			Help:      "Shows the share of its dialogues a server gets for its call quality, 1 when healthy",
+		}, []string{nodeLabel}),
+
+		CallSeizures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallSeizures",
+			Help:      "Counts INVITE dialogues per server that were answered or failed",
+		}, []string{nodeLabel}),
+		CallsAnswered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallsAnswered",
+			Help:      "Counts INVITE dialogues per server that were answered",
+		}, []string{nodeLabel}),
+		NetworkFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallNetworkFailures",
+			Help:      "Counts INVITE dialogues per server that timed out or got a 408 or 5xx",
+		}, []string{nodeLabel}),
+		TalkTime: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallTalkSeconds",
+			Help:      "Counts the duration of the answered dialogues per server once they end",
+		}, []string{nodeLabel}),
+		ASR: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NodeASR",
+			Help:      "Shows the answer-seizure ratio per server in percent",
+		}, []string{nodeLabel}),
+		NER: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NodeNER",
+			Help:      "Shows the network-effectiveness ratio per server in percent",
+		}, []string{nodeLabel}),
+		ACD: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NodeACDSeconds",
+			Help:      "Shows the average call duration per server",
+		}, []string{nodeLabel}),
+		GlobalASR: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ASR",
+			Help:      "Shows the answer-seizure ratio in percent",
+		}),
+		GlobalNER: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "NER",
+			Help:      "Shows the network-effectiveness ratio in percent",
+		}),
+		GlobalACD: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ACDSeconds",
+			Help:      "Shows the average call duration",
+		}),
+		RingDelay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "InviteToRingingSeconds",
+			Help:      "Shows the delay between an INVITE and its first 180/183 per server",
+			Buckets:   delayBuckets,
+		}, []string{nodeLabel}),
+		AnswerDelay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "InviteToAnswerSeconds",
+			Help:      "Shows the delay between an INVITE and its 200 per server",
+			Buckets:   delayBuckets,
+		}, []string{nodeLabel}),
+
+		WrittenCDRs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CDRsWritten",
+			Help:      "Counts call detail records written",
+		}),
+		DroppedCDRs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CDRsDropped",
+			Help:      "Counts call detail records dropped because the writer could not keep up",
+		}),
+
+		CapturedPackets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CapturedPackets",
+			Help:      "Counts datagrams written to packet captures, once per capture",
+		}),
+		DroppedCapturePackets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CapturePacketsDropped",
+			Help:      "Counts datagrams missing from packet captures because the writer could not keep up",
+		}),
+		SentHEPPackets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "HEPPacketsSent",
+			Help:      "Counts HEP packets sent to the collector",
+		}),
+		DroppedHEPPackets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "HEPPacketsDropped",
+			Help:      "Counts SIP datagrams not mirrored to the collector, being unreachable or too slow",
+		}),
+		ExportedSpans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "SpansExported",
+			Help:      "Counts trace spans exported over OTLP",
+		}),
+		DroppedSpans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "SpansDropped",
+			Help:      "Counts trace spans dropped, the exporter being unreachable or too slow",
+		}),
+
+		ParseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ParseErrors",
+			Help:      "Counts received datagrams that could not be parsed as SIP",
+		}),
+		DroppedMessages: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "DroppedMessages",
+			Help:      "Counts SIP messages dropped without being forwarded",
+		}),
+		LocalResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "LocalResponses",
+			Help:      "Counts SIP error responses generated by the balancer per status code",
+		}, []string{"code"}),
+		LimiterAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallAttemptsAccepted",
+			Help:      "Counts call attempts let through by the call limiter per quota",
+		}, []string{"key"}),
+		LimiterRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "CallAttemptsRejected",
+			Help:      "Counts call attempts rejected by the call limiter per quota, by the quota or the global cap",
+		}, []string{"key"}),
+		LimiterActiveCalls: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "QuotaActiveCalls",
+			Help:      "Dialogues in progress per call limiter quota",
+		}, []string{"key"}),
+		LimiterMaxCallsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "MaxCallsRejected",
+			Help:      "Counts dialogues rejected per quota for reaching the maxCalls of the quota or the global maxCalls",
+		}, []string{"key"}),
 	}
 
+	reg.MustRegister(
+		metrics.RequestsForwarded, metrics.ResponsesForwarded, metrics.ActiveDialogs,
+		metrics.NodeUp, metrics.ProbeRTT, metrics.Ejections, metrics.QualityFactor,
+		metrics.ParseErrors, metrics.DroppedMessages, metrics.LocalResponses,
+		metrics.CallSeizures, metrics.CallsAnswered, metrics.NetworkFailures, metrics.TalkTime,
+		metrics.ASR, metrics.NER, metrics.ACD, metrics.GlobalASR, metrics.GlobalNER, metrics.GlobalACD,
+		metrics.RingDelay, metrics.AnswerDelay, metrics.WrittenCDRs, metrics.DroppedCDRs,
+		metrics.CapturedPackets, metrics.DroppedCapturePackets, metrics.SentHEPPackets, metrics.DroppedHEPPackets,
+		metrics.ExportedSpans, metrics.DroppedSpans, metrics.LimiterAccepted, metrics.LimiterRejected,
+		metrics.LimiterActiveCalls, metrics.LimiterMaxCallsRejected,
+	)
+
 	return metrics
 }
 
+// RegisterQueueDepth exposes the depth of the receive queue, read on each scrape
+func (m *Metrics) RegisterQueueDepth(depth func() float64) {
+	m.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "PacketQueueDepth",
+		Help:      "Shows packets received and waiting for a worker",
+	}, depth))
+}
+
+// DeleteNode drops the series of a server that was removed or renamed
+func (m *Metrics) DeleteNode(node string) {
+	labels := prometheus.Labels{nodeLabel: node}
+	m.RequestsForwarded.DeletePartialMatch(labels)
+	m.ResponsesForwarded.DeletePartialMatch(labels)
+	m.ActiveDialogs.DeletePartialMatch(labels)
+	m.NodeUp.DeletePartialMatch(labels)
+	m.ProbeRTT.DeletePartialMatch(labels)
+	m.Ejections.DeletePartialMatch(labels)
+	m.QualityFactor.DeletePartialMatch(labels)
+	m.CallSeizures.DeletePartialMatch(labels)
+	m.CallsAnswered.DeletePartialMatch(labels)
+	m.NetworkFailures.DeletePartialMatch(labels)
+	m.TalkTime.DeletePartialMatch(labels)
+	m.ASR.DeletePartialMatch(labels)
+	m.NER.DeletePartialMatch(labels)
+	m.ACD.DeletePartialMatch(labels)
+	m.RingDelay.DeletePartialMatch(labels)
+	m.AnswerDelay.DeletePartialMatch(labels)
+}
+
+// DeleteQuota drops the series of a call limiter quota that was removed
+func (m *Metrics) DeleteQuota(key string) {
+	m.LimiterAccepted.DeleteLabelValues(key)
+	m.LimiterRejected.DeleteLabelValues(key)
+	m.LimiterActiveCalls.DeleteLabelValues(key)
+	m.LimiterMaxCallsRejected.DeleteLabelValues(key)
+}
+
 func (m *Metrics) Handler() http.Handler {
 	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
 }
@@ -0,0 +1,279 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const timeFormat = "20060102-150405"
+
+type Config struct {
+	MaxSize    int64         // bytes written before rotating, 0 disables
+	Interval   time.Duration // age of a file before rotating, 0 disables
+	Compress   bool          // gzip rotated files
+	MaxBackups int           // rotated files kept, 0 keeps them all
+	Header     []byte        // written at the start of every new file
+	Logger     *slog.Logger  // clean-up failures, slog.Default when nil
+}
+
+// Writer appends to a file that it rotates by size and age. Rotated files are
+// renamed with a timestamp, e.g. cdr.jsonl becomes cdr-20060102-150405.jsonl.
+type Writer struct {
+	path   string
+	cfg    Config
+	file   *os.File
+	size   int64
+	opened time.Time
+	mu     sync.Mutex
+
+	cleaning sync.Mutex     // one clean-up at a time, in the order of rotation
+	pending  sync.WaitGroup // clean-ups not finished yet
+}
+
+func Open(path string, cfg Config) (*Writer, error) {
+	w := &Writer{path: path, cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.due(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.rotate()
+}
+
+// Close closes the file and waits for the clean-up of the rotated files,
+// which may log to this very file and so must not find it locked
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.pending.Wait()
+	return err
+}
+
+// due reports whether the file must be rotated before writing n more bytes
+func (w *Writer) due(n int64) bool {
+	if w.size <= int64(len(w.cfg.Header)) {
+		return false // never leave an empty file behind
+	}
+	if w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize {
+		return true
+	}
+	return w.cfg.Interval > 0 && time.Since(w.opened) >= w.cfg.Interval
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file, w.size, w.opened = file, fi.Size(), time.Now()
+	if w.size == 0 && len(w.cfg.Header) > 0 {
+		n, err := file.Write(w.cfg.Header)
+		w.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	rotated := w.rotatedName(time.Now())
+	if err := os.Rename(w.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		w.cleanUp(rotated)
+	}()
+
+	return w.open()
+}
+
+func (w *Writer) rotatedName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	name := fmt.Sprintf("%s-%s%s", base, t.Format(timeFormat), ext)
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%s-%d%s", base, t.Format(timeFormat), i, ext)
+	}
+	return name
+}
+
+// cleanUp compresses the rotated file and removes the oldest ones beyond MaxBackups
+func (w *Writer) cleanUp(rotated string) {
+	w.cleaning.Lock()
+	defer w.cleaning.Unlock()
+
+	if w.cfg.Compress {
+		if err := compress(rotated); err != nil {
+			w.log().Error("Failed to compress a rotated file", "path", rotated, slog.Any("error", err))
+		}
+	}
+	if w.cfg.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		w.log().Error("Failed to list the rotated files", "path", w.path, slog.Any("error", err))
+		return
+	}
+	if len(backups) <= w.cfg.MaxBackups {
+		return
+	}
+	slices.SortFunc(backups, w.compareBackups)
+	for _, name := range backups[:len(backups)-w.cfg.MaxBackups] {
+		if err := os.Remove(name); err != nil {
+			w.log().Error("Failed to remove a rotated file", "path", name, slog.Any("error", err))
+		}
+	}
+}
+
+func (w *Writer) log() *slog.Logger {
+	if w.cfg.Logger != nil {
+		return w.cfg.Logger
+	}
+	return slog.Default()
+}
+
+// backups returns the files rotated from this one, and no other file sharing
+// its name as a prefix
+func (w *Writer) backups() ([]string, error) {
+	dir := filepath.Dir(w.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if _, _, ok := w.backupKey(e.Name()); ok && e.Type().IsRegular() {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	return backups, nil
+}
+
+// compareBackups orders rotated files chronologically: by timestamp, then by
+// the counter of the files rotated within the same second
+func (w *Writer) compareBackups(a, b string) int {
+	tsA, nA, _ := w.backupKey(a)
+	tsB, nB, _ := w.backupKey(b)
+	if c := strings.Compare(tsA, tsB); c != 0 {
+		return c
+	}
+	return nA - nB
+}
+
+// backupKey returns the timestamp and counter of a rotated file name,
+// e.g. 20060102-150405 and 1 for cdr-20060102-150405-1.jsonl.gz, and whether
+// the name is exactly <base>-<timestamp>[-<counter>]<ext>[.gz]
+func (w *Writer) backupKey(name string) (string, int, bool) {
+	ext := filepath.Ext(w.path)
+	rest, ok := strings.CutPrefix(filepath.Base(name), strings.TrimSuffix(filepath.Base(w.path), ext)+"-")
+	if !ok {
+		return "", 0, false
+	}
+	if rest, ok = strings.CutSuffix(strings.TrimSuffix(rest, ".gz"), ext); !ok || len(rest) < len(timeFormat) {
+		return "", 0, false
+	}
+
+	ts, counter := rest[:len(timeFormat)], rest[len(timeFormat):]
+	if _, err := time.Parse(timeFormat, ts); err != nil {
+		return "", 0, false
+	}
+	if counter == "" {
+		return ts, 0, true
+	}
+	digits, ok := strings.CutPrefix(counter, "-")
+	n, err := strconv.Atoi(digits)
+	if !ok || err != nil || n <= 0 || strconv.Itoa(n) != digits {
+		return "", 0, false
+	}
+	return ts, n, true
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,180 @@
+package rotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatedNameCollision(t *testing.T) {
+	dir := t.TempDir()
+	w := &Writer{path: filepath.Join(dir, "cdr.jsonl")}
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	var names []string
+	for range 12 {
+		name := w.rotatedName(now)
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(name))
+	}
+	if names[0] != "cdr-20250102-150405.jsonl" || names[1] != "cdr-20250102-150405-1.jsonl" {
+		t.Errorf("names = %v", names[:2])
+	}
+
+	// the order of rotation, whatever the shell or Glob order
+	shuffled := slices.Clone(names)
+	slices.Reverse(shuffled)
+	shuffled = append(shuffled, "cdr-20250102-150404-3.jsonl.gz", "cdr-20250102-150406.jsonl.gz")
+	slices.SortFunc(shuffled, func(a, b string) int {
+		return w.compareBackups(filepath.Join(dir, a), filepath.Join(dir, b))
+	})
+	want := append([]string{"cdr-20250102-150404-3.jsonl.gz"}, names...)
+	want = append(want, "cdr-20250102-150406.jsonl.gz")
+	if fmt.Sprint(shuffled) != fmt.Sprint(want) {
+		t.Errorf("order = %v, want %v", shuffled, want)
+	}
+}
+
+func TestMaxBackupsKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	w := &Writer{path: filepath.Join(dir, "cdr.jsonl"), cfg: Config{MaxBackups: 2}}
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	var rotated string
+	for range 3 {
+		rotated = w.rotatedName(now)
+		if err := os.WriteFile(rotated, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.cleanUp(rotated)
+
+	left, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for i := range left {
+		left[i] = filepath.Base(left[i])
+	}
+	if want := []string{"cdr-20250102-150405-1.jsonl", "cdr-20250102-150405-2.jsonl"}; fmt.Sprint(left) != fmt.Sprint(want) {
+		t.Errorf("kept %v, want %v", left, want)
+	}
+}
+
+func TestBackupKeyAnchored(t *testing.T) {
+	w := &Writer{path: filepath.Join(t.TempDir(), "cdr.jsonl")}
+	for name, want := range map[string]bool{
+		"cdr-20250102-150405.jsonl":        true,
+		"cdr-20250102-150405-12.jsonl":     true,
+		"cdr-20250102-150405.jsonl.gz":     true,
+		"cdr-20250102-150405-3.jsonl.gz":   true,
+		"cdr.jsonl":                        false,
+		"cdr-notes.jsonl":                  false,
+		"cdr-20250102-150405.jsonl.bak":    false,
+		"cdr-20250102-150405.txt":          false,
+		"cdr-20251302-150405.jsonl":        false,
+		"cdr-20250102-150405-0.jsonl":      false,
+		"cdr-20250102-150405-01.jsonl":     false,
+		"cdr-20250102-150405-x.jsonl":      false,
+		"cdr-20250102-150405extra.jsonl":   false,
+		"cdr-eu-20250102-150405.jsonl":     false,
+		"old-cdr-20250102-150405.jsonl.gz": false,
+	} {
+		if _, _, ok := w.backupKey(name); ok != want {
+			t.Errorf("backupKey(%s) ok = %t, want %t", name, ok, want)
+		}
+	}
+}
+
+func TestCleanUpSerialized(t *testing.T) {
+	dir := t.TempDir()
+	others := []string{"cdr-notes.jsonl", "cdr-eu-20250102-150405.jsonl", "cdr-20250102-150405.jsonl.bak"}
+	for _, name := range others {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := Open(filepath.Join(dir, "cdr.jsonl"), Config{MaxSize: 10, Compress: true, MaxBackups: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every write rotates the previous one, within the same second or so
+	for i := range 20 {
+		if _, err := fmt.Fprintf(w, "record %02d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backups, rest []string
+	for _, e := range entries {
+		if _, _, ok := w.backupKey(e.Name()); ok {
+			backups = append(backups, e.Name())
+		} else {
+			rest = append(rest, e.Name())
+		}
+	}
+	if len(backups) != 3 {
+		t.Errorf("backups %v, want the 3 newest", backups)
+	}
+	for _, name := range backups {
+		if filepath.Ext(name) != ".gz" {
+			t.Errorf("backup %s not compressed", name)
+		}
+	}
+	slices.SortFunc(backups, w.compareBackups)
+	for i, name := range backups {
+		if got := gunzip(t, filepath.Join(dir, name)); got != fmt.Sprintf("record %02d\n", 16+i) {
+			t.Errorf("%s holds %q", name, got)
+		}
+	}
+	want := append(slices.Clone(others), "cdr.jsonl")
+	slices.Sort(want)
+	if fmt.Sprint(rest) != fmt.Sprint(want) {
+		t.Errorf("other files %v, want %v", rest, want)
+	}
+}
+
+func gunzip(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCleanUpLogsFailures(t *testing.T) {
+	var buf bytes.Buffer
+	dir := t.TempDir()
+	w := &Writer{path: filepath.Join(dir, "cdr.jsonl"), cfg: Config{Compress: true, Logger: slog.New(slog.NewTextHandler(&buf, nil))}}
+
+	missing := filepath.Join(dir, "cdr-20250102-150405.jsonl")
+	w.cleanUp(missing)
+	if out := buf.String(); !strings.Contains(out, "Failed to compress a rotated file") || !strings.Contains(out, "path="+missing) || !strings.Contains(out, "error=") {
+		t.Errorf("logged %q", out)
+	}
+}
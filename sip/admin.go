@@ -0,0 +1,316 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"siploadbalancer/cl"
+	"siploadbalancer/events"
+	. "siploadbalancer/global"
+)
+
+var (
+	ErrSipNodeNotFound = errors.New("SIP server not found")
+	ErrInvalidState    = errors.New("invalid SIP server state")
+)
+
+// Settings holds the runtime tunables, nil fields are left unchanged
+type Settings struct {
+	Distribution             *Distribution    `json:"distribution"`
+	MaxCallAttemptsPerSecond *int             `json:"maxCallAttemptsPerSecond"`
+	ProbingInterval          *int             `json:"probingInterval"`
+	TimeoutTimerDuration     *int             `json:"timeoutTimerDuration"`
+	ClearTimerDuration       *int             `json:"clearTimerDuration"`
+	MaxDialogDuration        *int             `json:"maxDialogDuration"`
+	MinHealthyNodes          *int             `json:"minHealthyNodes"`
+	HitWindow                *int             `json:"hitWindow"`
+	CallLimiter              *cl.Settings     `json:"callLimiter"`
+	Trace                    *TraceSettings   `json:"trace"`
+	Quality                  *QualitySettings `json:"quality"`
+}
+
+// FindSipNode looks up a node by its key or, case-insensitively, its description
+func (lb *LoadBalancingNode) FindSipNode(id string) *SipNode {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if sn, ok := lb.sipNodesMap[id]; ok {
+		return sn
+	}
+	return Find(lb.SipNodes, func(x *SipNode) bool { return strings.EqualFold(x.Description, id) })
+}
+
+func (lb *LoadBalancingNode) GetSipNodes() []*SipNode {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return slices.Clone(lb.SipNodes)
+}
+
+func (lb *LoadBalancingNode) GetDistribution() Distribution {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return lb.Distribution
+}
+
+func (lb *LoadBalancingNode) GetHitWindow() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return lb.HitWindow
+}
+
+// NodeInfo is a consistent copy of a node for the API
+type NodeInfo struct {
+	UdpAddr       *net.UDPAddr
+	Description   string
+	Cost          int
+	Weight        int
+	Key           string
+	Hits          int
+	Answers       int
+	Rejects       int
+	LastHit       time.Time
+	State         NodeState
+	ActiveCalls   int
+	MaxSessions   int
+	IsAlive       bool
+	ProbeRTT      float64
+	QualityFactor float64
+}
+
+// Info copies sn while holding its lock
+func (sn *SipNode) Info() NodeInfo {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+
+	return NodeInfo{
+		UdpAddr:       sn.UdpAddr,
+		Description:   sn.Description,
+		Cost:          sn.Cost,
+		Weight:        sn.Weight,
+		Key:           sn.Key,
+		Hits:          sn.Hits,
+		Answers:       sn.Answers,
+		Rejects:       sn.Rejects,
+		LastHit:       sn.LastHit,
+		State:         sn.State,
+		ActiveCalls:   sn.ActiveCalls,
+		MaxSessions:   sn.MaxSessions,
+		IsAlive:       sn.IsAlive,
+		ProbeRTT:      sn.ProbeRTT,
+		QualityFactor: sn.QualityFactor,
+	}
+}
+
+func (lb *LoadBalancingNode) Nodes() []NodeInfo {
+	nodes := lb.GetSipNodes()
+	infos := make([]NodeInfo, 0, len(nodes))
+	for _, sn := range nodes {
+		infos = append(infos, sn.Info())
+	}
+	return infos
+}
+
+// ConfigInfo is a consistent copy of the running state for the API
+type ConfigInfo struct {
+	SipNodes             []NodeInfo      `json:"sipNodes"`
+	Distribution         Distribution    `json:"distribution"`
+	ProbingInterval      int             `json:"probingInterval"`
+	TimeoutTimerDuration int             `json:"timeoutTimerDuration"`
+	ClearTimerDuration   int             `json:"clearTimerDuration"`
+	MaxDialogDuration    int             `json:"maxDialogDuration"`
+	MinHealthyNodes      int             `json:"minHealthyNodes"`
+	HitWindow            int             `json:"hitWindow"`
+	Trace                TraceSettings   `json:"trace"`
+	Quality              QualitySettings `json:"quality"`
+	SipNodesLB           []string        `json:"sipNodesLB"`
+}
+
+func (lb *LoadBalancingNode) Config() ConfigInfo {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	ci := ConfigInfo{
+		SipNodes:             make([]NodeInfo, 0, len(lb.SipNodes)),
+		Distribution:         lb.Distribution,
+		ProbingInterval:      lb.ProbingInterval,
+		TimeoutTimerDuration: lb.TimeoutTimerDuration,
+		ClearTimerDuration:   lb.ClearTimerDuration,
+		MaxDialogDuration:    lb.MaxDialogDuration,
+		MinHealthyNodes:      lb.MinHealthyNodes,
+		HitWindow:            lb.HitWindow,
+		Trace:                lb.Trace,
+		Quality:              lb.Quality,
+		SipNodesLB:           slices.Clone(lb.SipNodesLB),
+	}
+	for _, sn := range lb.SipNodes {
+		ci.SipNodes = append(ci.SipNodes, sn.Info())
+	}
+	return ci
+}
+
+func (lb *LoadBalancingNode) AddServer(srvr ServerData, persist bool) (*SipNode, error) {
+	var sn *SipNode
+	err := lb.changeConfig(persist, func(in *inputData) error {
+		in.Servers = append(in.Servers, srvr)
+		return nil
+	})
+	if err == nil || IsNotPersisted(err) {
+		sn = lb.findSipNodeByAddr(srvr.socket())
+	}
+	return sn, err
+}
+
+// UpdateServer changes the description, weight, cost and maxSessions of a node. The
+// address identifies the node and cannot be changed.
+func (lb *LoadBalancingNode) UpdateServer(id string, srvr ServerData, persist bool) (*SipNode, error) {
+	sn := lb.FindSipNode(id)
+	if sn == nil {
+		return nil, ErrSipNodeNotFound
+	}
+	if srvr.Ipv4 == "" && srvr.Port == 0 {
+		srvr.Ipv4, srvr.Port = sn.UdpAddr.IP.String(), sn.UdpAddr.Port
+	}
+	if srvr.socket() != sn.UdpAddr.String() {
+		return nil, fmt.Errorf("SIP server address cannot be changed, remove and add it instead")
+	}
+
+	err := lb.changeConfig(persist, func(in *inputData) error {
+		idx := slices.IndexFunc(in.Servers, func(x ServerData) bool { return x.socket() == srvr.socket() })
+		if idx == -1 {
+			return ErrSipNodeNotFound
+		}
+		in.Servers[idx] = srvr
+		return nil
+	})
+	return sn, err
+}
+
+func (lb *LoadBalancingNode) RemoveServer(id string, persist bool) error {
+	sn := lb.FindSipNode(id)
+	if sn == nil {
+		return ErrSipNodeNotFound
+	}
+
+	return lb.changeConfig(persist, func(in *inputData) error {
+		idx := slices.IndexFunc(in.Servers, func(x ServerData) bool { return x.socket() == sn.UdpAddr.String() })
+		if idx == -1 {
+			return ErrSipNodeNotFound
+		}
+		in.Servers = slices.Delete(in.Servers, idx, idx+1)
+		return nil
+	})
+}
+
+func (lb *LoadBalancingNode) SetServerState(id string, state NodeState) (*SipNode, error) {
+	switch state {
+	case NodeEnabled, NodeDisabled, NodeDraining:
+	default:
+		return nil, ErrInvalidState
+	}
+
+	sn := lb.FindSipNode(id)
+	if sn == nil {
+		return nil, ErrSipNodeNotFound
+	}
+	sn.SetState(state)
+	return sn, nil
+}
+
+func (lb *LoadBalancingNode) UpdateSettings(st Settings, persist bool) error {
+	return lb.changeConfig(persist, func(in *inputData) error {
+		if st.Distribution != nil {
+			in.LoadbalanceMode = string(*st.Distribution)
+		}
+		if st.MaxCallAttemptsPerSecond != nil {
+			in.MaxCallAttemptsPerSecond = *st.MaxCallAttemptsPerSecond
+		}
+		if st.ProbingInterval != nil {
+			in.ProbingInterval = *st.ProbingInterval
+		}
+		if st.TimeoutTimerDuration != nil {
+			in.TimeoutTimerDuration = *st.TimeoutTimerDuration
+		}
+		if st.ClearTimerDuration != nil {
+			in.ClearTimerDuration = *st.ClearTimerDuration
+		}
+		if st.MaxDialogDuration != nil {
+			in.MaxDialogDuration = *st.MaxDialogDuration
+		}
+		if st.MinHealthyNodes != nil {
+			in.MinHealthyNodes = *st.MinHealthyNodes
+		}
+		if st.HitWindow != nil {
+			in.HitWindow = *st.HitWindow
+		}
+		if st.CallLimiter != nil {
+			in.CallLimiter = *st.CallLimiter
+		}
+		if st.Trace != nil {
+			in.Trace = *st.Trace
+		}
+		if st.Quality != nil {
+			in.Quality = *st.Quality
+		}
+		return nil
+	})
+}
+
+var errNotPersisted = errors.New("change applied but not persisted")
+
+// changeConfig runs modify on a copy of the running configuration, validates
+// and applies the result, then optionally writes it back to the config file.
+func (lb *LoadBalancingNode) changeConfig(persist bool, modify func(in *inputData) error) error {
+	lb.cfgMu.Lock()
+	defer lb.cfgMu.Unlock()
+
+	in := lb.config
+	in.Servers = slices.Clone(lb.config.Servers)
+	in.CallLimiter.Quotas = slices.Clone(lb.config.CallLimiter.Quotas) // validated in place
+	if err := modify(&in); err != nil {
+		return err
+	}
+	in.setDefaults()
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	lb.applyConfig(in)
+	events.Publish(events.ConfigReloaded, ConfigEvent{Source: "api"})
+
+	if persist {
+		if err := PersistConfig(in); err != nil {
+			return fmt.Errorf("%w: %w", errNotPersisted, err)
+		}
+	}
+	return nil
+}
+
+func IsNotPersisted(err error) bool {
+	return errors.Is(err, errNotPersisted)
+}
+
+func (lb *LoadBalancingNode) findSipNodeByAddr(socket string) *SipNode {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return Find(lb.SipNodes, func(x *SipNode) bool { return x.UdpAddr.String() == socket })
+}
+
+func (lb *LoadBalancingNode) findSipNodeByUDPAddr(addr *net.UDPAddr) *SipNode {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return Find(lb.SipNodes, func(x *SipNode) bool { return AreUAddrsEqual(x.UdpAddr, addr) })
+}
+
+// settings returns the running configuration, it must not be modified
+func (lb *LoadBalancingNode) settings() *inputData {
+	return lb.running.Load()
+}
@@ -0,0 +1,121 @@
+package sip
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"siploadbalancer/cl"
+	. "siploadbalancer/global"
+)
+
+func TestAdminServerRoundTrip(t *testing.T) {
+	lb := startTestConfig(t, testConfig(t, 60, testServer(newTestPeer(t), "core-a", 1)))
+	peer := newTestPeer(t)
+
+	sn, err := lb.AddServer(testServer(peer, "core-b", 2), false)
+	if err != nil || sn == nil {
+		t.Fatalf("AddServer = %v, %v", sn, err)
+	}
+	if lb.FindSipNode(sn.Key) != sn || len(lb.GetSipNodes()) != 2 {
+		t.Fatal("added server not running")
+	}
+	if got := lb.Config().SipNodes; len(got) != 2 || got[1].Description != "core-b" {
+		t.Errorf("configured servers = %+v", got)
+	}
+
+	if _, err := lb.AddServer(testServer(peer, "core-b2", 1), false); err == nil {
+		t.Error("AddServer accepted a second server on the same address")
+	}
+
+	updated, err := lb.UpdateServer(sn.Key, ServerData{Description: "core-b2", Weight: 3, Cost: 7}, false)
+	if err != nil || updated != sn {
+		t.Fatalf("UpdateServer = %v, %v, want the same node", updated, err)
+	}
+	if info := sn.Info(); info.Description != "core-b2" || info.Weight != 3 || info.Cost != 7 {
+		t.Errorf("updated server = %+v", info)
+	}
+
+	moved := testServer(newTestPeer(t), "core-b2", 3)
+	if _, err := lb.UpdateServer(sn.Key, moved, false); err == nil {
+		t.Error("UpdateServer accepted an address change")
+	}
+	if sn.Info().UdpAddr.Port != peer.addr().Port {
+		t.Error("address changed by a rejected update")
+	}
+
+	if err := lb.RemoveServer(sn.Key, false); err != nil {
+		t.Fatal(err)
+	}
+	if lb.FindSipNode(sn.Key) != nil || len(lb.Config().SipNodes) != 1 {
+		t.Error("removed server still running or configured")
+	}
+	if err := lb.RemoveServer(sn.Key, false); !errors.Is(err, ErrSipNodeNotFound) {
+		t.Errorf("removing it again = %v, want ErrSipNodeNotFound", err)
+	}
+	if _, err := lb.UpdateServer("nope", ServerData{}, false); !errors.Is(err, ErrSipNodeNotFound) {
+		t.Errorf("UpdateServer of an unknown id = %v, want ErrSipNodeNotFound", err)
+	}
+}
+
+func TestAdminRejectsInvalid(t *testing.T) {
+	lb := startTestConfig(t, testConfig(t, 60, testServer(newTestPeer(t), "core-a", 1)))
+
+	bad := testServer(newTestPeer(t), "core-b", -1)
+	if _, err := lb.AddServer(bad, false); err == nil {
+		t.Error("AddServer accepted a negative weight")
+	}
+	window := MaxHitWindow + 1
+	if err := lb.UpdateSettings(Settings{HitWindow: &window}, false); err == nil {
+		t.Error("UpdateSettings accepted an invalid hitWindow")
+	}
+	if len(lb.GetSipNodes()) != 1 || lb.GetHitWindow() != 60 {
+		t.Errorf("rejected changes applied: %d servers, hitWindow %d", len(lb.GetSipNodes()), lb.GetHitWindow())
+	}
+}
+
+func TestAdminNotPersisted(t *testing.T) {
+	lb := startTestConfig(t, testConfig(t, 60, testServer(newTestPeer(t), "core-a", 1)))
+	prev := ConfigPath
+	ConfigPath = ""
+	t.Cleanup(func() { ConfigPath = prev })
+
+	sn, err := lb.AddServer(testServer(newTestPeer(t), "core-b", 1), true)
+	if !IsNotPersisted(err) {
+		t.Fatalf("AddServer without a configuration file = %v, want errNotPersisted", err)
+	}
+	if sn == nil || lb.FindSipNode(sn.Key) == nil {
+		t.Error("change not applied when it could not be persisted")
+	}
+
+	window := 120
+	if err := lb.UpdateSettings(Settings{HitWindow: &window}, true); !IsNotPersisted(err) || lb.GetHitWindow() != 120 {
+		t.Errorf("UpdateSettings = %v with hitWindow %d, want applied but not persisted", err, lb.GetHitWindow())
+	}
+}
+
+func TestAdminCopiesQuotas(t *testing.T) {
+	var in inputData
+	if err := json.Unmarshal(testConfig(t, 60), &in); err != nil {
+		t.Fatal(err)
+	}
+	in.CallLimiter.Quotas = []cl.Quota{{Name: "tenant", Sources: []string{"10.1.0.0/16"}, Rate: 5}}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := startTestConfig(t, data)
+	running := lb.settings().CallLimiter.Quotas
+
+	window := 120
+	if err := lb.UpdateSettings(Settings{HitWindow: &window}, false); err != nil {
+		t.Fatal(err)
+	}
+	// the quotas are validated in place, which must not touch those of the running configuration
+	if updated := lb.settings().CallLimiter.Quotas; len(updated) != 1 || &updated[0] == &running[0] {
+		t.Error("quotas shared between the running and the changed configuration")
+	}
+	if running[0].Name != "tenant" || lb.settings().CallLimiter.Quotas[0].Name != "tenant" {
+		t.Errorf("quotas %+v", lb.settings().CallLimiter.Quotas)
+	}
+}
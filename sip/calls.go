@@ -0,0 +1,244 @@
+package sip
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"siploadbalancer/events"
+	. "siploadbalancer/global"
+)
+
+const (
+	maxCallHistory   = 100
+	DefaultCallLimit = 100
+	MaxCallLimit     = 1000
+
+	DirectionInbound  = "inbound"  // from access towards a SIP server
+	DirectionOutbound = "outbound" // from a SIP server towards access
+
+	SourcePeer  = "peer"  // the access side of the dialogue
+	SourceNode  = "node"  // the SIP server of the dialogue
+	SourceLocal = "local" // generated by the balancer
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type (
+	messageRecord struct {
+		Time    time.Time `json:"time"`
+		Message string    `json:"message"`
+		Source  string    `json:"source"`
+	}
+
+	// CallInfo is the API view of a CallCache
+	CallInfo struct {
+		CallID     string          `json:"callId"`
+		Node       string          `json:"node"`
+		NodeKey    string          `json:"nodeKey"`
+		NodeAddr   string          `json:"nodeAddr"`
+		PeerAddr   string          `json:"peerAddr"`
+		Direction  string          `json:"direction"`
+		Status     Status          `json:"status"`
+		StartTime  time.Time       `json:"startTime"`
+		AnswerTime *time.Time      `json:"answerTime,omitempty"`
+		EndTime    *time.Time      `json:"endTime,omitempty"`
+		Messages   []messageRecord `json:"messages,omitempty"`
+	}
+
+	CallFilter struct {
+		Status Status
+		Node   string // key or description
+		From   time.Time
+		Limit  int
+		Cursor string
+	}
+
+	CallPage struct {
+		Calls      []CallInfo `json:"calls"`
+		NextCursor string     `json:"nextCursor,omitempty"`
+	}
+)
+
+// must be called while holding cc.mu
+func (cc *CallCache) addHistory(sipmsg *SipMessage, srcAddr *net.UDPAddr) {
+	if len(cc.history) >= maxCallHistory {
+		return
+	}
+	src := SourcePeer
+	if cc.SIPNode != nil && AreUAddrsEqual(srcAddr, cc.SIPNode.UdpAddr) {
+		src = SourceNode
+	}
+	cc.history = append(cc.history, messageRecord{Time: time.Now().UTC(), Message: sipmsg.String(), Source: src})
+}
+
+// must be called while holding cc.mu
+func (cc *CallCache) addLocalHistory(sipmsg *SipMessage) {
+	if len(cc.history) >= maxCallHistory {
+		return
+	}
+	cc.history = append(cc.history, messageRecord{Time: time.Now().UTC(), Message: sipmsg.String(), Source: SourceLocal})
+}
+
+// must be called while holding cc.mu
+func (cc *CallCache) setAnswered() {
+	if cc.AnswerTime.IsZero() {
+		cc.AnswerTime = time.Now().UTC()
+		cc.recordOutcome()
+		cc.publishCallEvent(events.CallAnswer)
+	}
+}
+
+// must be called while holding cc.mu
+func (cc *CallCache) setEnded() {
+	if cc.EndTime.IsZero() {
+		cc.EndTime = time.Now().UTC()
+		if cc.AnswerTime.IsZero() {
+			cc.recordOutcome()
+		} else {
+			cc.recordDuration()
+		}
+		cc.publishCallEvent(events.CallEnd)
+		cc.endSpan(cc.EndTime)
+		cc.releaseCall()
+	}
+}
+
+func (cc *CallCache) info(withHistory bool) CallInfo {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+
+	ci := CallInfo{
+		CallID:    cc.CallID,
+		Status:    cc.CallStatus,
+		StartTime: cc.StartTime,
+		Direction: DirectionOutbound,
+	}
+	if cc.IsInbound {
+		ci.Direction = DirectionInbound
+	}
+	if cc.SIPNode != nil {
+		ci.NodeKey = cc.SIPNode.Key
+		ci.Node = cc.SIPNode.GetDescription()
+		ci.NodeAddr = cc.SIPNode.UdpAddr.String()
+	}
+	if cc.OtherAddr != nil {
+		ci.PeerAddr = cc.OtherAddr.String()
+	}
+	if !cc.AnswerTime.IsZero() {
+		t := cc.AnswerTime
+		ci.AnswerTime = &t
+	}
+	if !cc.EndTime.IsZero() {
+		t := cc.EndTime
+		ci.EndTime = &t
+	}
+	if withHistory {
+		ci.Messages = slices.Clone(cc.history)
+	}
+	return ci
+}
+
+// AllCalls returns every cached dialogue without message history
+func (lb *LoadBalancingNode) AllCalls() []CallInfo {
+	ccs := lb.callsSnapshot()
+	calls := make([]CallInfo, 0, len(ccs))
+	for _, cc := range ccs {
+		calls = append(calls, cc.info(false))
+	}
+	return calls
+}
+
+func (lb *LoadBalancingNode) GetCall(callID string) (CallInfo, bool) {
+	cc := lb.getCallCache(callID)
+	if cc == nil {
+		return CallInfo{}, false
+	}
+	return cc.info(true), true
+}
+
+// Calls returns the cached dialogues matching f ordered by start time, one
+// page at a time. NextCursor is set when more calls are available.
+func (lb *LoadBalancingNode) Calls(f CallFilter) (CallPage, error) {
+	var (
+		afterTime time.Time
+		afterID   string
+	)
+	if f.Cursor != "" {
+		var err error
+		if afterTime, afterID, err = decodeCursor(f.Cursor); err != nil {
+			return CallPage{}, err
+		}
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultCallLimit
+	}
+	f.Limit = min(f.Limit, MaxCallLimit)
+
+	var node *SipNode
+	if f.Node != "" {
+		if node = lb.FindSipNode(f.Node); node == nil {
+			return CallPage{Calls: []CallInfo{}}, nil
+		}
+	}
+
+	calls := make([]CallInfo, 0)
+	for _, cc := range lb.callsSnapshot() {
+		if node != nil && cc.SIPNode != node {
+			continue
+		}
+		ci := cc.info(false)
+		if f.Status != "" && !strings.EqualFold(string(ci.Status), string(f.Status)) {
+			continue
+		}
+		if ci.StartTime.Before(f.From) {
+			continue
+		}
+		if f.Cursor != "" && compareCalls(ci.StartTime, ci.CallID, afterTime, afterID) <= 0 {
+			continue
+		}
+		calls = append(calls, ci)
+	}
+
+	slices.SortFunc(calls, func(a, b CallInfo) int { return compareCalls(a.StartTime, a.CallID, b.StartTime, b.CallID) })
+
+	page := CallPage{Calls: calls}
+	if len(calls) > f.Limit {
+		page.Calls = calls[:f.Limit]
+		last := page.Calls[f.Limit-1]
+		page.NextCursor = encodeCursor(last.StartTime, last.CallID)
+	}
+	return page, nil
+}
+
+func compareCalls(t1 time.Time, id1 string, t2 time.Time, id2 string) int {
+	if c := t1.Compare(t2); c != 0 {
+		return c
+	}
+	return cmp.Compare(id1, id2)
+}
+
+func encodeCursor(t time.Time, callID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixNano(), 10) + ":" + callID))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	nanos, callID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return time.Unix(0, n).UTC(), callID, nil
+}
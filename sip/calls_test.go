@@ -0,0 +1,61 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)
+	for _, callID := range []string{"a84b4c76e66710", "3848276298220188511@atlanta.example.com", "id:with:colons", ""} {
+		cursor := encodeCursor(start, callID)
+		gotTime, gotID, err := decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", cursor, err)
+		}
+		if !gotTime.Equal(start) || gotID != callID {
+			t.Errorf("round trip of (%v, %q) = (%v, %q)", start, callID, gotTime, gotID)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, cursor := range []string{"!!!", "bm9jb2xvbg", "YWJjOmlk"} { // not base64, no colon, not a number
+		if _, _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestCallsPagination(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	lb := &LoadBalancingNode{callsCache: make(map[string]*CallCache)}
+	for i := range 5 {
+		id := fmt.Sprintf("call-%d", i)
+		// two calls share each start time, so the Call-ID breaks the tie
+		lb.callsCache[id] = &CallCache{CallID: id, StartTime: start.Add(time.Duration(i/2) * time.Second), CallStatus: StatusProgressing}
+	}
+	lb.callsCache["probe"] = &CallCache{CallID: "probe", IsProbing: true}
+
+	var got []string
+	cursor := ""
+	for range 5 {
+		page, err := lb.Calls(CallFilter{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ci := range page.Calls {
+			got = append(got, ci.CallID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	want := []string{"call-0", "call-1", "call-2", "call-3", "call-4"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package sip
+
+import (
+	"time"
+
+	"siploadbalancer/cdr"
+	. "siploadbalancer/global"
+)
+
+// writeCDR queues the record of a dialogue leaving the cache
+func (cc *CallCache) writeCDR() {
+	if !cdr.IsEnabled() {
+		return
+	}
+
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+
+	rec := cdr.Record{
+		CallID:           cc.CallID,
+		Method:           string(cc.dialog.method),
+		FromUser:         uriUser(cc.dialog.from),
+		ToUser:           uriUser(cc.dialog.to),
+		Direction:        DirectionOutbound,
+		Node:             cc.SIPNode.GetDescription(),
+		NodeAddr:         cc.SIPNode.UdpAddr.String(),
+		StartTime:        cc.StartTime,
+		Status:           string(cc.CallStatus),
+		FinalCode:        cc.finalCode,
+		FailoverAttempts: cc.failovers,
+	}
+	if cc.IsInbound {
+		rec.Direction = DirectionInbound
+		rec.SourceAddr = cc.OtherAddr.String()
+	} else {
+		rec.SourceAddr = cc.SIPNode.UdpAddr.String()
+	}
+	if !cc.AnswerTime.IsZero() {
+		t := cc.AnswerTime
+		rec.AnswerTime = &t
+	}
+	if !cc.EndTime.IsZero() {
+		t := cc.EndTime
+		rec.EndTime = &t
+	}
+	if rec.AnswerTime != nil && rec.EndTime != nil {
+		rec.Duration = rec.EndTime.Sub(*rec.AnswerTime).Round(time.Millisecond).Seconds()
+	}
+
+	cdr.Write(rec)
+}
+
+// uriUser returns the user part of the URI in a From or To header value
+func uriUser(hdr string) string {
+	var matches []string
+	if !RMatch(hdr, URIFull, &matches) {
+		return ""
+	}
+	uri := matches[1]
+	if RMatch(uri, INVITERURI, &matches) {
+		return matches[2]
+	}
+	return ""
+}
+
+// uriHost returns the host part of the URI in a From or To header value
+func uriHost(hdr string) string {
+	var matches []string
+	if !RMatch(hdr, URIFull, &matches) {
+		return ""
+	}
+	uri := matches[1]
+	if RMatch(uri, INVITERURI, &matches) {
+		return matches[5]
+	}
+	return ""
+}
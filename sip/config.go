@@ -0,0 +1,309 @@
+package sip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"siploadbalancer/cl"
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+type inputData struct {
+	IPv4          string `json:"ipv4"`
+	SipUdpPort    int    `json:"sipUdpPort"`
+	HttpPort      int    `json:"httpPort"`
+	CachingServer string `json:"cachingServer"`
+
+	LoadbalanceMode          string `json:"loadbalancemode"`
+	MaxCallAttemptsPerSecond int    `json:"maxCallAttemptsPerSecond"`
+	ProbingInterval          int    `json:"probingInterval"`
+	TimeoutTimerDuration     int    `json:"timeoutTimerDuration"`
+	ClearTimerDuration       int    `json:"clearTimerDuration"`
+	MaxDialogDuration        int    `json:"maxDialogDuration"`
+	MinHealthyNodes          int    `json:"minHealthyNodes"`
+	HitWindow                int    `json:"hitWindow"` // seconds
+
+	CallLimiter cl.Settings     `json:"callLimiter"`
+	Trace       TraceSettings   `json:"trace"`
+	Quality     QualitySettings `json:"quality"`
+
+	Servers []ServerData `json:"servers"`
+}
+
+type ServerData struct {
+	Ipv4        string `json:"ipv4"`
+	Port        int    `json:"port"`
+	Description string `json:"description"`
+	Weight      int    `json:"weight"`
+	Cost        int    `json:"cost"`
+	MaxSessions int    `json:"maxSessions"` // simultaneous dialogues (0=unlimited)
+}
+
+func parseInputData(data []byte) (inputData, error) {
+	var in inputData
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in, err
+	}
+	in.setDefaults()
+	return in, in.validate()
+}
+
+// parseStartupData is parseInputData, except that invalid and duplicate
+// server records are skipped as they always were at startup, rather than
+// rejecting the whole configuration as reloads do
+func parseStartupData(data []byte) (inputData, error) {
+	var in inputData
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in, err
+	}
+	in.setDefaults()
+	in.skipInvalidServers()
+	return in, in.validate()
+}
+
+func (in *inputData) skipInvalidServers() {
+	servers := make([]ServerData, 0, len(in.Servers))
+	for i, srvr := range in.Servers {
+		if err := srvr.validate(); err != nil {
+			logger.Warn("Invalid server record - Skipped", "index", i, logging.Err(err))
+			continue
+		}
+		if slices.ContainsFunc(servers, func(x ServerData) bool {
+			return x.socket() == srvr.socket() || strings.EqualFold(x.Description, srvr.Description)
+		}) {
+			logger.Warn("Duplicate server record - Skipped", "index", i, "server", srvr.Description)
+			continue
+		}
+		servers = append(servers, srvr)
+	}
+	in.Servers = servers
+}
+
+func (in *inputData) setDefaults() {
+	if in.TimeoutTimerDuration == 0 {
+		in.TimeoutTimerDuration = int(TimeoutTimerDD / time.Second)
+	}
+	if in.ClearTimerDuration == 0 {
+		in.ClearTimerDuration = int(ClearTimerDD / time.Second)
+	}
+	if in.MaxDialogDuration == 0 {
+		in.MaxDialogDuration = int(MaxDialogDD / time.Second)
+	}
+	if in.MinHealthyNodes == 0 {
+		in.MinHealthyNodes = DefaultMinHealthyNodes
+	}
+	if in.HitWindow == 0 {
+		in.HitWindow = DefaultHitWindow
+	}
+	in.CallLimiter.SetDefaults()
+	in.Trace.setDefaults()
+	in.Quality.setDefaults()
+}
+
+func (in *inputData) validate() error {
+	if net.ParseIP(in.IPv4) == nil {
+		return fmt.Errorf("ipv4 [%s] is invalid", in.IPv4)
+	}
+	if !isValidPort(in.SipUdpPort) {
+		return fmt.Errorf("sipUdpPort [%d] is invalid", in.SipUdpPort)
+	}
+	if !isValidPort(in.HttpPort) {
+		return fmt.Errorf("httpPort [%d] is invalid", in.HttpPort)
+	}
+	if !Distribution(in.LoadbalanceMode).IsValid() {
+		return fmt.Errorf("loadbalancemode [%s] is unknown", in.LoadbalanceMode)
+	}
+	if in.MaxCallAttemptsPerSecond < -1 {
+		return fmt.Errorf("maxCallAttemptsPerSecond [%d] is invalid", in.MaxCallAttemptsPerSecond)
+	}
+	if in.ProbingInterval <= 0 {
+		return fmt.Errorf("probingInterval [%d] must be positive", in.ProbingInterval)
+	}
+	if in.TimeoutTimerDuration < 0 {
+		return fmt.Errorf("timeoutTimerDuration [%d] is invalid", in.TimeoutTimerDuration)
+	}
+	if in.ClearTimerDuration < 0 {
+		return fmt.Errorf("clearTimerDuration [%d] is invalid", in.ClearTimerDuration)
+	}
+	if in.MaxDialogDuration < 0 {
+		return fmt.Errorf("maxDialogDuration [%d] is invalid", in.MaxDialogDuration)
+	}
+	if in.MinHealthyNodes < 0 {
+		return fmt.Errorf("minHealthyNodes [%d] is invalid", in.MinHealthyNodes)
+	}
+	if err := in.CallLimiter.Validate(); err != nil {
+		return err
+	}
+	if in.HitWindow < 0 || in.HitWindow > MaxHitWindow {
+		return fmt.Errorf("hitWindow [%d] must be between 1 and %d", in.HitWindow, MaxHitWindow)
+	}
+	if in.Trace.MaxMessagesPerCall < 0 {
+		return fmt.Errorf("trace.maxMessagesPerCall [%d] is invalid", in.Trace.MaxMessagesPerCall)
+	}
+	if err := in.Quality.validate(); err != nil {
+		return err
+	}
+
+	grandweight := 0
+	for i, srvr := range in.Servers {
+		if err := srvr.validate(); err != nil {
+			return fmt.Errorf("servers[%d]: %w", i, err)
+		}
+		for _, other := range in.Servers[:i] {
+			if other.socket() == srvr.socket() || strings.EqualFold(other.Description, srvr.Description) {
+				return fmt.Errorf("servers[%d]: duplicate server record [%s]", i, srvr.Description)
+			}
+		}
+		grandweight += srvr.Weight
+	}
+	if Distribution(in.LoadbalanceMode) == DistribWeighted && len(in.Servers) > 0 && grandweight == 0 {
+		return errors.New("Weighted distribution requires at least one server with a positive weight")
+	}
+
+	return nil
+}
+
+func (srvr *ServerData) validate() error {
+	if net.ParseIP(srvr.Ipv4) == nil {
+		return fmt.Errorf("SIP Server IPv4: %s - invalid", srvr.Ipv4)
+	}
+	if !isValidPort(srvr.Port) {
+		return fmt.Errorf("SIP Server Port: %d - invalid", srvr.Port)
+	}
+	if srvr.Weight < 0 {
+		return fmt.Errorf("SIP Server Weight: %d - invalid", srvr.Weight)
+	}
+	if srvr.MaxSessions < 0 {
+		return fmt.Errorf("SIP Server MaxSessions: %d - invalid", srvr.MaxSessions)
+	}
+	return nil
+}
+
+func (srvr *ServerData) udpAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(srvr.Ipv4), Port: srvr.Port}
+}
+
+func (srvr *ServerData) socket() string {
+	return srvr.udpAddr().String()
+}
+
+func isValidPort(prt int) bool {
+	return 0 < prt && prt <= 65535
+}
+
+// ==========================================================================
+
+func reloadConfig(data []byte) (func(), error) {
+	in, err := parseInputData(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		LoadBalancer.cfgMu.Lock()
+		defer LoadBalancer.cfgMu.Unlock()
+		LoadBalancer.applyConfig(in)
+	}, nil
+}
+
+// applyConfig diffs the running configuration against in and applies the
+// changes live. Existing SipNodes are updated in place so that CallCache
+// entries keep pointing to the same node. Callers must hold lb.cfgMu.
+func (lb *LoadBalancingNode) applyConfig(in inputData) {
+	old := lb.config
+	if old.IPv4 != in.IPv4 || old.SipUdpPort != in.SipUdpPort || old.HttpPort != in.HttpPort {
+		logger.Warn("Changes to ipv4, sipUdpPort or httpPort require a restart - Ignored")
+	}
+
+	if old.MaxCallAttemptsPerSecond != in.MaxCallAttemptsPerSecond && CallLimiter != nil {
+		CallLimiter.SetRate(in.MaxCallAttemptsPerSecond)
+	}
+	if !old.CallLimiter.Equal(in.CallLimiter) && CallLimiter != nil {
+		CallLimiter.SetSettings(in.CallLimiter)
+	}
+
+	lb.mu.Lock()
+
+	current := make(map[string]*SipNode, len(lb.SipNodes))
+	for _, sn := range lb.SipNodes {
+		current[sn.UdpAddr.String()] = sn
+	}
+
+	var added, updated []*SipNode
+	sipnodes := make([]*SipNode, 0, len(in.Servers))
+	sipNodesMap := make(map[string]*SipNode, len(in.Servers))
+	for _, srvr := range in.Servers {
+		sn, ok := current[srvr.socket()]
+		if ok {
+			delete(current, srvr.socket())
+			if desc := sn.GetDescription(); desc != srvr.Description {
+				Prometrics.DeleteNode(desc)
+			}
+			if sn.update(srvr) {
+				updated = append(updated, sn)
+			}
+		} else {
+			sn = newSipNode(srvr, in.Quality.windowSize(), hitWindowSize(in.HitWindow))
+			added = append(added, sn)
+		}
+		sipnodes = append(sipnodes, sn)
+		sipNodesMap[sn.Key] = sn
+	}
+
+	lb.SipNodes = sipnodes
+	lb.sipNodesMap = sipNodesMap
+	lb.SipNodesLB = computeSipNodesLB(sipnodes)
+	lb.nodeIdx = 0
+
+	if lb.Distribution != Distribution(in.LoadbalanceMode) {
+		logger.Info("Distribution changed", "previous", lb.Distribution, "distribution", in.LoadbalanceMode)
+		lb.Distribution = Distribution(in.LoadbalanceMode)
+	}
+	if lb.ProbingInterval != in.ProbingInterval {
+		lb.ProbingInterval = in.ProbingInterval
+		if lb.probingTicker != nil {
+			lb.probingTicker.Reset(time.Duration(in.ProbingInterval) * time.Second)
+		}
+	}
+	lb.TimeoutTimerDuration = in.TimeoutTimerDuration
+	lb.ClearTimerDuration = in.ClearTimerDuration
+	lb.MaxDialogDuration = in.MaxDialogDuration
+	lb.MinHealthyNodes = in.MinHealthyNodes
+	lb.Trace = in.Trace
+	if lb.HitWindow != in.HitWindow {
+		for _, sn := range sipnodes {
+			sn.resetCounters(hitWindowSize(in.HitWindow))
+		}
+		lb.HitWindow = in.HitWindow
+	}
+	if lb.Quality.Window != in.Quality.Window {
+		for _, sn := range sipnodes {
+			sn.resetQuality(in.Quality.windowSize())
+		}
+	}
+	lb.Quality = in.Quality
+	lb.config = in
+	lb.running.Store(&in)
+
+	for _, sn := range added {
+		lb.probeSipNode(sn)
+	}
+
+	lb.mu.Unlock()
+
+	for _, sn := range added {
+		logger.Info("Server added", logging.KeyNode, sn.Key, "server", sn)
+	}
+	for _, sn := range updated {
+		logger.Info("Server updated", logging.KeyNode, sn.Key, "server", sn)
+	}
+	for _, sn := range current {
+		Prometrics.DeleteNode(sn.GetDescription())
+		logger.Info("Server removed", logging.KeyNode, sn.Key, "server", sn)
+	}
+}
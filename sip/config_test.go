@@ -0,0 +1,148 @@
+package sip
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"siploadbalancer/cl"
+)
+
+// testConfig returns a valid configuration file with the given servers
+func testConfig(t *testing.T, hitWindow int, servers ...ServerData) []byte {
+	t.Helper()
+	data, err := json.Marshal(inputData{
+		IPv4:                     "127.0.0.1",
+		SipUdpPort:               5060,
+		HttpPort:                 9080,
+		LoadbalanceMode:          string(DistribRoundRobin),
+		MaxCallAttemptsPerSecond: -1,
+		ProbingInterval:          60,
+		HitWindow:                hitWindow,
+		CallLimiter:              cl.Settings{Mode: cl.ModeWindow},
+		Servers:                  servers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// startTestConfig installs a balancer running data, as at startup
+func startTestConfig(t *testing.T, data []byte) *LoadBalancingNode {
+	t.Helper()
+	in, err := parseInputData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestBalancer(t, NewLoadBalancer(in))
+	return LoadBalancer
+}
+
+func reload(t *testing.T, data []byte) error {
+	t.Helper()
+	apply, err := reloadConfig(data)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+func testServer(peer *testPeer, description string, weight int) ServerData {
+	return ServerData{Ipv4: "127.0.0.1", Port: peer.addr().Port, Description: description, Weight: weight}
+}
+
+func TestReloadRejectsInvalid(t *testing.T) {
+	a := testServer(newTestPeer(t), "core-a", 1)
+	lb := startTestConfig(t, testConfig(t, 60, a))
+	sn := lb.SipNodes[0]
+
+	bad := a
+	bad.Weight = -1
+	for name, data := range map[string][]byte{
+		"not json":          []byte(`{"servers": [`),
+		"invalid server":    testConfig(t, 60, bad),
+		"duplicate server":  testConfig(t, 60, a, a),
+		"invalid hitWindow": testConfig(t, MaxHitWindow+1, a),
+	} {
+		if err := reload(t, data); err == nil {
+			t.Errorf("%s: reload accepted", name)
+		}
+	}
+
+	if len(lb.GetSipNodes()) != 1 || lb.GetSipNodes()[0] != sn || sn.GetDescription() != "core-a" {
+		t.Errorf("running servers changed by rejected reloads: %v", lb.Nodes())
+	}
+	if got := lb.settings().HitWindow; got != 60 {
+		t.Errorf("hitWindow = %d after rejected reloads, want 60", got)
+	}
+}
+
+func TestReloadDiffsServers(t *testing.T) {
+	pa, pb, pc, uac := newTestPeer(t), newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	lb := startTestConfig(t, testConfig(t, 60, testServer(pa, "core-a", 1), testServer(pb, "core-b", 1)))
+	a, b := lb.SipNodes[0], lb.SipNodes[1]
+	a.SetAlive(true)
+
+	cc, _ := lb.AddOrGetCallCache(testInvite(t, uac, "reload-diff"), uac.addr(), time.Now())
+	if cc == nil || cc.SIPNode != a {
+		t.Fatal("dialogue not routed to core-a")
+	}
+
+	updated := testServer(pa, "core-a2", 5)
+	updated.MaxSessions = 10
+	if err := reload(t, testConfig(t, 60, updated, testServer(pc, "core-c", 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := lb.GetSipNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("%d servers after reload, want 2", len(nodes))
+	}
+	if nodes[0] != a {
+		t.Error("updated server replaced by a new SipNode")
+	}
+	if desc := cc.SIPNode.GetDescription(); cc.SIPNode != a || desc != "core-a2" {
+		t.Errorf("dialogue points to %q, want the updated core-a2", desc)
+	}
+	if info := a.Info(); info.Weight != 5 || info.MaxSessions != 10 || !info.IsAlive {
+		t.Errorf("updated server = %+v, want weight 5, maxSessions 10 and still alive", info)
+	}
+	if c := nodes[1]; c == b || c.GetDescription() != "core-c" || c.UdpAddr.Port != pc.addr().Port {
+		t.Errorf("second server = %v, want the added core-c", c)
+	}
+	if lb.FindSipNode(b.Key) != nil {
+		t.Error("removed server still found")
+	}
+	if got := pc.receive(200 * time.Millisecond); len(got) != 1 || !strings.HasPrefix(got[0], "OPTIONS ") {
+		t.Errorf("added server received %q, want a probe", got)
+	}
+}
+
+func TestReloadHitWindowResetsCounters(t *testing.T) {
+	srvr := testServer(newTestPeer(t), "core-a", 1)
+	lb := startTestConfig(t, testConfig(t, 60, srvr))
+	sn := lb.SipNodes[0]
+	sn.AddHit()
+	sn.AddHit()
+
+	srvr.Weight = 2
+	if err := reload(t, testConfig(t, 60, srvr)); err != nil {
+		t.Fatal(err)
+	}
+	if got := sn.windowedHits(); got != 2 {
+		t.Errorf("hits = %d after a reload keeping hitWindow, want 2", got)
+	}
+
+	if err := reload(t, testConfig(t, 120, srvr)); err != nil {
+		t.Fatal(err)
+	}
+	if got := sn.windowedHits(); got != 0 || sn.Info().Hits != 0 {
+		t.Errorf("hits = %d after hitWindow changed, want 0", got)
+	}
+	if got := lb.GetHitWindow(); got != 120 {
+		t.Errorf("hitWindow = %d, want 120", got)
+	}
+}
@@ -0,0 +1,241 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+const (
+	DefaultDebugTTL = 600   // seconds
+	MaxDebugTTL     = 86400 // seconds
+	MaxDebugFilters = 50
+	maxDebugTraces  = 200 // traces of matched dialogues kept once they leave the cache
+)
+
+var (
+	ErrDebugFilterNotFound = errors.New("debug filter not found")
+	ErrTooManyDebugFilters = fmt.Errorf("at most %d debug filters can be active", MaxDebugFilters)
+)
+
+// DebugFilter selects new dialogues whose messages are traced and logged in
+// full until it expires. Every criterion set must match.
+type DebugFilter struct {
+	ID         string    `json:"id"`
+	FromUser   string    `json:"fromUser,omitempty"`
+	ToUser     string    `json:"toUser,omitempty"`
+	RURIPrefix string    `json:"ruriPrefix,omitempty"` // prefix of the R-URI or of its user part
+	SourceIP   string    `json:"sourceIp,omitempty"`   // address or CIDR
+	CallID     string    `json:"callId,omitempty"`
+	TTL        int       `json:"ttl,omitempty"` // seconds, when creating (Default 600)
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	Matches    int       `json:"matches"`
+
+	source netip.Prefix
+}
+
+var (
+	debugFilters []*DebugFilter
+	debugTraces  []CallTrace // oldest first
+	debugMu      sync.Mutex
+)
+
+func (df *DebugFilter) validate() error {
+	df.FromUser = strings.TrimSpace(df.FromUser)
+	df.ToUser = strings.TrimSpace(df.ToUser)
+	df.RURIPrefix = strings.TrimSpace(df.RURIPrefix)
+	df.CallID = strings.TrimSpace(df.CallID)
+	if df.FromUser == "" && df.ToUser == "" && df.RURIPrefix == "" && df.SourceIP == "" && df.CallID == "" {
+		return errors.New("at least one of fromUser, toUser, ruriPrefix, sourceIp or callId is required")
+	}
+	if df.TTL == 0 {
+		df.TTL = DefaultDebugTTL
+	}
+	if df.TTL < 0 || df.TTL > MaxDebugTTL {
+		return fmt.Errorf("ttl [%d] must be between 1 and %d", df.TTL, MaxDebugTTL)
+	}
+	if df.SourceIP == "" {
+		return nil
+	}
+	if strings.Contains(df.SourceIP, "/") {
+		prefix, err := netip.ParsePrefix(df.SourceIP)
+		if err != nil {
+			return fmt.Errorf("sourceIp [%s] is invalid", df.SourceIP)
+		}
+		df.source = prefix.Masked()
+		return nil
+	}
+	addr, err := netip.ParseAddr(df.SourceIP)
+	if err != nil {
+		return fmt.Errorf("sourceIp [%s] is invalid", df.SourceIP)
+	}
+	addr = addr.Unmap()
+	df.source = netip.PrefixFrom(addr, addr.BitLen())
+	return nil
+}
+
+func (df *DebugFilter) match(sipmsg *SipMessage, srcAddr *net.UDPAddr) bool {
+	if df.CallID != "" && df.CallID != sipmsg.CallID {
+		return false
+	}
+	if df.source.IsValid() && !df.source.Contains(srcAddr.AddrPort().Addr().Unmap()) {
+		return false
+	}
+	if df.FromUser != "" && df.FromUser != uriUser(firstHeaderValue(sipmsg, From)) {
+		return false
+	}
+	if df.ToUser != "" && df.ToUser != uriUser(firstHeaderValue(sipmsg, To)) {
+		return false
+	}
+	if df.RURIPrefix != "" {
+		ruri := sipmsg.StartLine.RUri
+		var matches []string
+		ruriUser := ""
+		if RMatch(ruri, INVITERURI, &matches) {
+			ruriUser = matches[2]
+		}
+		if !strings.HasPrefix(ruri, df.RURIPrefix) && !strings.HasPrefix(ruriUser, df.RURIPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneDebugFilters drops the expired filters, must be called while holding debugMu
+func pruneDebugFilters(now time.Time) {
+	debugFilters = slices.DeleteFunc(debugFilters, func(df *DebugFilter) bool { return !now.Before(df.Expires) })
+}
+
+// matchDebugFilter returns the id of the first active filter matching a
+// request, or an empty string
+func matchDebugFilter(sipmsg *SipMessage, srcAddr *net.UDPAddr) string {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	if len(debugFilters) == 0 {
+		return ""
+	}
+	pruneDebugFilters(time.Now())
+	for _, df := range debugFilters {
+		if df.match(sipmsg, srcAddr) {
+			df.Matches++
+			return df.ID
+		}
+	}
+	return ""
+}
+
+// logDebugRejection logs a request the balancer rejected when a debug filter matched it
+func logDebugRejection(filterID string, sipmsg *SipMessage, srcAddr *net.UDPAddr, code int, reason string) {
+	if filterID == "" {
+		return
+	}
+	callLog(sipmsg.CallID, srcAddr).Info("Debug trace - request rejected", "filter", filterID, "code", code, "reason", reason, "message", sipmsg.String())
+}
+
+// keepDebugTrace stores the trace of a matched dialogue leaving the cache
+func keepDebugTrace(ct CallTrace) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	if len(debugTraces) >= maxDebugTraces {
+		debugTraces = slices.Delete(debugTraces, 0, len(debugTraces)-maxDebugTraces+1)
+	}
+	debugTraces = append(debugTraces, ct)
+}
+
+func findDebugTrace(callID string) (CallTrace, bool) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	idx := slices.IndexFunc(debugTraces, func(ct CallTrace) bool { return ct.CallID == callID })
+	if idx == -1 {
+		return CallTrace{}, false
+	}
+	return debugTraces[idx], true
+}
+
+// ==========================================================================
+
+func (lb *LoadBalancingNode) AddDebugFilter(df DebugFilter) (DebugFilter, error) {
+	if err := df.validate(); err != nil {
+		return DebugFilter{}, err
+	}
+
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	now := time.Now().UTC()
+	pruneDebugFilters(now)
+	if len(debugFilters) >= MaxDebugFilters {
+		return DebugFilter{}, ErrTooManyDebugFilters
+	}
+	df.ID = GetTagOrKey()
+	df.Created = now
+	df.Expires = now.Add(time.Duration(df.TTL) * time.Second)
+	df.Matches = 0
+	debugFilters = append(debugFilters, &df)
+
+	logger.Info("Debug filter added", "filter", df.ID, "fromUser", df.FromUser, "toUser", df.ToUser,
+		"ruriPrefix", df.RURIPrefix, "sourceIp", df.SourceIP, logging.KeyCallID, df.CallID, "expires", df.Expires)
+	return df, nil
+}
+
+func (lb *LoadBalancingNode) RemoveDebugFilter(id string) error {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	idx := slices.IndexFunc(debugFilters, func(df *DebugFilter) bool { return df.ID == id })
+	if idx == -1 {
+		return ErrDebugFilterNotFound
+	}
+	debugFilters = slices.Delete(debugFilters, idx, idx+1)
+	logger.Info("Debug filter removed", "filter", id)
+	return nil
+}
+
+// DebugFilters returns the active filters
+func (lb *LoadBalancingNode) DebugFilters() []DebugFilter {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	pruneDebugFilters(time.Now())
+	dfs := make([]DebugFilter, 0, len(debugFilters))
+	for _, df := range debugFilters {
+		dfs = append(dfs, *df)
+	}
+	return dfs
+}
+
+// DebugTraces returns the traces of the dialogues matched by debug filters,
+// of filterID only when set, those still cached first
+func (lb *LoadBalancingNode) DebugTraces(filterID string) []CallTrace {
+	cts := make([]CallTrace, 0)
+	for _, cc := range lb.callsSnapshot() {
+		cc.mu.RLock()
+		matched := cc.debugFilter != "" && (filterID == "" || cc.debugFilter == filterID)
+		cc.mu.RUnlock()
+		if matched {
+			cts = append(cts, cc.callTrace())
+		}
+	}
+
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	for _, ct := range slices.Backward(debugTraces) {
+		if filterID == "" || ct.FilterID == filterID {
+			cts = append(cts, ct)
+		}
+	}
+	return cts
+}
@@ -0,0 +1,320 @@
+package sip
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+var (
+	ErrCallNotFound  = errors.New("call not found")
+	ErrCallNotActive = errors.New("call is not active")
+)
+
+const terminationReason = `Q.850;cause=16;text="Terminated by operator"`
+
+// dialogInfo keeps what is needed to generate requests within a dialogue.
+// The originator is the side that sent the dialogue-creating request.
+type dialogInfo struct {
+	method            Method
+	ruri              string
+	from              string // From of the originator, with its tag
+	to                string // To as sent by the originator
+	remoteTo          string // To with the recipient tag, once known
+	cseq              uint32
+	originatorVias    []string
+	originatorContact string
+	recipientContact  string
+	originatorCSeq    uint32
+	recipientCSeq     uint32
+	localTxns         []string // transactions generated by the balancer, as "branch method"
+	hungUp            bool     // a 2xx arrived after the operator terminated the dialogue
+}
+
+// newDialogInfo must be called before our Via is added to sipmsg
+func newDialogInfo(sipmsg *SipMessage) dialogInfo {
+	return dialogInfo{
+		method:            sipmsg.GetMethod(),
+		ruri:              sipmsg.StartLine.RUri,
+		from:              firstHeaderValue(sipmsg, From),
+		to:                firstHeaderValue(sipmsg, To),
+		cseq:              sipmsg.CSeqNum,
+		originatorVias:    slices.Clone(sipmsg.Headers.GetHeaderValues(Via)),
+		originatorContact: contactURI(sipmsg),
+		originatorCSeq:    sipmsg.CSeqNum,
+	}
+}
+
+func firstHeaderValue(sipmsg *SipMessage, hn string) string {
+	values := sipmsg.Headers.GetHeaderValues(hn)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func contactURI(sipmsg *SipMessage) string {
+	var matches []string
+	if RMatch(firstHeaderValue(sipmsg, Contact), URIFull, &matches) {
+		return matches[1]
+	}
+	return ""
+}
+
+func localTxnKey(branch string, mthd Method) string {
+	return branch + " " + string(mthd)
+}
+
+// ==========================================================================
+
+func (cc *CallCache) originatorAddr() *net.UDPAddr {
+	if cc.IsInbound {
+		return cc.OtherAddr
+	}
+	return cc.SIPNode.UdpAddr
+}
+
+func (cc *CallCache) recipientAddr() *net.UDPAddr {
+	if cc.IsInbound {
+		return cc.SIPNode.UdpAddr
+	}
+	return cc.OtherAddr
+}
+
+// isLocalTransaction reports whether rspns answers a request generated by the
+// balancer, such responses are consumed and not forwarded
+func (cc *CallCache) isLocalTransaction(rspns *SipMessage) bool {
+	return slices.Contains(cc.dialog.localTxns, localTxnKey(rspns.ViaBranch, rspns.CSeqMethod))
+}
+
+// isInitialTransaction reports whether rspns answers the dialogue-creating request
+func (cc *CallCache) isInitialTransaction(rspns *SipMessage) bool {
+	if rspns.CSeqMethod == UNKNOWN {
+		return true
+	}
+	return rspns.CSeqMethod == cc.dialog.method && rspns.CSeqNum == cc.dialog.cseq
+}
+
+// updateStatus reports whether rspns is to be forwarded, must be called while
+// holding cc.mu
+func (cc *CallCache) updateStatus(rspns *SipMessage) bool {
+	if !cc.AnswerTime.IsZero() { // retransmitted 2xx
+		return true
+	}
+	if rspns.ToTag != "" {
+		cc.dialog.remoteTo = firstHeaderValue(rspns, To)
+	}
+
+	cc.timeoutTmr.Stop()
+
+	stsCode := rspns.StartLine.StatusCode
+	switch {
+	case IsProvisional(stsCode):
+		cc.recordRinging(stsCode)
+		if cc.EndTime.IsZero() { // ended dialogues are cleared by their clear timer
+			cc.StartTimeoutTimer(true)
+		}
+	case IsPositive(stsCode) && cc.CallStatus == StatusTerminated:
+		cc.hangUpLateAnswer(rspns)
+		return false
+	case IsPositive(stsCode):
+		cc.finalCode = stsCode
+		cc.CallStatus = StatusAnswered
+		cc.setAnswered()
+		cc.dialog.recipientContact = contactURI(rspns)
+		if cc.dialog.method == INVITE { // kept until BYE
+			cc.startDialogTimer()
+		} else {
+			cc.startClearTimer()
+		}
+	case IsNegative(stsCode) && cc.CallStatus == StatusTerminated:
+		// the originator already got our 487
+		cc.finalCode = stsCode
+		cc.sendNegativeAck(rspns)
+		return false
+	case IsNegative(stsCode):
+		cc.finalCode = stsCode
+		if cc.CallStatus != StatusCancelled {
+			cc.CallStatus = StatusRejected
+		}
+		cc.setEnded()
+		cc.startClearTimer()
+	}
+	return true
+}
+
+// trackRequest follows in-dialogue requests, must be called while holding cc.mu
+func (cc *CallCache) trackRequest(rqst *SipMessage, srcAddr *net.UDPAddr) {
+	fromOriginator := AreUAddrsEqual(cc.OtherAddr, srcAddr) == cc.IsInbound
+	if fromOriginator {
+		cc.dialog.originatorCSeq = max(cc.dialog.originatorCSeq, rqst.CSeqNum)
+	} else {
+		cc.dialog.recipientCSeq = max(cc.dialog.recipientCSeq, rqst.CSeqNum)
+	}
+
+	switch rqst.GetMethod() {
+	case CANCEL:
+		if cc.CallStatus == StatusProgressing {
+			cc.CallStatus = StatusCancelled
+		}
+	case BYE:
+		if cc.CallStatus == StatusAnswered {
+			cc.CallStatus = StatusEnded
+			cc.setEnded()
+			cc.timeoutTmr.Stop()
+			cc.startClearTimer()
+		}
+	}
+}
+
+// must be called while holding cc.mu
+func (cc *CallCache) startClearTimer() {
+	if cc.clearTmr == nil {
+		cc.clearTmr = createClearTimer(cc.CallID)
+	}
+}
+
+// startDialogTimer bounds how long an answered dialogue is kept when its BYE
+// is never seen, must be called while holding cc.mu
+func (cc *CallCache) startDialogTimer() {
+	duration := time.Duration(LoadBalancer.settings().MaxDialogDuration) * time.Second
+	cc.timeoutTmr = time.AfterFunc(duration, func() { cc.timeoutHandler() })
+}
+
+// ==========================================================================
+
+// TerminateCall tears down a dialogue: answered calls get a BYE towards both
+// sides, early ones a CANCEL towards the recipient and a 487 towards the originator.
+func (lb *LoadBalancingNode) TerminateCall(callID string) error {
+	cc := lb.getCallCache(callID)
+	if cc == nil {
+		return ErrCallNotFound
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.dialog.method != INVITE {
+		return ErrCallNotActive
+	}
+
+	switch cc.CallStatus {
+	case StatusAnswered:
+		cc.sendBye(true)
+		cc.sendBye(false)
+	case StatusProgressing:
+		cc.sendCancel()
+		cc.sendRequestTerminated()
+	default:
+		return ErrCallNotActive
+	}
+
+	cc.CallStatus = StatusTerminated
+	cc.setEnded()
+	cc.timeoutTmr.Stop()
+	cc.startClearTimer()
+
+	cc.log().Info("Call terminated by operator")
+	return nil
+}
+
+// hangUpLateAnswer ends a dialogue answered while the operator was terminating
+// it: the 2xx is acknowledged instead of forwarded, and both sides get a BYE
+// once. Must be called while holding cc.mu.
+func (cc *CallCache) hangUpLateAnswer(rspns *SipMessage) {
+	cc.dialog.recipientContact = contactURI(rspns)
+	cc.sendAck()
+	if cc.dialog.hungUp { // retransmitted 2xx
+		return
+	}
+	cc.dialog.hungUp = true
+	cc.sendBye(false)
+	cc.sendBye(true)
+	cc.log().Info("Call answered after being terminated by operator, hung up")
+}
+
+// sendAck acknowledges the 2xx of the recipient
+func (cc *CallCache) sendAck() {
+	remoteTo := cmp.Or(cc.dialog.remoteTo, cc.dialog.to)
+	ruri := cmp.Or(cc.dialog.recipientContact, cc.dialog.ruri)
+	ackmsg := BuildInDialogRequest(ACK, ruri, GetViaBranch(), cc.dialog.from, remoteTo, cc.CallID, cc.dialog.cseq)
+
+	cc.sendLocal(ackmsg, cc.recipientAddr())
+}
+
+// sendNegativeAck acknowledges a non-2xx final response of the recipient,
+// within the INVITE transaction as we forwarded it
+func (cc *CallCache) sendNegativeAck(rspns *SipMessage) {
+	ackmsg := BuildInDialogRequest(ACK, cc.dialog.ruri, cc.OwnViaBranch, cc.dialog.from, firstHeaderValue(rspns, To), cc.CallID, cc.dialog.cseq)
+
+	cc.sendLocal(ackmsg, cc.recipientAddr())
+}
+
+// isLocalAck reports whether rqst acknowledges the 487 the balancer sent when
+// the operator terminated the dialogue, such ACKs are consumed and not forwarded
+func (cc *CallCache) isLocalAck(rqst *SipMessage) bool {
+	return rqst.GetMethod() == ACK && cc.CallStatus == StatusTerminated && cc.AnswerTime.IsZero() &&
+		rqst.CSeqNum == cc.dialog.cseq
+}
+
+// sendBye sends a BYE towards the recipient, or the originator when toOriginator is set
+func (cc *CallCache) sendBye(toOriginator bool) {
+	remoteTo := cc.dialog.remoteTo
+	if remoteTo == "" {
+		remoteTo = cc.dialog.to
+	}
+
+	var byemsg *SipMessage
+	branch := GetViaBranch()
+	if toOriginator {
+		ruri := cmp.Or(cc.dialog.originatorContact, cc.dialog.ruri)
+		cc.dialog.recipientCSeq++
+		byemsg = BuildInDialogRequest(BYE, ruri, branch, remoteTo, cc.dialog.from, cc.CallID, cc.dialog.recipientCSeq)
+	} else {
+		ruri := cmp.Or(cc.dialog.recipientContact, cc.dialog.ruri)
+		cc.dialog.originatorCSeq++
+		byemsg = BuildInDialogRequest(BYE, ruri, branch, cc.dialog.from, remoteTo, cc.CallID, cc.dialog.originatorCSeq)
+	}
+	byemsg.Headers.Add(Reason, terminationReason)
+
+	cc.dialog.localTxns = append(cc.dialog.localTxns, localTxnKey(branch, BYE))
+
+	if toOriginator {
+		cc.sendLocal(byemsg, cc.originatorAddr())
+	} else {
+		cc.sendLocal(byemsg, cc.recipientAddr())
+	}
+}
+
+// sendCancel cancels the INVITE as we forwarded it, hence our own Via branch
+func (cc *CallCache) sendCancel() {
+	cancelmsg := BuildInDialogRequest(CANCEL, cc.dialog.ruri, cc.OwnViaBranch, cc.dialog.from, cc.dialog.to, cc.CallID, cc.dialog.cseq)
+	cancelmsg.Headers.Add(Reason, terminationReason)
+
+	cc.dialog.localTxns = append(cc.dialog.localTxns, localTxnKey(cc.OwnViaBranch, CANCEL))
+
+	cc.sendLocal(cancelmsg, cc.recipientAddr())
+}
+
+func (cc *CallCache) sendRequestTerminated() {
+	to := cc.dialog.remoteTo
+	if to == "" {
+		to = fmt.Sprintf("%s;tag=%s", cc.dialog.to, GetTagOrKey())
+	}
+
+	hdrs := NewSipHeaders()
+	hdrs.Add(Via, cc.dialog.originatorVias...)
+	hdrs.Add(From, cc.dialog.from)
+	hdrs.Add(To, to)
+	hdrs.Add(CSeq, fmt.Sprintf("%d %s", cc.dialog.cseq, INVITE))
+	invite := &SipMessage{MsgType: REQUEST, Headers: hdrs, CallID: cc.CallID}
+
+	rspnsmsg := BuildResponseMessage(invite, 487, "Request Terminated")
+
+	cc.sendLocal(rspnsmsg, cc.originatorAddr())
+}
@@ -0,0 +1,147 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+// A provisional response arriving after a CANCEL must not leave the dialogue
+// without a timer when the final response never comes
+func TestProvisionalAfterCancelKeepsTimeout(t *testing.T) {
+	core, uac := newTestPeer(t), newTestPeer(t)
+	sn := newTestBalancer(t, core, 1)
+	lb := LoadBalancer
+
+	cc, _ := lb.AddOrGetCallCache(testInvite(t, uac, "cancel-1xx"), uac.addr(), time.Now())
+	if cc == nil {
+		t.Fatal("INVITE not admitted")
+	}
+	lb.AddOrGetCallCache(testCancel(t, uac, "cancel-1xx"), uac.addr(), time.Now())
+	lb.AddOrGetCallCache(testResponse(t, cc, uac, 180, "Ringing"), core.addr(), time.Now())
+
+	cc.mu.RLock()
+	status := cc.CallStatus
+	cc.mu.RUnlock()
+	if status != StatusCancelled {
+		t.Fatalf("status = %s, want %s", status, StatusCancelled)
+	}
+
+	// no 487: the doubled timeout (2s) then the clear timer (1s) must free the dialogue
+	deadline := time.Now().Add(5 * time.Second)
+	for lb.getCallCache("cancel-1xx") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("dialogue never cleared")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	cc.mu.RLock()
+	status = cc.CallStatus
+	cc.mu.RUnlock()
+	if status != StatusTimedout {
+		t.Errorf("status = %s, want %s", status, StatusTimedout)
+	}
+	if sn.Info().ActiveCalls != 0 {
+		t.Errorf("ActiveCalls = %d after the dialogue timed out, want 0", sn.Info().ActiveCalls)
+	}
+	if n := CallLimiter.ActiveCalls(); n != 0 {
+		t.Errorf("limiter ActiveCalls = %d after the dialogue timed out, want 0", n)
+	}
+}
+
+// A 2xx crossing the CANCEL of an operator termination must not bring the
+// call up: it is acknowledged and both sides are hung up
+func TestAnswerAfterTerminateHangsUp(t *testing.T) {
+	core, uac := newTestPeer(t), newTestPeer(t)
+	newTestBalancer(t, core, 0)
+	lb := LoadBalancer
+
+	cc, _ := lb.AddOrGetCallCache(testInvite(t, uac, "late-2xx"), uac.addr(), time.Now())
+	if cc == nil {
+		t.Fatal("INVITE not admitted")
+	}
+	if err := lb.TerminateCall("late-2xx"); err != nil {
+		t.Fatal(err)
+	}
+	core.receive(200 * time.Millisecond) // CANCEL
+	uac.receive(200 * time.Millisecond)  // 487
+
+	ok := testResponse(t, cc, uac, 200, "OK")
+	if _, target := lb.AddOrGetCallCache(ok, core.addr(), time.Now()); target != nil {
+		t.Errorf("2xx forwarded to %s", target)
+	}
+	if got, want := strings.Join(core.receive(200*time.Millisecond), "|"), "ACK sip:100@127.0.0.1:5099 SIP/2.0|BYE sip:100@127.0.0.1:5099 SIP/2.0"; got != want {
+		t.Errorf("core received %q, want %q", got, want)
+	}
+	if got := uac.receive(200 * time.Millisecond); len(got) != 1 || !strings.HasPrefix(got[0], "BYE ") {
+		t.Errorf("originator received %q, want a BYE", got)
+	}
+
+	// a retransmitted 2xx is acknowledged again, without another BYE
+	lb.AddOrGetCallCache(testResponse(t, cc, uac, 200, "OK"), core.addr(), time.Now())
+	if got := core.receive(200 * time.Millisecond); len(got) != 1 || !strings.HasPrefix(got[0], "ACK ") {
+		t.Errorf("core received %q after a retransmission, want an ACK", got)
+	}
+
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if cc.CallStatus != StatusTerminated || !cc.AnswerTime.IsZero() {
+		t.Errorf("status = %s answered at %v, want %s and never answered", cc.CallStatus, cc.AnswerTime, StatusTerminated)
+	}
+}
+
+// Terminating an early dialogue answers the originator with our own 487: the
+// 487 of the recipient to our CANCEL is acknowledged and dropped, and the ACK
+// of the originator to ours is not forwarded
+func TestTerminateEarlySendsOne487(t *testing.T) {
+	core, uac := newTestPeer(t), newTestPeer(t)
+	newTestBalancer(t, core, 0)
+	lb := LoadBalancer
+
+	cc, _ := lb.AddOrGetCallCache(testInvite(t, uac, "early-487"), uac.addr(), time.Now())
+	if cc == nil {
+		t.Fatal("INVITE not admitted")
+	}
+	if err := lb.TerminateCall("early-487"); err != nil {
+		t.Fatal(err)
+	}
+	if got := core.receive(200 * time.Millisecond); len(got) != 1 || !strings.HasPrefix(got[0], "CANCEL ") {
+		t.Fatalf("core received %q, want a CANCEL", got)
+	}
+
+	for range 2 { // and its retransmission
+		rspns := testResponse(t, cc, uac, 487, "Request Terminated")
+		if _, target := lb.AddOrGetCallCache(rspns, core.addr(), time.Now()); target != nil {
+			t.Errorf("487 of the core forwarded to %s", target)
+		}
+	}
+	if got := uac.receive(200 * time.Millisecond); len(got) != 1 || got[0] != "SIP/2.0 487 Request Terminated" {
+		t.Errorf("originator received %q, want exactly one 487", got)
+	}
+	if got := core.receive(200 * time.Millisecond); len(got) != 2 || got[0] != "ACK sip:100@127.0.0.1 SIP/2.0" {
+		t.Errorf("core received %q, want an ACK to each 487", got)
+	}
+
+	ack := parseTestMessage(t, `ACK sip:100@127.0.0.1 SIP/2.0
+Via: SIP/2.0/UDP %s;branch=z9hG4bK-early-487
+From: <sip:alice@example.com>;tag=a1
+To: <sip:100@127.0.0.1>;tag=local
+Call-ID: early-487
+CSeq: 1 ACK
+Max-Forwards: 70
+Content-Length: 0
+
+`, uac.addr())
+	if _, target := lb.AddOrGetCallCache(ack, uac.addr(), time.Now()); target != nil {
+		t.Errorf("ACK of our 487 forwarded to %s", target)
+	}
+
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if cc.CallStatus != StatusTerminated || cc.finalCode != 487 {
+		t.Errorf("status = %s with final code %d, want %s and 487", cc.CallStatus, cc.finalCode, StatusTerminated)
+	}
+}
@@ -0,0 +1,70 @@
+package sip
+
+import (
+	"time"
+
+	"siploadbalancer/events"
+	. "siploadbalancer/global"
+)
+
+type (
+	NodeEvent struct {
+		Node     string    `json:"node"`
+		Key      string    `json:"key"`
+		Addr     string    `json:"addr"`
+		State    NodeState `json:"state"`
+		Previous NodeState `json:"previous,omitempty"`
+	}
+
+	NodesDownEvent struct {
+		Nodes int `json:"nodes"`
+	}
+
+	CallEvent struct {
+		CallID     string  `json:"callId"`
+		Node       string  `json:"node"`
+		NodeKey    string  `json:"nodeKey"`
+		PeerAddr   string  `json:"peerAddr"`
+		Direction  string  `json:"direction"`
+		Status     Status  `json:"status"`
+		DurationMs float64 `json:"durationMs,omitempty"` // answered time of ended calls
+	}
+)
+
+// newNodeEvent must be called while holding sn.mu
+func newNodeEvent(sn *SipNode) NodeEvent {
+	return NodeEvent{Node: sn.Description, Key: sn.Key, Addr: sn.UdpAddr.String(), State: sn.State}
+}
+
+// publishCallEvent only reports INVITE dialogues, must be called while holding cc.mu
+func (cc *CallCache) publishCallEvent(t events.Type) {
+	if cc.dialog.method != INVITE {
+		return
+	}
+
+	ev := CallEvent{
+		CallID:    cc.CallID,
+		Node:      cc.SIPNode.GetDescription(),
+		NodeKey:   cc.SIPNode.Key,
+		Direction: DirectionOutbound,
+		Status:    cc.CallStatus,
+	}
+	if cc.IsInbound {
+		ev.Direction = DirectionInbound
+	}
+	if cc.OtherAddr != nil {
+		ev.PeerAddr = cc.OtherAddr.String()
+	}
+	if !cc.AnswerTime.IsZero() && !cc.EndTime.IsZero() {
+		ev.DurationMs = float64(cc.EndTime.Sub(cc.AnswerTime)) / float64(time.Millisecond)
+	}
+	events.Publish(t, ev)
+}
+
+// checkAllDead reports when the last alive node has gone down
+func (lb *LoadBalancingNode) checkAllDead() {
+	sipnodes := lb.GetSipNodes()
+	if len(sipnodes) > 0 && All(sipnodes, func(x *SipNode) bool { return x.IsDead() }) {
+		events.Publish(events.NodesDown, NodesDownEvent{Nodes: len(sipnodes)})
+	}
+}
@@ -22,7 +22,7 @@ func NewSipHeaders() *SipHeaders {
 func (hdrs *SipHeaders) DecrementMaxForwards() bool {
 	idx := hdrs.GetHeaderIndex(Max_Forwards)
 	if idx == -1 {
-		fmt.Printf("Could not find header [%s] values to amend", Max_Forwards)
+		logger.Warn("Could not find header values to amend", "header", Max_Forwards)
 		return false
 	}
 
@@ -66,7 +66,7 @@ func (hdrs *SipHeaders) Add(headerName string, headerValues ...string) {
 func (hdrs *SipHeaders) AddTopHeaderValue(headerName string, topValue string) {
 	idx := hdrs.GetHeaderIndex(headerName)
 	if idx == -1 {
-		fmt.Printf("Could not find header [%s] values to amend", headerName)
+		logger.Warn("Could not find header values to amend", "header", headerName)
 		return
 	}
 
@@ -82,14 +82,14 @@ func (hdrs *SipHeaders) AddTopHeaderValue(headerName string, topValue string) {
 func (hdrs *SipHeaders) DropTopHeaderValue(headerName string) {
 	idx := hdrs.GetHeaderIndex(headerName)
 	if idx == -1 {
-		fmt.Printf("Could not find header [%s] values to amend", headerName)
+		logger.Warn("Could not find header values to amend", "header", headerName)
 		return
 	}
 
 	currentVia := hdrs.hmap[ViaHeader]
 
 	if len(currentVia) < 2 {
-		fmt.Printf("Could not find enough header [%s] values to adjust", headerName)
+		logger.Warn("Could not find enough header values to adjust", "header", headerName)
 		return
 	}
 
@@ -0,0 +1,89 @@
+package sip
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+const (
+	DefaultMinHealthyNodes = 1
+	QueueStallTimeout      = 5 * time.Second // queued packets not processed for this long mean the workers are stuck
+)
+
+type (
+	Check struct {
+		Name   string `json:"name"`
+		OK     bool   `json:"ok"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	HealthReport struct {
+		OK     bool    `json:"ok"`
+		Checks []Check `json:"checks"`
+	}
+)
+
+var (
+	startTime     = time.Now()
+	listening     atomic.Bool
+	lastProcessed atomic.Int64 // unix nano of the last packet processed by a worker
+)
+
+func (hr *HealthReport) add(name string, ok bool, format string, a ...any) {
+	hr.Checks = append(hr.Checks, Check{Name: name, OK: ok, Detail: fmt.Sprintf(format, a...)})
+	hr.OK = hr.OK && ok
+}
+
+// Health reports whether the balancer is alive: its UDP listener is running
+// and its workers are processing the queued packets.
+func Health() HealthReport {
+	hr := HealthReport{OK: true}
+	hr.add("process", true, "up %s", time.Since(startTime).Round(time.Second))
+	hr.add("listener", listening.Load(), "%s", listenerDetail())
+
+	depth := len(packetQueue)
+	idle := time.Since(time.Unix(0, lastProcessed.Load()))
+	stuck := depth > 0 && idle > QueueStallTimeout
+	hr.add("workers", !stuck, "%d/%d packets queued, last processed %s ago", depth, QueueSize, idle.Round(time.Millisecond))
+
+	return hr
+}
+
+// Readiness reports whether the balancer can take calls: enough SIP servers
+// are available, the call limiter lets calls through and the listener is bound.
+func Readiness() HealthReport {
+	hr := HealthReport{OK: true}
+	hr.add("listener", listening.Load(), "%s", listenerDetail())
+
+	sipnodes := LoadBalancer.GetSipNodes()
+	available := 0
+	for _, sn := range sipnodes {
+		if sn.IsAvailable() {
+			available++
+		}
+	}
+	minNodes := LoadBalancer.GetMinHealthyNodes()
+	hr.add("sipNodes", available >= minNodes, "%d/%d available, %d required", available, len(sipnodes), minNodes)
+
+	rate := CallLimiter.Rate()
+	hr.add("callLimiter", rate != 0, "maxCallAttemptsPerSecond %d", rate)
+
+	return hr
+}
+
+func listenerDetail() string {
+	if ServerConnection == nil || !listening.Load() {
+		return "not listening"
+	}
+	return "listening on udp " + ServerConnection.LocalAddr().String()
+}
+
+func (lb *LoadBalancingNode) GetMinHealthyNodes() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return lb.MinHealthyNodes
+}
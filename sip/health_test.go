@@ -0,0 +1,61 @@
+package sip
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReadinessCountsAvailableNodes(t *testing.T) {
+	listening.Store(true)
+	t.Cleanup(func() { listening.Store(false) })
+
+	type node struct {
+		alive bool
+		state NodeState
+	}
+	tests := []struct {
+		name     string
+		nodes    []node
+		minNodes int
+		ready    bool
+	}{
+		{"no servers", nil, 1, false},
+		{"alive and enabled", []node{{true, NodeEnabled}}, 1, true},
+		{"dead", []node{{false, NodeEnabled}}, 1, false},
+		{"disabled", []node{{true, NodeDisabled}}, 1, false},
+		{"draining", []node{{true, NodeDraining}}, 1, false},
+		{"one of two required", []node{{true, NodeEnabled}, {false, NodeEnabled}}, 1, true},
+		{"two of two required", []node{{true, NodeEnabled}, {true, NodeDisabled}}, 2, false},
+		{"enough", []node{{true, NodeEnabled}, {true, NodeEnabled}, {false, NodeDisabled}}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := inputData{
+				LoadbalanceMode: string(DistribRoundRobin),
+				ProbingInterval: 60,
+				MinHealthyNodes: tt.minNodes,
+				HitWindow:       60,
+			}
+			for i := range tt.nodes {
+				in.Servers = append(in.Servers, testServer(newTestPeer(t), fmt.Sprintf("core-%d", i), 1))
+			}
+			in.Quality.setDefaults()
+			useTestBalancer(t, NewLoadBalancer(in))
+			for i, n := range tt.nodes {
+				sn := LoadBalancer.SipNodes[i]
+				sn.IsAlive = n.alive
+				sn.State = n.state
+			}
+
+			hr := Readiness()
+			if hr.OK != tt.ready {
+				t.Errorf("ready = %t, want %t: %+v", hr.OK, tt.ready, hr.Checks)
+			}
+			for _, c := range hr.Checks {
+				if c.Name != "sipNodes" && !c.OK {
+					t.Errorf("check %s failed: %s", c.Name, c.Detail)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package sip
+
+import (
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/history"
+	"siploadbalancer/window"
+)
+
+const (
+	DefaultHitWindow = 3600  // seconds
+	MaxHitWindow     = 86400 // seconds
+	maxHitBuckets    = 3600  // buckets are one second wide up to an hour, wider beyond
+
+	hitRefreshInterval = time.Second
+)
+
+// nodeCounters count the dialogues of a node over the hit window, must be
+// accessed while holding the SipNode mu
+type nodeCounters struct {
+	hits    *window.Counter
+	answers *window.Counter
+	rejects *window.Counter
+}
+
+func hitWindowSize(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+func newNodeCounters(size time.Duration) nodeCounters {
+	buckets := min(int(size/time.Second), maxHitBuckets)
+	return nodeCounters{
+		hits:    window.New(size, buckets),
+		answers: window.New(size, buckets),
+		rejects: window.New(size, buckets),
+	}
+}
+
+// refresh copies the windowed counts to the exported fields, must be called
+// while holding the SipNode mu
+func (sn *SipNode) refresh() {
+	sn.Hits = int(sn.counters.hits.Sum())
+	sn.Answers = int(sn.counters.answers.Sum())
+	sn.Rejects = int(sn.counters.rejects.Sum())
+}
+
+func (sn *SipNode) AddHit() {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.counters.hits.Add(1)
+	sn.refresh()
+	sn.LastHit = time.Now().UTC()
+	history.Inc(history.Hits, sn.Description)
+}
+
+// windowedHits returns the dialogues routed to sn over the hit window
+func (sn *SipNode) windowedHits() int64 {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	return sn.counters.hits.Sum()
+}
+
+// countOutcome must be called once an INVITE dialogue routed to sn is answered or rejected
+func (sn *SipNode) countOutcome(answered, rejected bool) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if answered {
+		sn.counters.answers.Add(1)
+	}
+	if rejected {
+		sn.counters.rejects.Add(1)
+	}
+	sn.refresh()
+}
+
+func (sn *SipNode) resetCounters(size time.Duration) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.counters = newNodeCounters(size)
+	sn.refresh()
+}
+
+// periodicHitRefresh lets the counts of idle nodes slide out of the window
+func (lb *LoadBalancingNode) periodicHitRefresh() {
+	ticker := time.NewTicker(hitRefreshInterval)
+	WtGrp.Add(1)
+	go func() {
+		defer WtGrp.Done()
+		for range ticker.C {
+			for _, sn := range lb.GetSipNodes() {
+				sn.mu.Lock()
+				sn.refresh()
+				sn.mu.Unlock()
+			}
+		}
+	}()
+}
@@ -3,12 +3,13 @@ package sip
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"runtime"
 	. "siploadbalancer/global"
+	"siploadbalancer/logging"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -39,6 +40,8 @@ type Packet struct {
 }
 
 func startWorkers() {
+	Prometrics.RegisterQueueDepth(func() float64 { return float64(len(packetQueue)) })
+	lastProcessed.Store(time.Now().UnixNano())
 	WtGrp.Add(WorkerCount)
 	for range WorkerCount {
 		go worker(packetQueue)
@@ -47,15 +50,23 @@ func startWorkers() {
 
 func udpLoopWorkers() {
 	WtGrp.Add(1)
+	listening.Store(true)
 	go func() {
 		WtGrp.Done()
+		defer listening.Store(false)
+		localAddr := ServerConnection.LocalAddr().(*net.UDPAddr)
 		for {
 			buf := BufferPool.Get().(*[]byte)
 			n, addr, err := ServerConnection.ReadFromUDP(*buf)
 			if err != nil {
-				fmt.Println(err)
+				if errors.Is(err, net.ErrClosed) {
+					logger.Info("SIP listener closed")
+					return
+				}
+				logger.Error("Failed to read from SIP socket", logging.Err(err))
 				continue
 			}
+			mirrorReceived(addr, localAddr, (*buf)[:n])
 			packetQueue <- Packet{sourceAddr: addr, buffer: buf, bytesCount: n}
 		}
 	}()
@@ -65,6 +76,7 @@ func worker(queue <-chan Packet) {
 	defer WtGrp.Done()
 	for packet := range queue {
 		processPacket(packet)
+		lastProcessed.Store(time.Now().UnixNano())
 	}
 }
 
@@ -73,13 +85,14 @@ func processPacket(packet Packet) {
 	for len(pdu) > 0 {
 		msg, pdutmp, err := parsePDU(pdu)
 		if err != nil {
-			fmt.Println("Bad PDU -", err)
-			fmt.Println(string(pdu))
+			Prometrics.ParseErrors.Inc()
+			logger.Warn("Bad PDU", logging.KeyPeer, packet.sourceAddr.String(), logging.Err(err))
+			logger.Debug("Bad PDU content", logging.KeyPeer, packet.sourceAddr.String(), "pdu", string(pdu))
 			break
 		} else if msg == nil {
 			break
 		}
-		callHandler(msg, packet.sourceAddr)
+		callHandler(msg, pdu[:len(pdu)-len(pdutmp)], packet.sourceAddr)
 		pdu = pdutmp
 	}
 	BufferPool.Put(packet.buffer)
@@ -174,6 +187,10 @@ func parsePDU(payload []byte) (*SipMessage, []byte, error) {
 				cntntLength = Str2Int[int](headervalue)
 			case strings.EqualFold(headername, Call_ID):
 				sipmsg.CallID = headervalue
+			case strings.EqualFold(headername, CSeq):
+				num, mthd, _ := strings.Cut(strings.TrimSpace(headervalue), " ")
+				sipmsg.CSeqNum = Str2Uint[uint32](num)
+				sipmsg.CSeqMethod = GetMethod(ASCIIToUpper(strings.TrimSpace(mthd)))
 			case strings.EqualFold(headername, Via):
 				via := DicFieldRegEx[ViaBranchPattern].FindStringSubmatch(headervalue)
 				if via != nil && sipmsg.ViaBranch == "" {
@@ -190,7 +207,7 @@ func parsePDU(payload []byte) (*SipMessage, []byte, error) {
 	cntntLengthComputed = len(payload) - _bodyStartIdx
 
 	if cntntLengthComputed != cntntLength {
-		log.Printf("Discrepancy encountered in Content-Length - computed [%d] vs received [%d]", cntntLengthComputed, cntntLength)
+		logger.Warn("Discrepancy encountered in Content-Length", "computed", cntntLengthComputed, "received", cntntLength)
 	}
 
 	sipmsg.Headers = msgmap
@@ -208,20 +225,35 @@ func parsePDU(payload []byte) (*SipMessage, []byte, error) {
 	return sipmsg, payload, nil
 }
 
-func callHandler(sipmsg *SipMessage, srcAddr *net.UDPAddr) {
+func callHandler(sipmsg *SipMessage, raw []byte, srcAddr *net.UDPAddr) {
 	defer func() {
 		if r := recover(); r != nil {
 			LogCallStack(r)
 		}
 	}()
 
-	cc, rmtAddr := LoadBalancer.AddOrGetCallCache(sipmsg, srcAddr)
+	received := time.Now()
+	cc, rmtAddr := LoadBalancer.AddOrGetCallCache(sipmsg, srcAddr, received)
 	if cc == nil || rmtAddr == nil {
 		return
 	}
 
-	_, err := ServerConnection.WriteTo(sipmsg.Bytes(), rmtAddr)
+	out := sipmsg.Bytes()
+	cc.traceMessages(raw, srcAddr, out, rmtAddr)
+
+	forwarded := time.Now()
+	err := writeTo(out, rmtAddr)
+	cc.traceTransaction(sipmsg, srcAddr, rmtAddr, received, forwarded, err)
 	if err != nil {
-		log.Println("Failed to forward message - error:", err)
+		callLog(sipmsg.CallID, rmtAddr).Error("Failed to forward message", logging.Err(err))
+		Prometrics.DroppedMessages.Inc()
+		return
+	}
+
+	node := cc.SIPNode.GetDescription()
+	if sipmsg.IsResponse() {
+		Prometrics.ResponsesForwarded.WithLabelValues(node, fmt.Sprintf("%dxx", sipmsg.GetStatusCode()/100)).Inc()
+	} else {
+		Prometrics.RequestsForwarded.WithLabelValues(node, string(sipmsg.GetMethod())).Inc()
 	}
 }
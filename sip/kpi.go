@@ -0,0 +1,176 @@
+package sip
+
+import (
+	"sync"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+type (
+	// kpiCounters accumulate the outcome of the INVITE dialogues routed to SIP servers
+	kpiCounters struct {
+		seizures        int64 // dialogues that reached a final outcome
+		answered        int64
+		networkFailures int64 // timeouts, 408 and 5xx
+		ended           int64 // answered dialogues that ended
+		talkTime        time.Duration
+		ringing         int64
+		ringDelay       time.Duration // INVITE to first 180/183
+		answerDelay     time.Duration // INVITE to 200
+	}
+
+	KPI struct {
+		Seizures         int64   `json:"seizures"`
+		Answered         int64   `json:"answered"`
+		NetworkFailures  int64   `json:"networkFailures"`
+		ASR              float64 `json:"asr"` // answer-seizure ratio, in percent
+		NER              float64 `json:"ner"` // network-effectiveness ratio, in percent
+		ACD              float64 `json:"acd"` // average call duration, in seconds
+		AvgRingDelayMs   float64 `json:"avgRingDelayMs"`
+		AvgAnswerDelayMs float64 `json:"avgAnswerDelayMs"`
+	}
+
+	KPIReport struct {
+		Global KPI            `json:"global"`
+		Nodes  map[string]KPI `json:"nodes"`
+	}
+)
+
+var (
+	globalKPI kpiCounters
+	kpiMu     sync.Mutex
+)
+
+func (kc *kpiCounters) kpi() KPI {
+	k := KPI{Seizures: kc.seizures, Answered: kc.answered, NetworkFailures: kc.networkFailures}
+	if kc.seizures > 0 {
+		k.ASR = 100 * float64(kc.answered) / float64(kc.seizures)
+		k.NER = 100 * float64(kc.seizures-kc.networkFailures) / float64(kc.seizures)
+	}
+	if kc.ended > 0 {
+		k.ACD = kc.talkTime.Seconds() / float64(kc.ended)
+	}
+	if kc.ringing > 0 {
+		k.AvgRingDelayMs = 1000 * kc.ringDelay.Seconds() / float64(kc.ringing)
+	}
+	if kc.answered > 0 {
+		k.AvgAnswerDelayMs = 1000 * kc.answerDelay.Seconds() / float64(kc.answered)
+	}
+	return k
+}
+
+func isNetworkFailure(status Status, code int) bool {
+	return status == StatusTimedout || code == 408 || (500 <= code && code <= 599)
+}
+
+// countsKPI reports whether the dialogue is one routed to a SIP server
+func (cc *CallCache) countsKPI() bool {
+	return cc.IsInbound && cc.dialog.method == INVITE
+}
+
+// recordRinging must be called while holding cc.mu
+func (cc *CallCache) recordRinging(code int) {
+	if !cc.countsKPI() || !cc.RingTime.IsZero() || (code != 180 && code != 183) {
+		return
+	}
+	cc.RingTime = time.Now().UTC()
+	delay := cc.RingTime.Sub(cc.StartTime)
+
+	cc.SIPNode.updateKPI(func(kc *kpiCounters) {
+		kc.ringing++
+		kc.ringDelay += delay
+	})
+	Prometrics.RingDelay.WithLabelValues(cc.SIPNode.GetDescription()).Observe(delay.Seconds())
+}
+
+// recordOutcome counts the seizure once the dialogue is answered or failed,
+// must be called while holding cc.mu
+func (cc *CallCache) recordOutcome() {
+	if !cc.countsKPI() {
+		return
+	}
+
+	answered := !cc.AnswerTime.IsZero()
+	failed := !answered && isNetworkFailure(cc.CallStatus, cc.finalCode)
+	delay := cc.AnswerTime.Sub(cc.StartTime)
+
+	cc.SIPNode.updateKPI(func(kc *kpiCounters) {
+		kc.seizures++
+		if answered {
+			kc.answered++
+			kc.answerDelay += delay
+		}
+		if failed {
+			kc.networkFailures++
+		}
+	})
+
+	cc.SIPNode.recordQuality(answered, failed)
+	cc.SIPNode.countOutcome(answered, cc.CallStatus == StatusRejected)
+
+	node := cc.SIPNode.GetDescription()
+	Prometrics.CallSeizures.WithLabelValues(node).Inc()
+	if answered {
+		Prometrics.CallsAnswered.WithLabelValues(node).Inc()
+		Prometrics.AnswerDelay.WithLabelValues(node).Observe(delay.Seconds())
+	}
+	if failed {
+		Prometrics.NetworkFailures.WithLabelValues(node).Inc()
+	}
+}
+
+// recordDuration must be called while holding cc.mu
+func (cc *CallCache) recordDuration() {
+	if !cc.countsKPI() || cc.AnswerTime.IsZero() {
+		return
+	}
+	duration := cc.EndTime.Sub(cc.AnswerTime)
+
+	cc.SIPNode.updateKPI(func(kc *kpiCounters) {
+		kc.ended++
+		kc.talkTime += duration
+	})
+	Prometrics.TalkTime.WithLabelValues(cc.SIPNode.GetDescription()).Add(duration.Seconds())
+}
+
+// updateKPI applies update to the node and global counters and refreshes the KPI gauges
+func (sn *SipNode) updateKPI(update func(kc *kpiCounters)) {
+	sn.mu.Lock()
+	update(&sn.kpi)
+	k := sn.kpi.kpi()
+	node := sn.Description
+	sn.mu.Unlock()
+
+	Prometrics.ASR.WithLabelValues(node).Set(k.ASR)
+	Prometrics.NER.WithLabelValues(node).Set(k.NER)
+	Prometrics.ACD.WithLabelValues(node).Set(k.ACD)
+
+	kpiMu.Lock()
+	update(&globalKPI)
+	k = globalKPI.kpi()
+	kpiMu.Unlock()
+
+	Prometrics.GlobalASR.Set(k.ASR)
+	Prometrics.GlobalNER.Set(k.NER)
+	Prometrics.GlobalACD.Set(k.ACD)
+}
+
+func (sn *SipNode) KPI() KPI {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+
+	return sn.kpi.kpi()
+}
+
+// KPIs returns the KPIs since start, globally and per SIP server description
+func (lb *LoadBalancingNode) KPIs() KPIReport {
+	kpiMu.Lock()
+	kr := KPIReport{Global: globalKPI.kpi(), Nodes: make(map[string]KPI)}
+	kpiMu.Unlock()
+
+	for _, sn := range lb.GetSipNodes() {
+		kr.Nodes[sn.GetDescription()] = sn.KPI()
+	}
+	return kr
+}
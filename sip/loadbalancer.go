@@ -3,13 +3,18 @@ package sip
 import (
 	"cmp"
 	"fmt"
-	"log"
 	"net"
+	"strconv"
 
+	"siploadbalancer/cl"
+	"siploadbalancer/events"
 	. "siploadbalancer/global"
+	"siploadbalancer/history"
+	"siploadbalancer/logging"
+	"siploadbalancer/tracing"
 	"slices"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,19 +22,27 @@ var LoadBalancer *LoadBalancingNode
 
 type (
 	LoadBalancingNode struct {
-		SipNodes             []*SipNode   `json:"sipNodes"`
-		Distribution         Distribution `json:"distribution"`
-		ProbingInterval      int          `json:"probingInterval"`
-		TimeoutTimerDuration int          `json:"timeoutTimerDuration"`
-		ClearTimerDuration   int          `json:"clearTimerDuration"`
+		SipNodes             []*SipNode      `json:"sipNodes"`
+		Distribution         Distribution    `json:"distribution"`
+		ProbingInterval      int             `json:"probingInterval"`
+		TimeoutTimerDuration int             `json:"timeoutTimerDuration"`
+		ClearTimerDuration   int             `json:"clearTimerDuration"`
+		MaxDialogDuration    int             `json:"maxDialogDuration"`
+		MinHealthyNodes      int             `json:"minHealthyNodes"`
+		HitWindow            int             `json:"hitWindow"`
+		Trace                TraceSettings   `json:"trace"`
+		Quality              QualitySettings `json:"quality"`
 
 		sipNodesMap map[string]*SipNode `json:"-"`
 		SipNodesLB  []string            `json:"sipNodesLB"`
 		nodeIdx     int                 `json:"-"`
 
-		hitResetTicker *time.Ticker          `json:"-"`
-		callsCache     map[string]*CallCache `json:"-"`
-		mu             sync.RWMutex          `json:"-"`
+		probingTicker *time.Ticker              `json:"-"`
+		callsCache    map[string]*CallCache     `json:"-"`
+		config        inputData                 `json:"-"`
+		running       atomic.Pointer[inputData] `json:"-"` // lb.config, for readers without lb.mu
+		cfgMu         sync.Mutex                `json:"-"`
+		mu            sync.RWMutex              `json:"-"`
 	}
 
 	SipNode struct {
@@ -39,16 +52,27 @@ type (
 		Weight      int
 		accWeight   int
 
-		Key     string
-		Hits    int
-		LastHit time.Time
-		isAlive bool
+		Key           string
+		Hits          int // dialogues routed to the node over the hit window
+		Answers       int // its INVITE dialogues answered over the hit window
+		Rejects       int // its INVITE dialogues rejected with a final 3xx-6xx over the hit window
+		LastHit       time.Time
+		State         NodeState
+		ActiveCalls   int // dialogues in progress
+		MaxSessions   int // limit of ActiveCalls for new inbound dialogues, 0 for none
+		IsAlive       bool
+		ProbeRTT      float64 // round-trip time of the last answered probe, in milliseconds
+		QualityFactor float64 // share of its dialogues the node gets, lowered while degraded
+		kpi           kpiCounters
+		quality       nodeQuality
+		counters      nodeCounters
 
 		mu sync.RWMutex
 	}
 
 	Status       string
 	Distribution string
+	NodeState    string
 
 	CallCache struct {
 		SIPNode      *SipNode
@@ -60,6 +84,23 @@ type (
 		CallStatus   Status
 		Messages     []string
 		IsProbing    bool
+		StartTime    time.Time
+		RingTime     time.Time
+		AnswerTime   time.Time
+		EndTime      time.Time
+
+		finalCode   int // final response of the dialogue-creating request
+		failovers   int // nodes passed over before SIPNode was chosen
+		history     []messageRecord
+		dialog      dialogInfo
+		tracing     bool
+		trace       []TraceRecord
+		debugFilter string // id of the debug filter that matched the dialogue, immutable
+		session     *cl.Session
+		released    bool // the dialogue no longer counts in ActiveCalls and maxCalls
+
+		span         *tracing.Span        // nil unless the dialogue is exported
+		transactions map[string]time.Time // requests forwarded, by CSeq, awaiting a final response
 
 		timeoutTmr *time.Timer
 		clearTmr   *time.Timer
@@ -73,6 +114,8 @@ const (
 	StatusAnswered    Status = "Answered"    // received 2xx
 	StatusCancelled   Status = "Cancelled"   // received CANCEL
 	StatusTimedout    Status = "Timedout"    // received no responses in time
+	StatusEnded       Status = "Ended"       // received BYE
+	StatusTerminated  Status = "Terminated"  // torn down by the operator
 
 	DistribRoundRobin Distribution = "RoundRobin"
 	DistribLeastHit   Distribution = "LeastHit"
@@ -81,50 +124,23 @@ const (
 	DistribWeighted   Distribution = "Weighted"
 	DistribRandom     Distribution = "Random"
 
+	NodeEnabled  NodeState = "Enabled"  // receives new dialogues
+	NodeDisabled NodeState = "Disabled" // receives no new dialogues, existing ones continue
+	NodeDraining NodeState = "Draining" // like Disabled, becomes Disabled once its last dialogue ends
+
 	LongTimeFormat string = "Mon, 02 Jan 2006 15:04:05 GMT"
 	JsonTimeFormat string = "2006-01-02T15:04:05Z"
 
-	HitResetDuration = 1 * time.Hour
-	TimeoutTimerDD   = 32 * time.Second // DD = Default Duration
-	ClearTimerDD     = 10 * time.Second
+	TimeoutTimerDD = 32 * time.Second // DD = Default Duration
+	ClearTimerDD   = 10 * time.Second
+	MaxDialogDD    = 3 * time.Hour
 )
 
 func NewLoadBalancer(inputData inputData) *LoadBalancingNode {
 	sipnodes := make([]*SipNode, 0, len(inputData.Servers))
 	sipNodesMap := make(map[string]*SipNode, len(inputData.Servers))
 	for _, srvr := range inputData.Servers {
-		sipIpv4 := net.ParseIP(srvr.Ipv4)
-		if sipIpv4 == nil {
-			fmt.Printf("SIP Server IPv4: %s - invalid", srvr.Ipv4)
-			continue
-		}
-
-		sipprt := srvr.Port
-		if sipprt == 0 {
-			fmt.Printf("SIP Server Port: %d - invalid", srvr.Port)
-			continue
-		}
-
-		udpAddr := &net.UDPAddr{IP: sipIpv4, Port: sipprt, Zone: ""}
-
-		if slices.ContainsFunc(sipnodes, func(x *SipNode) bool {
-			return AreUAddrsEqual(x.UdpAddr, udpAddr) || strings.EqualFold(x.Description, srvr.Description)
-		}) {
-			fmt.Println("Duplicate Server record - Skipped")
-			continue
-		}
-
-		sn := &SipNode{
-			Key:         GetTagOrKey(),
-			UdpAddr:     udpAddr,
-			Description: srvr.Description,
-			Cost:        srvr.Cost,
-			Weight:      srvr.Weight,
-			accWeight:   srvr.Weight,
-			isAlive:     false,
-			Hits:        0,
-		}
-
+		sn := newSipNode(srvr, inputData.Quality.windowSize(), hitWindowSize(inputData.HitWindow))
 		sipnodes = append(sipnodes, sn)
 		sipNodesMap[sn.Key] = sn
 	}
@@ -135,26 +151,57 @@ func NewLoadBalancer(inputData inputData) *LoadBalancingNode {
 		ProbingInterval:      inputData.ProbingInterval,
 		TimeoutTimerDuration: inputData.TimeoutTimerDuration,
 		ClearTimerDuration:   inputData.ClearTimerDuration,
+		MaxDialogDuration:    inputData.MaxDialogDuration,
+		MinHealthyNodes:      inputData.MinHealthyNodes,
+		HitWindow:            inputData.HitWindow,
+		Trace:                inputData.Trace,
+		Quality:              inputData.Quality,
 
 		sipNodesMap: sipNodesMap,
 		SipNodesLB:  computeSipNodesLB(sipnodes),
 		callsCache:  make(map[string]*CallCache),
+		config:      inputData,
 	}
-
-	lbn.hitResetTicker = time.NewTicker(HitResetDuration)
-	go lbn.hitResetTickerHandler()
+	lbn.running.Store(&inputData)
 
 	return lbn
 }
 
+func newSipNode(srvr ServerData, qualityWindow, hitWindow time.Duration) *SipNode {
+	return &SipNode{
+		Key:         GetTagOrKey(),
+		UdpAddr:     srvr.udpAddr(),
+		Description: srvr.Description,
+		Cost:        srvr.Cost,
+		Weight:      srvr.Weight,
+		accWeight:   srvr.Weight,
+		MaxSessions: srvr.MaxSessions,
+		State:       NodeEnabled,
+		IsAlive:     false,
+
+		QualityFactor: 1,
+		quality:       newNodeQuality(qualityWindow),
+		counters:      newNodeCounters(hitWindow),
+	}
+}
+
+func (d Distribution) IsValid() bool {
+	switch d {
+	case DistribRoundRobin, DistribLeastHit, DistribLeastCost, DistribMostIdle, DistribWeighted, DistribRandom:
+		return true
+	}
+	return false
+}
+
 func createClearTimer(callID string) *time.Timer {
-	duration := time.Duration(LoadBalancer.ClearTimerDuration) * time.Second
+	duration := time.Duration(LoadBalancer.settings().ClearTimerDuration) * time.Second
 	return time.AfterFunc(duration, func() { LoadBalancer.DeleteCallCache(callID) })
 }
 
 func computeSipNodesLB(snlst []*SipNode) []string {
 	grandweight := 0
 	for _, wh := range snlst {
+		wh.accWeight = wh.Weight
 		grandweight += wh.Weight
 	}
 
@@ -182,16 +229,6 @@ func computeSipNodesLB(snlst []*SipNode) []string {
 	return lblst
 }
 
-func (lb *LoadBalancingNode) hitResetTickerHandler() {
-	for range lb.hitResetTicker.C {
-		lb.mu.Lock()
-		for _, sn := range lb.SipNodes {
-			sn.ResetHits()
-		}
-		lb.mu.Unlock()
-	}
-}
-
 func (lb *LoadBalancingNode) CallsCacheCount() int {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
@@ -199,71 +236,146 @@ func (lb *LoadBalancingNode) CallsCacheCount() int {
 	return len(lb.callsCache)
 }
 
-func (lb *LoadBalancingNode) CallsCache() map[string]*CallCache {
+// callsSnapshot returns the dialogues currently cached, probes excluded
+func (lb *LoadBalancingNode) callsSnapshot() []*CallCache {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	ccs := make([]*CallCache, 0, len(lb.callsCache))
+	for _, cc := range lb.callsCache {
+		if !cc.IsProbing {
+			ccs = append(ccs, cc)
+		}
+	}
+	return ccs
+}
+
+func (lb *LoadBalancingNode) getCallCache(callID string) *CallCache {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 
-	return lb.callsCache
+	cc, ok := lb.callsCache[callID]
+	if !ok || cc.IsProbing {
+		return nil
+	}
+	return cc
 }
 
-func (lb *LoadBalancingNode) GetNode() *SipNode {
+// GetNode picks the node for a new dialogue, counts the dialogue in its
+// ActiveCalls and tells how many were passed over, and whether some were for
+// being at their maxSessions. Unavailable and full nodes are passed over, and
+// so are degraded ones for part of the dialogues unless no other node is left.
+func (lb *LoadBalancingNode) GetNode() (*SipNode, int, bool) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	var outNode *SipNode
-	for len(lb.SipNodes) > 0 && outNode == nil {
-		switch lb.Distribution {
-		case DistribRoundRobin:
-			nd := lb.SipNodes[lb.nodeIdx]
-			lb.nodeIdx++
-			if lb.nodeIdx >= len(lb.SipNodes) {
-				lb.nodeIdx = 0
-			}
-			outNode = nd
-		case DistribLeastHit:
-			slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int { return cmp.Compare(a.Hits, b.Hits) })
-			outNode = lb.SipNodes[0]
-		case DistribLeastCost:
-			slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int { return cmp.Compare(a.Cost, b.Cost) })
-			outNode = lb.SipNodes[0]
-		case DistribMostIdle:
-			slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int {
-				if a.LastHit.Before(b.LastHit) {
-					return -1
-				}
-				if a.LastHit.After(b.LastHit) {
-					return 1
-				}
-				return 0
-			})
-			outNode = lb.SipNodes[0]
-		case DistribWeighted:
-			ndKey := lb.SipNodesLB[lb.nodeIdx]
-			lb.nodeIdx++
-			if lb.nodeIdx >= len(lb.SipNodesLB) {
-				lb.nodeIdx = 0
-			}
-			outNode = lb.sipNodesMap[ndKey]
-		default: // DistribRandom
-			outNode = lb.SipNodes[RandomNum(len(lb.SipNodes))]
+	excluded := make(map[*SipNode]bool, len(lb.SipNodes))
+	var degraded *SipNode
+	var full bool
+	for range 2 * (len(lb.SipNodes) + len(lb.SipNodesLB)) {
+		if len(excluded) == len(lb.SipNodes) {
+			break
+		}
+		outNode := lb.nextNode(excluded)
+		if outNode == nil || excluded[outNode] {
+			continue
 		}
 
-		if outNode.IsDead() {
-			if All(lb.SipNodes, func(x *SipNode) bool { return x.IsDead() }) {
-				return nil
+		switch {
+		case !outNode.IsAvailable():
+			excluded[outNode] = true
+		case outNode.skipDegraded():
+			excluded[outNode] = true
+			if degraded == nil {
+				degraded = outNode
 			}
-			outNode = nil
+		case !outNode.reserveCall():
+			excluded[outNode] = true
+			full = true
+		default:
+			return outNode, len(excluded), full
 		}
 	}
 
-	return outNode
+	if degraded != nil && !degraded.reserveCall() {
+		return nil, len(excluded), true
+	}
+	return degraded, max(len(excluded)-1, 0), full
+}
+
+// nextNode applies the distribution, must be called while holding lb.mu
+func (lb *LoadBalancingNode) nextNode(excluded map[*SipNode]bool) *SipNode {
+	first := func() *SipNode { return Find(lb.SipNodes, func(x *SipNode) bool { return !excluded[x] }) }
+
+	switch lb.Distribution {
+	case DistribRoundRobin:
+		nd := lb.SipNodes[lb.nodeIdx]
+		lb.nodeIdx++
+		if lb.nodeIdx >= len(lb.SipNodes) {
+			lb.nodeIdx = 0
+		}
+		return nd
+	case DistribLeastHit:
+		hits := make(map[*SipNode]int64, len(lb.SipNodes))
+		for _, sn := range lb.SipNodes {
+			hits[sn] = sn.windowedHits()
+		}
+		slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int { return cmp.Compare(hits[a], hits[b]) })
+		return first()
+	case DistribLeastCost:
+		slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int { return cmp.Compare(a.Cost, b.Cost) })
+		return first()
+	case DistribMostIdle:
+		slices.SortFunc(lb.SipNodes, func(a, b *SipNode) int {
+			if a.LastHit.Before(b.LastHit) {
+				return -1
+			}
+			if a.LastHit.After(b.LastHit) {
+				return 1
+			}
+			return 0
+		})
+		return first()
+	case DistribWeighted:
+		if len(lb.SipNodesLB) == 0 {
+			return nil
+		}
+		ndKey := lb.SipNodesLB[lb.nodeIdx]
+		lb.nodeIdx++
+		if lb.nodeIdx >= len(lb.SipNodesLB) {
+			lb.nodeIdx = 0
+		}
+		return lb.sipNodesMap[ndKey]
+	default: // DistribRandom
+		candidates := slices.DeleteFunc(slices.Clone(lb.SipNodes), func(x *SipNode) bool { return excluded[x] })
+		if len(candidates) == 0 {
+			return nil
+		}
+		return candidates[RandomNum(len(candidates))]
+	}
 }
 
 func (lb *LoadBalancingNode) DeleteCallCache(callID string) {
 	lb.mu.Lock()
-	defer lb.mu.Unlock()
-	delete(lb.callsCache, callID)
-	Prometrics.ConSessions.Dec()
+	cc, ok := lb.callsCache[callID]
+	if ok {
+		delete(lb.callsCache, callID)
+		Prometrics.ConSessions.Dec()
+	}
+	lb.mu.Unlock()
+
+	if !ok || cc.IsProbing || cc.SIPNode == nil {
+		return
+	}
+	cc.writeCDR()
+	if cc.debugFilter != "" {
+		keepDebugTrace(cc.callTrace())
+	}
+
+	cc.mu.Lock()
+	cc.releaseCall()
+	cc.endSpan(time.Now())
+	cc.mu.Unlock()
 }
 
 func (lb *LoadBalancingNode) ProbeSipNodes() {
@@ -271,32 +383,38 @@ func (lb *LoadBalancingNode) ProbeSipNodes() {
 	defer lb.mu.Unlock()
 
 	for _, sn := range lb.SipNodes {
-		callid := GetCallID()
-		viaBranch := GetViaBranch()
-		frmTag := GetTagOrKey()
-		localstr := ServerConnection.LocalAddr().String()
-		remotestr := sn.UdpAddr.String()
-
-		probemsg := BuildOptionsMessage(viaBranch, localstr, remotestr, callid, frmTag)
-
-		cc := &CallCache{
-			SIPNode:      sn,
-			CallID:       callid,
-			FromTag:      frmTag,
-			OwnViaBranch: viaBranch,
-			CallStatus:   StatusProgressing,
-			IsProbing:    true,
-		}
-		cc.StartTimeoutTimer(false)
+		lb.probeSipNode(sn)
+	}
+}
 
-		lb.callsCache[callid] = cc
-		Prometrics.ConSessions.Inc()
+// probeSipNode must be called while holding lb.mu
+func (lb *LoadBalancingNode) probeSipNode(sn *SipNode) {
+	callid := GetCallID()
+	viaBranch := GetViaBranch()
+	frmTag := GetTagOrKey()
+	localstr := ServerConnection.LocalAddr().String()
+	remotestr := sn.UdpAddr.String()
 
-		sendMessage(probemsg, sn.UdpAddr)
+	probemsg := BuildOptionsMessage(viaBranch, localstr, remotestr, callid, frmTag)
+
+	cc := &CallCache{
+		SIPNode:      sn,
+		CallID:       callid,
+		FromTag:      frmTag,
+		OwnViaBranch: viaBranch,
+		CallStatus:   StatusProgressing,
+		IsProbing:    true,
+		StartTime:    time.Now().UTC(),
 	}
+	cc.StartTimeoutTimer(false)
+
+	lb.callsCache[callid] = cc
+	Prometrics.ConSessions.Inc()
+
+	sendMessage(probemsg, sn.UdpAddr)
 }
 
-func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.UDPAddr) (*CallCache, *net.UDPAddr) {
+func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.UDPAddr, received time.Time) (*CallCache, *net.UDPAddr) {
 	lb.mu.RLock()
 	cc, ok := lb.callsCache[sipmsg.CallID]
 	lb.mu.RUnlock()
@@ -308,6 +426,7 @@ func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.
 			defer cc.mu.Unlock()
 
 			if cc.timeoutTmr.Stop() {
+				cc.SIPNode.setProbeRTT(time.Since(cc.StartTime))
 				cc.SIPNode.SetAlive(true)
 				LoadBalancer.DeleteCallCache(cc.CallID)
 			}
@@ -317,31 +436,30 @@ func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.
 
 		var duplicateMsg bool
 		cc.Messages, duplicateMsg = AddIfNew(cc.Messages, sipmsg.String())
+		cc.addHistory(sipmsg, srcAddr)
 
 		if sipmsg.IsResponse() {
-			cc.timeoutTmr.Stop()
+			if cc.isLocalTransaction(sipmsg) {
+				cc.mu.Unlock()
+				return nil, nil
+			}
 			sipmsg.Headers.DropTopVia()
 
-			stsCode := sipmsg.StartLine.StatusCode
-			switch {
-			case IsProvisional(stsCode):
-				cc.CallStatus = StatusProgressing
-				cc.StartTimeoutTimer(true)
-			case IsPositive(stsCode):
-				cc.timeoutTmr.Stop()
-				cc.CallStatus = StatusAnswered
-				cc.clearTmr = createClearTimer(cc.CallID)
-			case IsNegative(stsCode):
-				cc.timeoutTmr.Stop()
-				cc.CallStatus = StatusRejected
-				cc.clearTmr = createClearTimer(cc.CallID)
+			if cc.isInitialTransaction(sipmsg) && !cc.updateStatus(sipmsg) {
+				cc.mu.Unlock()
+				return nil, nil
 			}
 		} else {
 			if cc.IsInbound && duplicateMsg && cc.SIPNode.IsDead() { // if sipnode dies in the middle
 				defer cc.mu.Unlock()
-				sendMessage(BuildResponseMessage(sipmsg, 503, "Server Unreachable"), srcAddr)
+				sendErrorResponse(sipmsg, 503, "Server Unreachable", srcAddr)
+				return nil, nil
+			}
+			if cc.isLocalAck(sipmsg) {
+				cc.mu.Unlock()
 				return nil, nil
 			}
+			cc.trackRequest(sipmsg, srcAddr)
 			sipmsg.Headers.AddTopVia(cc.OwnViaBranch)
 		}
 
@@ -354,29 +472,63 @@ func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.
 		return cc, cc.OtherAddr
 	}
 
+	filterID := matchDebugFilter(sipmsg, srcAddr)
 	if sipmsg.IsResponse() || !sipmsg.GetMethod().IsDialogueCreating() {
-		// log.Printf("Message [%s] cannot initiate a dialogue - Dropping", sipmsg.String())
+		if filterID != "" {
+			callLog(sipmsg.CallID, srcAddr).Info("Debug trace - message cannot initiate a dialogue, dropping", "filter", filterID, "message", sipmsg.String())
+		}
+		Prometrics.DroppedMessages.Inc()
 		return nil, nil
 	}
 
 	if !sipmsg.Headers.DecrementMaxForwards() {
-		sendMessage(BuildResponseMessage(sipmsg, 483, "Too Many Hops"), srcAddr)
+		logDebugRejection(filterID, sipmsg, srcAddr, 483, "Too Many Hops")
+		sendErrorResponse(sipmsg, 483, "Too Many Hops", srcAddr)
 		return nil, nil
 	}
 
 	var rmtAddr, azrAddr *net.UDPAddr
 	var isingress bool
+	var failovers int
+	var selStart, selEnd time.Time
+
+	var rsv cl.Reservation
+	fromDomain := uriHost(firstHeaderValue(sipmsg, From))
 
-	sn := Find(lb.SipNodes, func(x *SipNode) bool { return AreUAddrsEqual(x.UdpAddr, srcAddr) })
+	sn := lb.findSipNodeByUDPAddr(srcAddr)
 	if sn == nil { // inbound from Access to Core
-		if CallLimiter.IsExceeded() {
-			sendMessage(BuildResponseMessage(sipmsg, 480, "Call Limiter Exceeded"), srcAddr)
+		if adm := CallLimiter.Admit(srcAddr.AddrPort().Addr(), fromDomain); !adm.OK {
+			code, reason := CallLimiter.RejectCode(), "Call Limiter Exceeded"
+			if adm.ByQuota {
+				reason = "Quota Exceeded"
+			}
+			logDebugRejection(filterID, sipmsg, srcAddr, code, reason)
+			if code == 503 {
+				sendRetryResponse(sipmsg, code, reason, adm.RetryAfter, srcAddr)
+			} else {
+				sendErrorResponse(sipmsg, code, reason, srcAddr)
+			}
+			return nil, nil
+		}
+		history.Inc(history.CAPS, "")
+		if rsv = CallLimiter.Reserve(srcAddr.AddrPort().Addr(), fromDomain); rsv.Session == nil {
+			rejectMaxCalls(filterID, sipmsg, srcAddr, rsv)
+			return nil, nil
+		}
+		selStart = time.Now()
+		var full bool
+		sn, failovers, full = lb.GetNode()
+		selEnd = time.Now()
+		if sn == nil && full {
+			rsv.Session.Release()
+			rejectServerFull(filterID, sipmsg, srcAddr)
 			return nil, nil
 		}
-		sn = lb.GetNode()
 		if sn == nil {
-			log.Printf("No more alive servers!")
-			sendMessage(BuildResponseMessage(sipmsg, 503, "No Available Servers"), srcAddr)
+			rsv.Session.Release()
+			callLog(sipmsg.CallID, srcAddr).Warn("No more alive servers!")
+			logDebugRejection(filterID, sipmsg, srcAddr, 503, "No Available Servers")
+			sendErrorResponse(sipmsg, 503, "No Available Servers", srcAddr)
 			return nil, nil
 		}
 		sn.AddHit()
@@ -386,7 +538,17 @@ func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.
 	} else { // outbound from Core to Access
 		msgTargetAddr, err := BuildSipUdpSocket(sipmsg.StartLine.Host, sipmsg.StartLine.Port)
 		if err != nil {
-			log.Printf("Message [%s] contains unreachable host - Error [%s] - Dropping", sipmsg.String(), err)
+			callLog(sipmsg.CallID, srcAddr).Warn("Message contains unreachable host - Dropping", logging.KeyNode, sn.Key, "message", sipmsg.String(), logging.Err(err))
+			Prometrics.DroppedMessages.Inc()
+			return nil, nil
+		}
+		if rsv = CallLimiter.Reserve(msgTargetAddr.AddrPort().Addr(), fromDomain); rsv.Session == nil {
+			rejectMaxCalls(filterID, sipmsg, srcAddr, rsv)
+			return nil, nil
+		}
+		if !sn.reserveCall() {
+			rsv.Session.Release()
+			rejectServerFull(filterID, sipmsg, srcAddr)
 			return nil, nil
 		}
 		azrAddr = msgTargetAddr
@@ -402,7 +564,16 @@ func (lb *LoadBalancingNode) AddOrGetCallCache(sipmsg *SipMessage, srcAddr *net.
 		OwnViaBranch: GetViaBranch(),
 		CallStatus:   StatusProgressing,
 		Messages:     []string{sipmsg.String()},
+		StartTime:    time.Now().UTC(),
+		dialog:       newDialogInfo(sipmsg),
+		tracing:      lb.settings().Trace.Enabled || filterID != "",
+		debugFilter:  filterID,
+		failovers:    failovers,
+		session:      rsv.Session,
 	}
+	cc.addHistory(sipmsg, srcAddr)
+	cc.startSpan(sipmsg, received, selStart, selEnd, lb.GetDistribution())
+	cc.publishCallEvent(events.CallStart)
 	cc.StartTimeoutTimer(false)
 
 	lb.mu.Lock()
@@ -420,13 +591,18 @@ func (cc *CallCache) timeoutHandler() {
 	defer cc.mu.Unlock()
 
 	if cc.IsProbing {
+		wasAlive := !cc.SIPNode.IsDead()
 		cc.SIPNode.SetAlive(false)
 		LoadBalancer.DeleteCallCache(cc.CallID)
+		if wasAlive {
+			LoadBalancer.checkAllDead()
+		}
 		return
 	}
 
-	cc.clearTmr = createClearTimer(cc.CallID)
+	cc.startClearTimer()
 	cc.CallStatus = StatusTimedout
+	cc.setEnded()
 }
 
 func (cc *CallCache) StartTimeoutTimer(dblDuration bool) {
@@ -439,7 +615,7 @@ func (cc *CallCache) StartTimeoutTimer(dblDuration bool) {
 	if cc.IsProbing {
 		interval = ProbingTimeout
 	} else {
-		interval = LoadBalancer.TimeoutTimerDuration
+		interval = LoadBalancer.settings().TimeoutTimerDuration
 	}
 
 	duration := time.Duration(interval) * time.Second
@@ -453,49 +629,175 @@ func (sn *SipNode) String() string {
 	return fmt.Sprintf("%s (%s)", sn.Description, sn.UdpAddr)
 }
 
-func (sn *SipNode) AddHit() {
+// update applies srvr to an existing node and reports whether anything changed
+func (sn *SipNode) update(srvr ServerData) bool {
 	sn.mu.Lock()
 	defer sn.mu.Unlock()
 
-	sn.Hits++ // TODO: find a way to rest this count!
-	sn.LastHit = time.Now().UTC()
+	changed := sn.Description != srvr.Description || sn.Cost != srvr.Cost || sn.Weight != srvr.Weight ||
+		sn.MaxSessions != srvr.MaxSessions
+	sn.Description = srvr.Description
+	sn.Cost = srvr.Cost
+	sn.Weight = srvr.Weight
+	sn.MaxSessions = srvr.MaxSessions
+
+	return changed
 }
 
-func (sn *SipNode) ResetHits() {
-	sn.mu.Lock()
-	defer sn.mu.Unlock()
+func (sn *SipNode) GetDescription() string {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
 
-	sn.Hits = 0
+	return sn.Description
 }
 
 func (sn *SipNode) SetAlive(flag bool) {
 	sn.mu.Lock()
 	defer sn.mu.Unlock()
 
-	if sn.isAlive != flag {
-		stamp := time.Now().UTC().Format(JsonTimeFormat)
-		var newsts string
+	if flag {
+		Prometrics.NodeUp.WithLabelValues(sn.Description).Set(1)
+	} else {
+		Prometrics.NodeUp.WithLabelValues(sn.Description).Set(0)
+	}
+
+	if sn.IsAlive != flag {
 		if flag {
-			newsts = "ALIVE"
+			sn.log().Info("Server became ALIVE")
+			events.Publish(events.NodeUp, newNodeEvent(sn))
 		} else {
-			newsts = "DEAD"
+			sn.log().Warn("Server became DEAD")
+			Prometrics.Ejections.WithLabelValues(sn.Description).Inc()
+			events.Publish(events.NodeDown, newNodeEvent(sn))
 		}
-		fmt.Printf("%s became %s on %s\n", sn, newsts, stamp)
 	}
 
-	sn.isAlive = flag
+	sn.IsAlive = flag
+}
+
+func (sn *SipNode) setProbeRTT(rtt time.Duration) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.ProbeRTT = float64(rtt) / float64(time.Millisecond)
+	Prometrics.ProbeRTT.WithLabelValues(sn.Description).Set(rtt.Seconds())
 }
 
 func (sn *SipNode) IsDead() bool {
 	sn.mu.RLock()
 	defer sn.mu.RUnlock()
 
-	return !sn.isAlive
+	return !sn.IsAlive
+}
+
+// IsAvailable reports whether the node can take new dialogues
+func (sn *SipNode) IsAvailable() bool {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+
+	return sn.IsAlive && sn.State == NodeEnabled
+}
+
+func (sn *SipNode) SetState(state NodeState) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if state == NodeDraining && sn.ActiveCalls == 0 {
+		state = NodeDisabled
+	}
+	if sn.State == state {
+		return
+	}
+	sn.log().Info("Server state changed", "previous", sn.State, "state", state)
+
+	ev := newNodeEvent(sn)
+	ev.State, ev.Previous = state, sn.State
+	sn.State = state
+	events.Publish(events.NodeState, ev)
+}
+
+// reserveCall starts a dialogue unless the node is at its maxSessions
+func (sn *SipNode) reserveCall() bool {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if sn.MaxSessions > 0 && sn.ActiveCalls >= sn.MaxSessions {
+		return false
+	}
+	sn.ActiveCalls++
+	Prometrics.ActiveDialogs.WithLabelValues(sn.Description).Set(float64(sn.ActiveCalls))
+	return true
+}
+
+func (sn *SipNode) endCall() {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.ActiveCalls--
+	Prometrics.ActiveDialogs.WithLabelValues(sn.Description).Set(float64(sn.ActiveCalls))
+	if sn.State == NodeDraining && sn.ActiveCalls <= 0 {
+		sn.State = NodeDisabled
+		sn.log().Info("Server drained", "previous", NodeDraining, "state", NodeDisabled)
+
+		ev := newNodeEvent(sn)
+		ev.Previous = NodeDraining
+		events.Publish(events.NodeState, ev)
+	}
+}
+
+// rejectMaxCalls rejects a dialogue over the global maxCalls or the maxCalls of its quota
+func rejectMaxCalls(filterID string, sipmsg *SipMessage, srcAddr *net.UDPAddr, rsv cl.Reservation) {
+	code, reason := CallLimiter.MaxCallsRejectCode(), "Call Limit Reached"
+	if rsv.ByQuota {
+		reason = "Quota Call Limit Reached"
+	}
+	logDebugRejection(filterID, sipmsg, srcAddr, code, reason)
+	sendErrorResponse(sipmsg, code, reason, srcAddr)
+}
+
+// rejectServerFull rejects a dialogue to or from a SIP server at its maxSessions
+func rejectServerFull(filterID string, sipmsg *SipMessage, srcAddr *net.UDPAddr) {
+	code := CallLimiter.MaxCallsRejectCode()
+	logDebugRejection(filterID, sipmsg, srcAddr, code, "Server Call Limit Reached")
+	sendErrorResponse(sipmsg, code, "Server Call Limit Reached", srcAddr)
+}
+
+// releaseCall ends the dialogue's share of ActiveCalls and maxCalls once, when
+// it ends or at the latest when it is cleared, must be called while holding cc.mu
+func (cc *CallCache) releaseCall() {
+	if cc.released {
+		return
+	}
+	cc.released = true
+	cc.SIPNode.endCall()
+	cc.session.Release()
+}
+
+// sendErrorResponse rejects a request on behalf of the SIP servers
+func sendErrorResponse(rqst *SipMessage, code int, reason string, rmtUDPAddr *net.UDPAddr) {
+	sendRejection(BuildResponseMessage(rqst, code, reason), rmtUDPAddr)
+}
+
+// sendRetryResponse rejects a request, telling the sender to retry after the
+// given delay rounded up to seconds, if any
+func sendRetryResponse(rqst *SipMessage, code int, reason string, retryAfter time.Duration, rmtUDPAddr *net.UDPAddr) {
+	rspnsmsg := BuildResponseMessage(rqst, code, reason)
+	if retryAfter > 0 {
+		rspnsmsg.Headers.Add(Retry_After, strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	}
+	sendRejection(rspnsmsg, rmtUDPAddr)
+}
+
+func sendRejection(rspnsmsg *SipMessage, rmtUDPAddr *net.UDPAddr) {
+	code := rspnsmsg.StartLine.StatusCode
+	Prometrics.LocalResponses.WithLabelValues(strconv.Itoa(code)).Inc()
+	history.Inc(history.Rejects, fmt.Sprintf("%d %s", code, rspnsmsg.StartLine.ReasonPhrase))
+	sendMessage(rspnsmsg, rmtUDPAddr)
 }
 
 func sendMessage(sipmsg *SipMessage, rmtUDPAddr *net.UDPAddr) {
-	_, err := ServerConnection.WriteTo(sipmsg.Bytes(), rmtUDPAddr)
+	err := writeTo(sipmsg.Bytes(), rmtUDPAddr)
 	if err != nil {
-		log.Println("Failed to send response message - error:", err)
+		callLog(sipmsg.CallID, rmtUDPAddr).Error("Failed to send response message", logging.Err(err))
 	}
 }
@@ -0,0 +1,56 @@
+package sip
+
+import (
+	"testing"
+	"time"
+
+	. "siploadbalancer/global"
+)
+
+func testOutboundInvite(t *testing.T, core, uas *testPeer, callID string) *SipMessage {
+	return parseTestMessage(t, `INVITE sip:bob@%s SIP/2.0
+Via: SIP/2.0/UDP %s;branch=z9hG4bK-%s
+From: <sip:100@127.0.0.1>;tag=c1
+To: <sip:bob@example.com>
+Call-ID: %s
+CSeq: 1 INVITE
+Contact: <sip:100@%s>
+Max-Forwards: 70
+Content-Length: 0
+
+`, uas.addr(), core.addr(), callID, callID, core.addr())
+}
+
+// maxSessions counts the dialogues a server originates as well as those it is sent
+func TestMaxSessionsBothDirections(t *testing.T) {
+	core, uas, uac := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	sn := newTestBalancer(t, core, 1)
+	lb := LoadBalancer
+	active := CallLimiter.ActiveCalls()
+
+	cc, target := lb.AddOrGetCallCache(testOutboundInvite(t, core, uas, "out-1"), core.addr(), time.Now())
+	if cc == nil || target.String() != uas.addr().String() {
+		t.Fatalf("outbound INVITE not forwarded to %s", uas.addr())
+	}
+
+	if cc, _ := lb.AddOrGetCallCache(testOutboundInvite(t, core, uas, "out-2"), core.addr(), time.Now()); cc != nil {
+		t.Error("second outbound INVITE admitted over maxSessions")
+	}
+	if got := core.receive(200 * time.Millisecond); len(got) != 1 || got[0] != "SIP/2.0 503 Server Call Limit Reached" {
+		t.Errorf("core received %q, want a 503 Server Call Limit Reached", got)
+	}
+
+	if cc, _ := lb.AddOrGetCallCache(testInvite(t, uac, "in-1"), uac.addr(), time.Now()); cc != nil {
+		t.Error("inbound INVITE sent to a server at its maxSessions")
+	}
+	if got := uac.receive(200 * time.Millisecond); len(got) != 1 || got[0] != "SIP/2.0 503 Server Call Limit Reached" {
+		t.Errorf("originator received %q, want a 503 Server Call Limit Reached", got)
+	}
+
+	if got := sn.Info().ActiveCalls; got != 1 {
+		t.Errorf("server ActiveCalls = %d, want 1", got)
+	}
+	if got := CallLimiter.ActiveCalls() - active; got != 1 {
+		t.Errorf("limiter holds %d more dialogues, want 1: rejected dialogues must give their place back", got)
+	}
+}
@@ -0,0 +1,33 @@
+package sip
+
+import (
+	"log/slog"
+	"net"
+
+	"siploadbalancer/logging"
+)
+
+var logger = logging.For("sip")
+
+// log returns the logger of the node, must be called while holding sn.mu
+func (sn *SipNode) log() *slog.Logger {
+	return logger.With(logging.KeyNode, sn.Key, "description", sn.Description, "addr", sn.UdpAddr.String())
+}
+
+// callLog returns the logger of a message or dialogue
+func callLog(callID string, peer *net.UDPAddr) *slog.Logger {
+	l := logger.With(logging.KeyCallID, callID)
+	if peer != nil {
+		l = l.With(logging.KeyPeer, peer.String())
+	}
+	return l
+}
+
+// log returns the logger of the dialogue, must be called while holding cc.mu
+func (cc *CallCache) log() *slog.Logger {
+	l := callLog(cc.CallID, cc.OtherAddr)
+	if cc.SIPNode != nil {
+		l = l.With(logging.KeyNode, cc.SIPNode.Key)
+	}
+	return l
+}
@@ -0,0 +1,159 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"siploadbalancer/cl"
+	. "siploadbalancer/global"
+	"siploadbalancer/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	Prometrics = prometheus.NewMetrics()
+	CallLimiter = cl.NewCallLimiter(-1, cl.Settings{Mode: cl.ModeWindow, RejectCode: cl.DefaultRejectCode, MaxCallsRejectCode: cl.DefaultMaxCallsRejectCode}, Prometrics, &WtGrp)
+
+	var err error
+	if ServerConnection, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// testPeer is a SIP endpoint on a local UDP socket
+type testPeer struct {
+	conn *net.UDPConn
+	t    *testing.T
+}
+
+func newTestPeer(t *testing.T) *testPeer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testPeer{conn: conn, t: t}
+}
+
+func (p *testPeer) addr() *net.UDPAddr {
+	return p.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// receive returns the start lines of the messages the balancer sent to p within wait
+func (p *testPeer) receive(wait time.Duration) []string {
+	var lines []string
+	buf := make([]byte, 65535)
+	p.conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			return lines
+		}
+		line, _, _ := strings.Cut(string(buf[:n]), "\r\n")
+		lines = append(lines, line)
+	}
+}
+
+// newTestBalancer installs a balancer with core as its only, alive, server
+func newTestBalancer(t *testing.T, core *testPeer, maxSessions int) *SipNode {
+	in := inputData{
+		LoadbalanceMode:      string(DistribRoundRobin),
+		ProbingInterval:      60,
+		TimeoutTimerDuration: 1,
+		ClearTimerDuration:   1,
+		MaxDialogDuration:    60,
+		MinHealthyNodes:      1,
+		HitWindow:            60,
+		Trace:                TraceSettings{MaxMessagesPerCall: 10},
+		Servers: []ServerData{{
+			Ipv4: "127.0.0.1", Port: core.addr().Port, Description: "core", Weight: 1, MaxSessions: maxSessions,
+		}},
+	}
+	in.Quality.setDefaults()
+	useTestBalancer(t, NewLoadBalancer(in))
+	sn := LoadBalancer.SipNodes[0]
+	sn.IsAlive = true
+	return sn
+}
+
+// useTestBalancer installs lb until the end of the test, when its dialogues
+// are stopped and cleared so that no timer outlives the test
+func useTestBalancer(t *testing.T, lb *LoadBalancingNode) {
+	LoadBalancer = lb
+	t.Cleanup(func() {
+		lb.mu.RLock()
+		calls := make([]*CallCache, 0, len(lb.callsCache))
+		for _, cc := range lb.callsCache {
+			calls = append(calls, cc)
+		}
+		lb.mu.RUnlock()
+
+		for _, cc := range calls {
+			cc.mu.Lock()
+			for _, tmr := range []*time.Timer{cc.timeoutTmr, cc.clearTmr} {
+				if tmr != nil {
+					tmr.Stop()
+				}
+			}
+			cc.mu.Unlock()
+			lb.DeleteCallCache(cc.CallID)
+		}
+	})
+}
+
+func parseTestMessage(t *testing.T, format string, args ...any) *SipMessage {
+	t.Helper()
+	raw := strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", "\r\n")
+	sipmsg, _, err := parsePDU([]byte(raw))
+	if err != nil || sipmsg == nil {
+		t.Fatalf("cannot parse %q: %v", raw, err)
+	}
+	return sipmsg
+}
+
+func testInvite(t *testing.T, uac *testPeer, callID string) *SipMessage {
+	return parseTestMessage(t, `INVITE sip:100@127.0.0.1 SIP/2.0
+Via: SIP/2.0/UDP %s;branch=z9hG4bK-%s
+From: <sip:alice@example.com>;tag=a1
+To: <sip:100@127.0.0.1>
+Call-ID: %s
+CSeq: 1 INVITE
+Contact: <sip:alice@%s>
+Max-Forwards: 70
+Content-Length: 0
+
+`, uac.addr(), callID, callID, uac.addr())
+}
+
+func testCancel(t *testing.T, uac *testPeer, callID string) *SipMessage {
+	return parseTestMessage(t, `CANCEL sip:100@127.0.0.1 SIP/2.0
+Via: SIP/2.0/UDP %s;branch=z9hG4bK-%s
+From: <sip:alice@example.com>;tag=a1
+To: <sip:100@127.0.0.1>
+Call-ID: %s
+CSeq: 1 CANCEL
+Max-Forwards: 70
+Content-Length: 0
+
+`, uac.addr(), callID, callID)
+}
+
+// testResponse answers the INVITE as forwarded by the balancer, with our Via on top
+func testResponse(t *testing.T, cc *CallCache, uac *testPeer, code int, reason string) *SipMessage {
+	return parseTestMessage(t, `SIP/2.0 %d %s
+Via: SIP/2.0/UDP 127.0.0.1;branch=%s
+Via: SIP/2.0/UDP %s;branch=z9hG4bK-%s
+From: <sip:alice@example.com>;tag=a1
+To: <sip:100@127.0.0.1>;tag=b2
+Call-ID: %s
+CSeq: 1 INVITE
+Contact: <sip:100@127.0.0.1:5099>
+Content-Length: 0
+
+`, code, reason, cc.OwnViaBranch, uac.addr(), cc.CallID, cc.CallID)
+}
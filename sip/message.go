@@ -12,10 +12,12 @@ type SipMessage struct {
 	Headers   *SipHeaders
 	Body      []byte
 
-	CallID    string
-	FromTag   string
-	ToTag     string
-	ViaBranch string
+	CallID     string
+	FromTag    string
+	ToTag      string
+	ViaBranch  string
+	CSeqNum    uint32
+	CSeqMethod Method
 }
 
 func BuildOptionsMessage(viaBranch, localstr, remotestr, callid, frmTag string) *SipMessage {
@@ -40,6 +42,31 @@ func BuildOptionsMessage(viaBranch, localstr, remotestr, callid, frmTag string)
 	}
 }
 
+func BuildInDialogRequest(mthd Method, ruri, viaBranch, from, to, callid string, cseq uint32) *SipMessage {
+	hdrs := NewSipHeaders()
+	hdrs.Add(Via, buildViaHeader(viaBranch))
+	hdrs.Add(From, from)
+	hdrs.Add(To, to)
+	hdrs.Add(Call_ID, callid)
+	hdrs.Add(CSeq, fmt.Sprintf("%d %s", cseq, mthd))
+	hdrs.Add(Max_Forwards, "70")
+	hdrs.Add(User_Agent, BUE)
+	hdrs.Add(Content_Length, "0")
+
+	return &SipMessage{
+		MsgType: REQUEST,
+		StartLine: SipStartLine{
+			Method: mthd,
+			RUri:   ruri,
+		},
+		Headers:    hdrs,
+		CallID:     callid,
+		ViaBranch:  viaBranch,
+		CSeqNum:    cseq,
+		CSeqMethod: mthd,
+	}
+}
+
 func BuildResponseMessage(rqstmsg *SipMessage, sc int, rp string) *SipMessage {
 	hdrs := NewSipHeaders()
 	hdrs.Add(Via, rqstmsg.Headers.GetHeaderValues(ViaHeader)...)
@@ -0,0 +1,209 @@
+package sip
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"siploadbalancer/events"
+	. "siploadbalancer/global"
+	"siploadbalancer/window"
+)
+
+const (
+	QualityEvalInterval = 10 * time.Second
+	qualityBuckets      = 30
+
+	DefaultQualityWindow      = 300 // seconds
+	DefaultMinSeizures        = 20
+	DefaultMinASR             = 20 // percent
+	DefaultMaxFailureRatio    = 50 // percent
+	DefaultMinQualityFactor   = 0.05
+	DefaultQualityRestoreStep = 0.1
+)
+
+type (
+	// QualitySettings steer new dialogues away from SIP servers whose ASR or
+	// failure ratio over the window is poor, whatever the distribution.
+	QualitySettings struct {
+		Enabled         bool    `json:"enabled"`
+		Window          int     `json:"window"`          // seconds
+		MinSeizures     int     `json:"minSeizures"`     // seizures in the window before judging a server
+		MinASR          float64 `json:"minASR"`          // percent
+		MaxFailureRatio float64 `json:"maxFailureRatio"` // percent of timeouts, 408 and 5xx
+		MinFactor       float64 `json:"minFactor"`       // share of its dialogues a degraded server keeps
+		RestoreStep     float64 `json:"restoreStep"`     // factor regained per evaluation once healthy
+	}
+
+	// nodeQuality must be accessed while holding the SipNode mu
+	nodeQuality struct {
+		seizures *window.Counter
+		answered *window.Counter
+		failures *window.Counter
+	}
+
+	QualityEvent struct {
+		NodeEvent
+		Factor       float64 `json:"factor"`
+		ASR          float64 `json:"asr"`
+		FailureRatio float64 `json:"failureRatio"`
+		Seizures     int64   `json:"seizures"`
+	}
+)
+
+func defaultQualitySettings() QualitySettings {
+	return QualitySettings{
+		Window:          DefaultQualityWindow,
+		MinSeizures:     DefaultMinSeizures,
+		MinASR:          DefaultMinASR,
+		MaxFailureRatio: DefaultMaxFailureRatio,
+		MinFactor:       DefaultMinQualityFactor,
+		RestoreStep:     DefaultQualityRestoreStep,
+	}
+}
+
+// UnmarshalJSON gives the keys missing from data their default, so that an
+// explicit 0 for minASR or minFactor is kept as a setting of its own
+func (qs *QualitySettings) UnmarshalJSON(data []byte) error {
+	type plain QualitySettings
+	p := plain(defaultQualitySettings())
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*qs = QualitySettings(p)
+	return nil
+}
+
+// setDefaults covers settings that were not read from JSON, such as a
+// configuration without a quality section
+func (qs *QualitySettings) setDefaults() {
+	if *qs == (QualitySettings{}) {
+		*qs = defaultQualitySettings()
+	}
+	if qs.Window == 0 {
+		qs.Window = DefaultQualityWindow
+	}
+}
+
+func (qs *QualitySettings) validate() error {
+	switch {
+	case qs.Window < 0:
+		return fmt.Errorf("quality.window [%d] is invalid", qs.Window)
+	case qs.MinSeizures < 0:
+		return fmt.Errorf("quality.minSeizures [%d] is invalid", qs.MinSeizures)
+	case qs.MinASR < 0 || qs.MinASR > 100:
+		return fmt.Errorf("quality.minASR [%g] must be between 0 and 100", qs.MinASR)
+	case qs.MaxFailureRatio < 0 || qs.MaxFailureRatio > 100:
+		return fmt.Errorf("quality.maxFailureRatio [%g] must be between 0 and 100", qs.MaxFailureRatio)
+	case qs.MinFactor < 0 || qs.MinFactor > 1:
+		return fmt.Errorf("quality.minFactor [%g] must be between 0 and 1", qs.MinFactor)
+	case qs.RestoreStep < 0 || qs.RestoreStep > 1:
+		return fmt.Errorf("quality.restoreStep [%g] must be between 0 and 1", qs.RestoreStep)
+	}
+	return nil
+}
+
+func (qs *QualitySettings) windowSize() time.Duration {
+	return time.Duration(qs.Window) * time.Second
+}
+
+func newNodeQuality(size time.Duration) nodeQuality {
+	return nodeQuality{
+		seizures: window.New(size, qualityBuckets),
+		answered: window.New(size, qualityBuckets),
+		failures: window.New(size, qualityBuckets),
+	}
+}
+
+// ==========================================================================
+
+// recordQuality must be called after the outcome of a dialogue routed to sn is known
+func (sn *SipNode) recordQuality(answered, failed bool) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.quality.seizures.Add(1)
+	if answered {
+		sn.quality.answered.Add(1)
+	}
+	if failed {
+		sn.quality.failures.Add(1)
+	}
+}
+
+func (sn *SipNode) resetQuality(size time.Duration) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	if sn.quality.seizures.Size() != size {
+		sn.quality = newNodeQuality(size)
+	}
+}
+
+// evaluateQuality halves the factor of a degraded node and restores a healthy
+// one step by step
+func (sn *SipNode) evaluateQuality(qs QualitySettings) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	seizures := sn.quality.seizures.Sum()
+	var asr, failureRatio float64
+	if seizures > 0 {
+		asr = 100 * float64(sn.quality.answered.Sum()) / float64(seizures)
+		failureRatio = 100 * float64(sn.quality.failures.Sum()) / float64(seizures)
+	}
+	degraded := qs.Enabled && seizures >= int64(qs.MinSeizures) && (asr < qs.MinASR || failureRatio > qs.MaxFailureRatio)
+
+	factor := sn.QualityFactor
+	switch {
+	case !qs.Enabled:
+		factor = 1
+	case degraded:
+		factor = max(factor/2, qs.MinFactor)
+	default:
+		factor = min(factor+qs.RestoreStep, 1)
+	}
+	if factor == sn.QualityFactor {
+		return
+	}
+
+	ev := QualityEvent{NodeEvent: newNodeEvent(sn), Factor: factor, ASR: asr, FailureRatio: failureRatio, Seizures: seizures}
+	switch {
+	case degraded && sn.QualityFactor == 1:
+		sn.log().Warn("Server degraded", "asr", asr, "failureRatio", failureRatio, "seizures", seizures)
+		events.Publish(events.NodeDegraded, ev)
+	case factor == 1:
+		sn.log().Info("Server restored")
+		events.Publish(events.NodeRestored, ev)
+	}
+
+	sn.QualityFactor = factor
+	Prometrics.QualityFactor.WithLabelValues(sn.Description).Set(factor)
+}
+
+// skipDegraded reports whether GetNode should pass on sn this time, so that
+// a degraded node only gets its factor of the dialogues
+func (sn *SipNode) skipDegraded() bool {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+
+	return sn.QualityFactor < 1 && rand.Float64() >= sn.QualityFactor
+}
+
+func (lb *LoadBalancingNode) periodicQualityEvaluation() {
+	ticker := time.NewTicker(QualityEvalInterval)
+	WtGrp.Add(1)
+	go func() {
+		defer WtGrp.Done()
+		for range ticker.C {
+			lb.mu.RLock()
+			qs := lb.Quality
+			lb.mu.RUnlock()
+
+			for _, sn := range lb.GetSipNodes() {
+				sn.evaluateQuality(qs)
+			}
+		}
+	}()
+}
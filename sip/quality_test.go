@@ -0,0 +1,71 @@
+package sip
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQualitySettingsZero(t *testing.T) {
+	defaults := defaultQualitySettings()
+	tests := []struct {
+		name    string
+		quality string
+		want    QualitySettings
+	}{
+		{"absent", ``, defaults},
+		{"empty", `, "quality": {}`, defaults},
+		{"enabled only", `, "quality": {"enabled": true}`, func() QualitySettings { qs := defaults; qs.Enabled = true; return qs }()},
+		{"explicit zeros", `, "quality": {"enabled": true, "window": 0, "minSeizures": 0, "minASR": 0, "maxFailureRatio": 0, "minFactor": 0, "restoreStep": 0}`,
+			QualitySettings{Enabled: true, Window: DefaultQualityWindow}},
+	}
+	for _, tt := range tests {
+		in, err := parseInputData([]byte(`{"ipv4": "127.0.0.1", "sipUdpPort": 5060, "httpPort": 9080, "loadbalancemode": "RoundRobin", "probingInterval": 60` + tt.quality + `}`))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if in.Quality != tt.want {
+			t.Errorf("%s: quality = %+v, want %+v", tt.name, in.Quality, tt.want)
+		}
+	}
+}
+
+func TestQualitySettingsZeroKeptByAdmin(t *testing.T) {
+	startTestConfig(t, testConfig(t, 60))
+
+	var st Settings
+	if err := json.Unmarshal([]byte(`{"quality": {"enabled": true, "minASR": 0, "minFactor": 0}}`), &st); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadBalancer.UpdateSettings(st, false); err != nil {
+		t.Fatal(err)
+	}
+	// another change reapplies the defaults to the whole configuration
+	window := 120
+	if err := LoadBalancer.UpdateSettings(Settings{HitWindow: &window}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	qs := LoadBalancer.settings().Quality
+	if !qs.Enabled || qs.MinASR != 0 || qs.MinFactor != 0 || qs.MinSeizures != DefaultMinSeizures {
+		t.Errorf("quality = %+v, want minASR and minFactor kept at 0", qs)
+	}
+}
+
+func TestQualityZeroThresholds(t *testing.T) {
+	sn := newTestBalancer(t, newTestPeer(t), 0)
+	sn.recordQuality(false, false) // ASR 0, no failure
+
+	// minASR 0: an ASR of 0 is acceptable
+	sn.QualityFactor = 0.5
+	sn.evaluateQuality(QualitySettings{Enabled: true, Window: 60, MinSeizures: 1, MinASR: 0, MaxFailureRatio: 50, MinFactor: 0, RestoreStep: 0.1})
+	if sn.QualityFactor != 0.6 {
+		t.Errorf("factor = %g with minASR 0, want it restored to 0.6", sn.QualityFactor)
+	}
+
+	// minFactor 0: the factor keeps halving below the default floor
+	sn.QualityFactor = DefaultMinQualityFactor
+	sn.evaluateQuality(QualitySettings{Enabled: true, Window: 60, MinSeizures: 1, MinASR: 50, MaxFailureRatio: 50, MinFactor: 0, RestoreStep: 0.1})
+	if sn.QualityFactor != DefaultMinQualityFactor/2 {
+		t.Errorf("factor = %g with minFactor 0, want %g", sn.QualityFactor, DefaultMinQualityFactor/2)
+	}
+}
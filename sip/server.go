@@ -1,35 +1,15 @@
 package sip
 
 import (
-	"encoding/json"
-	"fmt"
 	"net"
 	"os"
+	"siploadbalancer/cl"
 	"siploadbalancer/global"
+	"siploadbalancer/history"
+	"siploadbalancer/logging"
 	"time"
 )
 
-type inputData struct {
-	IPv4          string `json:"ipv4"`
-	SipUdpPort    int    `json:"sipUdpPort"`
-	HttpPort      int    `json:"httpPort"`
-	CachingServer string `json:"cachingServer"`
-
-	LoadbalanceMode          string `json:"loadbalancemode"`
-	MaxCallAttemptsPerSecond int    `json:"maxCallAttemptsPerSecond"`
-	ProbingInterval          int    `json:"probingInterval"`
-	TimeoutTimerDuration     int    `json:"timeoutTimerDuration"`
-	ClearTimerDuration       int    `json:"clearTimerDuration"`
-
-	Servers []struct {
-		Ipv4        string `json:"ipv4"`
-		Port        int    `json:"port"`
-		Description string `json:"description"`
-		Weight      int    `json:"weight"`
-		Cost        int    `json:"cost"`
-	} `json:"servers"`
-}
-
 func startListening(ip net.IP, prt int) (*net.UDPConn, error) {
 	socket := net.UDPAddr{}
 	socket.IP = ip
@@ -37,55 +17,53 @@ func startListening(ip net.IP, prt int) (*net.UDPConn, error) {
 	return net.ListenUDP("udp", &socket)
 }
 
-func InitializeServer(data []byte) (net.IP, int, int) {
-	var (
-		inputData inputData
-		err       error
-	)
-
-	if err = json.Unmarshal(data, &inputData); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+func InitializeServer(data []byte) (net.IP, int, int, cl.Settings) {
+	inputData, err := parseStartupData(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid configuration", logging.Err(err))
 	}
 
 	serverIP := net.ParseIP(inputData.IPv4)
-	fmt.Print("Attempting to listen on SIP...")
 	if ServerConnection, err = startListening(serverIP, inputData.SipUdpPort); err != nil {
-		fmt.Println(err)
+		logger.Error("Failed to listen on SIP", logging.Err(err))
 		os.Exit(2)
 	}
-	fmt.Println("Success: UDP", ServerConnection.LocalAddr().String())
+	logger.Info("Listening on SIP", "addr", ServerConnection.LocalAddr().String(), "transport", "udp")
 
-	fmt.Print("Checking Caching Server...")
 	// ripv4skt, err := redis.SetupCheckRedis(redisskt, "", 0, 15) //TODO: add redis password, db and expiryMin
 	// if err != nil {
-	// 	fmt.Println(err)
+	// 	logger.Error("Caching Server unavailable", logging.Err(err))
 	// 	os.Exit(3)
 	// }
-	// fmt.Printf("Ready! [%s]\n", ripv4skt)
-	fmt.Println("Skipped!")
+	logger.Info("Checking Caching Server skipped")
 
 	LoadBalancer = NewLoadBalancer(inputData)
+	global.RegisterReloader("sip", reloadConfig)
 
-	return serverIP, inputData.HttpPort, inputData.MaxCallAttemptsPerSecond
+	return serverIP, inputData.HttpPort, inputData.MaxCallAttemptsPerSecond, inputData.CallLimiter
 }
 
 func StartSS() {
 	startWorkers()
 	udpLoopWorkers()
 	periodicProbing()
+	LoadBalancer.periodicQualityEvaluation()
+	LoadBalancer.periodicHitRefresh()
+	history.Sample(history.Sessions, func() float64 { return float64(LoadBalancer.CallsCacheCount()) })
 
-	fmt.Println("SipLoadBalancer Server Ready!")
+	logger.Info("SipLoadBalancer Server Ready!")
 }
 
 func periodicProbing() {
 	global.WtGrp.Add(1)
-	duration := time.Duration(LoadBalancer.ProbingInterval) * time.Second
-	ticker := time.NewTicker(duration)
+	duration := time.Duration(LoadBalancer.settings().ProbingInterval) * time.Second
+	LoadBalancer.mu.Lock()
+	LoadBalancer.probingTicker = time.NewTicker(duration)
+	LoadBalancer.mu.Unlock()
 	LoadBalancer.ProbeSipNodes() // to run once when system starts
 	go func() {
 		defer global.WtGrp.Done()
-		for range ticker.C {
+		for range LoadBalancer.probingTicker.C {
 			LoadBalancer.ProbeSipNodes()
 		}
 	}()
@@ -0,0 +1,120 @@
+package sip
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/tracing"
+)
+
+// span attribute keys
+const (
+	attrMethod     = "sip.method"
+	attrStatusCode = "sip.status_code"
+	attrCallID     = "sip.call_id"
+	attrStatus     = "sip.dialog_status"
+	attrPeer       = "net.peer.address"
+	attrDirection  = "slb.direction"
+	attrNode       = "slb.node"
+	attrNodeKey    = "slb.node_key"
+	attrNodeAddr   = "slb.node_address"
+	attrFailovers  = "slb.failover_attempts"
+	attrDistrib    = "slb.distribution"
+)
+
+// startSpan starts the span of a new dialogue, the first message having been
+// received at received, and records the node selection that took from
+// selStart to selEnd. Must be called before cc is cached.
+func (cc *CallCache) startSpan(sipmsg *SipMessage, received, selStart, selEnd time.Time, distrib Distribution) {
+	cc.span = tracing.NewTrace(fmt.Sprintf("SIP %s", sipmsg.GetMethod()), tracing.KindServer, received)
+	if cc.span == nil {
+		return
+	}
+	cc.transactions = make(map[string]time.Time)
+
+	node := cc.SIPNode.GetDescription()
+	direction := DirectionOutbound
+	if cc.IsInbound {
+		direction = DirectionInbound
+	}
+	cc.span.Set(attrMethod, string(sipmsg.GetMethod())).
+		Set(attrCallID, cc.CallID).
+		Set(attrPeer, cc.OtherAddr.String()).
+		Set(attrDirection, direction).
+		Set(attrNode, node).
+		Set(attrNodeKey, cc.SIPNode.Key).
+		Set(attrNodeAddr, cc.SIPNode.UdpAddr.String()).
+		Set(attrFailovers, cc.failovers)
+
+	if cc.IsInbound {
+		cc.span.Child("GetNode", tracing.KindInternal, selStart).
+			Set(attrDistrib, string(distrib)).
+			Set(attrNode, node).
+			Set(attrFailovers, cc.failovers).
+			End(selEnd)
+	}
+}
+
+// traceTransaction records the receipt of a message, its forwarding and, for
+// responses, the time the far end took to respond
+func (cc *CallCache) traceTransaction(sipmsg *SipMessage, srcAddr, rmtAddr *net.UDPAddr, received, forwarded time.Time, fwdErr error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.span == nil {
+		return
+	}
+	done := time.Now()
+	label := string(sipmsg.GetMethod())
+	if sipmsg.IsResponse() {
+		label = fmt.Sprint(sipmsg.GetStatusCode())
+	}
+	txn := fmt.Sprintf("%d %s", sipmsg.CSeqNum, sipmsg.CSeqMethod)
+
+	if sipmsg.IsResponse() {
+		if sent, ok := cc.transactions[txn]; ok {
+			cc.span.Child("response "+label, tracing.KindClient, sent).
+				Set(attrMethod, string(sipmsg.CSeqMethod)).
+				Set(attrStatusCode, sipmsg.GetStatusCode()).
+				Set(attrPeer, srcAddr.String()).
+				End(received)
+			if IsFinal(sipmsg.GetStatusCode()) {
+				delete(cc.transactions, txn)
+			}
+		}
+	}
+
+	rcv := cc.span.Child("receive "+label, tracing.KindInternal, received).Set(attrPeer, srcAddr.String())
+	fwd := cc.span.Child("forward "+label, tracing.KindInternal, forwarded).Set(attrPeer, rmtAddr.String())
+	if sipmsg.IsResponse() {
+		rcv.Set(attrStatusCode, sipmsg.GetStatusCode())
+		fwd.Set(attrStatusCode, sipmsg.GetStatusCode())
+	} else {
+		rcv.Set(attrMethod, label)
+		fwd.Set(attrMethod, label)
+	}
+	rcv.End(forwarded)
+	if fwdErr != nil {
+		fwd.Fail(fwdErr.Error())
+	} else if sipmsg.IsRequest() && sipmsg.GetMethod() != ACK {
+		cc.transactions[txn] = done
+	}
+	fwd.End(done)
+}
+
+// endSpan ends the span of the dialogue, must be called while holding cc.mu
+func (cc *CallCache) endSpan(t time.Time) {
+	if cc.span.Ended() {
+		return
+	}
+	cc.span.Set(attrStatus, string(cc.CallStatus))
+	if cc.finalCode != 0 {
+		cc.span.Set(attrStatusCode, cc.finalCode)
+	}
+	if isNetworkFailure(cc.CallStatus, cc.finalCode) {
+		cc.span.Fail(string(cc.CallStatus))
+	}
+	cc.span.End(t)
+}
@@ -0,0 +1,113 @@
+package sip
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+const (
+	DefaultTraceMessages = 200
+
+	TraceIn  = "in"  // received by the balancer
+	TraceOut = "out" // sent by the balancer
+)
+
+type (
+	TraceSettings struct {
+		Enabled            bool `json:"enabled"`            // keep raw messages of every dialogue
+		MaxMessagesPerCall int  `json:"maxMessagesPerCall"` // 0=Default 200
+	}
+
+	TraceRecord struct {
+		Time      time.Time `json:"time"`
+		Direction string    `json:"direction"`
+		Peer      string    `json:"peer"`
+		Message   string    `json:"message"`
+	}
+
+	// CallTrace is the SIP ladder of a dialogue as seen by the balancer
+	CallTrace struct {
+		CallID    string        `json:"callId"`
+		LocalAddr string        `json:"localAddr"`
+		PeerAddr  string        `json:"peerAddr"`
+		NodeAddr  string        `json:"nodeAddr"`
+		Node      string        `json:"node"`
+		FilterID  string        `json:"filterId,omitempty"` // the debug filter that matched the dialogue
+		Records   []TraceRecord `json:"records"`
+	}
+)
+
+func (ts *TraceSettings) setDefaults() {
+	if ts.MaxMessagesPerCall == 0 {
+		ts.MaxMessagesPerCall = DefaultTraceMessages
+	}
+}
+
+// traceMessages records a forwarded message, as received and as sent
+func (cc *CallCache) traceMessages(in []byte, srcAddr *net.UDPAddr, out []byte, rmtAddr *net.UDPAddr) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.tracing {
+		return
+	}
+	cc.recordTrace(TraceIn, srcAddr, in)
+	cc.recordTrace(TraceOut, rmtAddr, out)
+}
+
+// must be called while holding cc.mu
+func (cc *CallCache) recordTrace(direction string, addr *net.UDPAddr, raw []byte) {
+	if cc.debugFilter != "" {
+		cc.log().Info("Debug trace", "filter", cc.debugFilter, "direction", direction, "addr", addr.String(), "message", string(raw))
+	}
+	if !cc.tracing || len(cc.trace) >= LoadBalancer.settings().Trace.MaxMessagesPerCall {
+		return
+	}
+	cc.trace = append(cc.trace, TraceRecord{
+		Time:      time.Now().UTC(),
+		Direction: direction,
+		Peer:      addr.String(),
+		Message:   string(raw), // copies, raw may belong to a pooled buffer
+	})
+}
+
+// sendLocal sends a message generated by the balancer within the dialogue,
+// must be called while holding cc.mu
+func (cc *CallCache) sendLocal(sipmsg *SipMessage, rmtAddr *net.UDPAddr) {
+	cc.addLocalHistory(sipmsg)
+	cc.recordTrace(TraceOut, rmtAddr, sipmsg.Bytes())
+	sendMessage(sipmsg, rmtAddr)
+}
+
+func (lb *LoadBalancingNode) GetCallTrace(callID string) (CallTrace, error) {
+	cc := lb.getCallCache(callID)
+	if cc == nil {
+		if ct, ok := findDebugTrace(callID); ok {
+			return ct, nil
+		}
+		return CallTrace{}, ErrCallNotFound
+	}
+	return cc.callTrace(), nil
+}
+
+func (cc *CallCache) callTrace() CallTrace {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+
+	ct := CallTrace{
+		CallID:    cc.CallID,
+		LocalAddr: ServerConnection.LocalAddr().String(),
+		NodeAddr:  cc.SIPNode.UdpAddr.String(),
+		Node:      cc.SIPNode.GetDescription(),
+		FilterID:  cc.debugFilter,
+		Records:   slices.Clone(cc.trace),
+	}
+	if cc.OtherAddr != nil {
+		ct.PeerAddr = cc.OtherAddr.String()
+	}
+	if ct.Records == nil {
+		ct.Records = []TraceRecord{}
+	}
+	return ct
+}
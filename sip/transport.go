@@ -0,0 +1,44 @@
+package sip
+
+import (
+	"net"
+
+	"siploadbalancer/capture"
+	"siploadbalancer/hep"
+)
+
+// writeTo sends a datagram, every message leaving the balancer goes through here
+func writeTo(raw []byte, rmtUDPAddr *net.UDPAddr) error {
+	_, err := ServerConnection.WriteTo(raw, rmtUDPAddr)
+	if err == nil {
+		mirrorSent(rmtUDPAddr, raw)
+	}
+	return err
+}
+
+// mirrorReceived passes a datagram read from the SIP socket to the running
+// capture and HEP exporters
+func mirrorReceived(src, local *net.UDPAddr, raw []byte) {
+	if capture.Active() {
+		capture.Received(src, local, raw)
+	}
+	if hep.Active() {
+		hep.Received(src, local, raw)
+	}
+}
+
+// mirrorSent passes a datagram written to the SIP socket to the running
+// capture and HEP exporters
+func mirrorSent(dst *net.UDPAddr, raw []byte) {
+	capturing, exporting := capture.Active(), hep.Active()
+	if !capturing && !exporting {
+		return
+	}
+	local := ServerConnection.LocalAddr().(*net.UDPAddr)
+	if capturing {
+		capture.Sent(local, dst, raw)
+	}
+	if exporting {
+		hep.Sent(local, dst, raw)
+	}
+}
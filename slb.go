@@ -10,45 +10,74 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"os"
 	"path/filepath"
+	"siploadbalancer/capture"
+	"siploadbalancer/cdr"
 	"siploadbalancer/cl"
 	"siploadbalancer/global"
+	"siploadbalancer/hep"
+	"siploadbalancer/history"
+	"siploadbalancer/logging"
 	"siploadbalancer/prometheus"
 	"siploadbalancer/sip"
+	"siploadbalancer/tracing"
+	"siploadbalancer/webhook"
 	"siploadbalancer/webserver"
 )
 
+var logger = logging.For("main")
+
 func greeting() {
-	fmt.Printf("Welcome to MT %s\n", global.BUE)
+	logger.Info("Welcome to MT " + global.BUE)
 }
 
+var configFile = flag.String("config", "", "path to the JSON configuration file (default: data.json next to the executable)")
+
 func main() {
+	flag.Parse()
+	global.ConfigPath = configPath()
+	data := readJsonFile(global.ConfigPath)
+	if err := logging.Setup(data); err != nil {
+		logging.Fatal(logger, "Invalid logging configuration", logging.Err(err))
+	}
+	global.RegisterReloader("logging", logging.Reload)
 	greeting()
 	global.Prometrics = prometheus.NewMetrics()
-	ip, hp, rate := sip.InitializeServer(readJsonFile())
-	global.CallLimiter = cl.NewCallLimiter(rate, global.Prometrics, &global.WtGrp)
+	ip, hp, rate, limits := sip.InitializeServer(data)
+	global.CallLimiter = cl.NewCallLimiter(rate, limits, global.Prometrics, &global.WtGrp)
 	// defer sip.ServerConnection.Close()
-	webserver.StartWS(ip, hp)
+	webserver.StartWS(ip, hp, data)
+	webhook.Start(data)
+	cdr.Start(data)
+	capture.Start(data)
+	hep.Start(data)
+	tracing.Start(data)
+	history.Start()
 	sip.StartSS()
+	global.WatchConfig(global.ConfigPath)
 	global.WtGrp.Wait()
 }
 
-func readJsonFile() []byte {
+func configPath() string {
+	if *configFile != "" {
+		return *configFile
+	}
+
 	exePath, err := os.Executable()
 	if err != nil {
-		fmt.Println("Error getting executable path:", err)
-		os.Exit(1)
+		logging.Fatal(logger, "Error getting executable path", logging.Err(err))
 	}
 	exeDir := filepath.Dir(exePath)
 
-	jsonPath := filepath.Join(exeDir, "data.json")
+	return filepath.Join(exeDir, "data.json")
+}
 
+func readJsonFile(jsonPath string) []byte {
 	data, err := os.ReadFile(jsonPath)
 	if err != nil {
-		fmt.Println("Error reading JSON file:", err)
-		os.Exit(1)
+		logging.Fatal(logger, "Error reading JSON file", "path", jsonPath, logging.Err(err))
 	}
 
 	return data
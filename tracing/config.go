@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+const (
+	DefaultServiceName   = "siploadbalancer"
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 // seconds
+	DefaultTimeout       = 10
+)
+
+type Config struct {
+	Enabled       bool              `json:"enabled"`
+	Endpoint      string            `json:"endpoint"` // OTLP/HTTP traces URL, e.g. http://collector:4318/v1/traces
+	Headers       map[string]string `json:"headers"`  // added to every export, e.g. authentication
+	ServiceName   string            `json:"serviceName"`
+	SampleRatio   *float64          `json:"sampleRatio"`   // share of dialogues traced, 0 to 1 (default 1)
+	BatchSize     int               `json:"batchSize"`     // spans per export
+	FlushInterval int               `json:"flushInterval"` // seconds before exporting a partial batch
+	Timeout       int               `json:"timeout"`       // seconds per export
+}
+
+func parseConfig(data []byte) (Config, error) {
+	var in struct {
+		Tracing Config `json:"tracing"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.Tracing, err
+	}
+	in.Tracing.setDefaults()
+	return in.Tracing, in.Tracing.validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.ServiceName == "" {
+		c.ServiceName = DefaultServiceName
+	}
+	if c.SampleRatio == nil {
+		ratio := 1.0
+		c.SampleRatio = &ratio
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+}
+
+func (c *Config) validate() error {
+	if *c.SampleRatio < 0 || *c.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sampleRatio [%g] must be between 0 and 1", *c.SampleRatio)
+	}
+	if c.BatchSize < 0 || c.FlushInterval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("tracing.batchSize, flushInterval and timeout must not be negative")
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("tracing.endpoint [%s] must be an http(s) URL", c.Endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// OTLP/HTTP JSON encoding of spans, see opentelemetry-proto trace/v1
+
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	scope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              Kind       `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	status struct {
+		Code    int    `json:"code,omitempty"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"` // int64 as a string
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+const statusError = 2
+
+func newExportRequest(serviceName string, spans []*Span) exportRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		out = append(out, s.otlp())
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{newKeyValue("service.name", serviceName)}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: DefaultServiceName}, Spans: out}},
+	}}}
+}
+
+func (s *Span) otlp() otlpSpan {
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	for _, a := range s.attrs {
+		out.Attributes = append(out.Attributes, newKeyValue(a.key, a.value))
+	}
+	if s.failed {
+		out.Status = status{Code: statusError, Message: s.errMsg}
+	}
+	return out
+}
+
+func newKeyValue(key string, value any) keyValue {
+	var v anyValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		i := strconv.Itoa(x)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(x, 10)
+		v.IntValue = &i
+	case uint32:
+		i := strconv.FormatUint(uint64(x), 10)
+		v.IntValue = &i
+	case float64:
+		v.DoubleValue = &x
+	default:
+		str := fmt.Sprint(x)
+		v.StringValue = &str
+	}
+	return keyValue{Key: key, Value: v}
+}
@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand/v2"
+	"time"
+)
+
+// Kind is the OTLP span kind
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// Span is a timed operation of a trace. A nil Span is valid and records
+// nothing, so callers need not check whether tracing is enabled. A Span is
+// not safe for concurrent use.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    []attribute
+	errMsg   string
+	failed   bool
+}
+
+// NewTrace starts the root span of a new trace, it returns nil when tracing
+// is disabled or the trace is not sampled
+func NewTrace(name string, kind Kind, start time.Time) *Span {
+	if !enabled.Load() {
+		return nil
+	}
+	if ratio := sampleRatio(); ratio < 1 && mrand.Float64() >= ratio {
+		return nil
+	}
+	s := &Span{name: name, kind: kind, start: start}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// Child starts a span within the trace of s
+func (s *Span) Child(name string, kind Kind, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{traceID: s.traceID, parentID: s.spanID, name: name, kind: kind, start: start}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// Set adds an attribute, value being a string, bool, integer or float
+func (s *Span) Set(key string, value any) *Span {
+	if s != nil && s.end.IsZero() {
+		s.attrs = append(s.attrs, attribute{key: key, value: value})
+	}
+	return s
+}
+
+// Fail marks the span as failed
+func (s *Span) Fail(msg string) *Span {
+	if s != nil && s.end.IsZero() {
+		s.failed, s.errMsg = true, msg
+	}
+	return s
+}
+
+// End ends the span at t and queues it for export, later calls are ignored
+func (s *Span) End(t time.Time) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = t
+	enqueue(s)
+}
+
+// Ended reports whether End was called
+func (s *Span) Ended() bool {
+	return s == nil || !s.end.IsZero()
+}
+
+// TraceID is the hex trace id, empty for a nil Span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
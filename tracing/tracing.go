@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+const QueueSize = 10000
+
+var (
+	logger = logging.For("tracing")
+
+	queue   = make(chan *Span, QueueSize)
+	enabled atomic.Bool
+
+	config   Config
+	configMu sync.RWMutex
+	failing  bool // the last export failed, logged once until it recovers
+)
+
+// Start exports the spans of the dialogues to the endpoint configured in data
+func Start(data []byte) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid tracing configuration", logging.Err(err))
+	}
+	setConfig(cfg)
+	RegisterReloader("tracing", reloadConfig)
+
+	go run()
+}
+
+func reloadConfig(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { setConfig(cfg) }, nil
+}
+
+func setConfig(cfg Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if cfg.Enabled && (!config.Enabled || cfg.Endpoint != config.Endpoint) {
+		logger.Info("Exporting traces", "endpoint", cfg.Endpoint, "sampleRatio", *cfg.SampleRatio)
+	}
+	config = cfg
+	enabled.Store(cfg.Enabled)
+}
+
+func getConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return config
+}
+
+func sampleRatio() float64 {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return *config.SampleRatio
+}
+
+// Enabled reports whether spans are exported
+func Enabled() bool {
+	return enabled.Load()
+}
+
+func enqueue(s *Span) {
+	select {
+	case queue <- s:
+	default:
+		Prometrics.DroppedSpans.Inc()
+	}
+}
+
+// run exports spans in batches, once a batch is full or at each flush interval
+func run() {
+	var batch []*Span
+	timer := time.NewTimer(time.Duration(getConfig().FlushInterval) * time.Second)
+	for {
+		select {
+		case s := <-queue:
+			batch = append(batch, s)
+			if len(batch) < getConfig().BatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+		if len(batch) > 0 {
+			export(batch)
+			batch = nil
+		}
+		timer.Reset(time.Duration(getConfig().FlushInterval) * time.Second)
+	}
+}
+
+func export(spans []*Span) {
+	cfg := getConfig()
+	if !cfg.Enabled {
+		return
+	}
+	body, err := json.Marshal(newExportRequest(cfg.ServiceName, spans))
+	if err != nil {
+		logger.Error("Failed to encode spans", logging.Err(err))
+		return
+	}
+
+	err = post(cfg, body)
+	switch {
+	case err != nil:
+		Prometrics.DroppedSpans.Add(float64(len(spans)))
+		if !failing {
+			logger.Warn("Failed to export spans, dropping them until the endpoint recovers", "endpoint", cfg.Endpoint, logging.Err(err))
+		}
+		failing = true
+	default:
+		Prometrics.ExportedSpans.Add(float64(len(spans)))
+		if failing {
+			logger.Info("Exporting spans again", "endpoint", cfg.Endpoint)
+		}
+		failing = false
+	}
+}
+
+func post(cfg Config, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	Prometrics = prometheus.NewMetrics()
+	os.Exit(m.Run())
+}
+
+// newCollector answers exports with the given status codes in turn, then 200
+func newCollector(t *testing.T, codes ...int) (*httptest.Server, <-chan *http.Request, <-chan []byte) {
+	reqs, bodies := make(chan *http.Request, 16), make(chan []byte, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- r
+		bodies <- body
+		code := http.StatusOK
+		if len(codes) > 0 {
+			code, codes = codes[0], codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reqs, bodies
+}
+
+func enable(t *testing.T, endpoint string) {
+	ratio := 1.0
+	setConfig(Config{Enabled: true, Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer t0ken"}, ServiceName: "slb-test", SampleRatio: &ratio, Timeout: 1})
+	t.Cleanup(func() { setConfig(Config{SampleRatio: &ratio}) })
+}
+
+func TestExportOTLP(t *testing.T) {
+	srv, reqs, bodies := newCollector(t)
+	enable(t, srv.URL)
+
+	start := time.Unix(1700000000, 500)
+	root := NewTrace("INVITE", KindServer, start).Set("sip.call_id", "a84b4c76e66710")
+	child := root.Child("core", KindClient, start.Add(time.Millisecond)).
+		Set("sip.status_code", 486).Set("net.peer.port", uint32(5060)).Set("sip.retransmitted", false).Fail("486 Busy Here")
+	child.End(start.Add(time.Second))
+	root.End(start.Add(2 * time.Second))
+	export([]*Span{root, child})
+
+	r := <-reqs
+	if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer t0ken" {
+		t.Errorf("headers = %v", r.Header)
+	}
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	body := <-bodies
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("body %s: %v", body, err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("body %s: want one resource, one scope and two spans", body)
+	}
+	if got := req.ResourceSpans[0].Resource.Attributes; len(got) != 1 || got[0]["key"] != "service.name" || got[0]["value"].(map[string]any)["stringValue"] != "slb-test" {
+		t.Errorf("resource attributes = %v", got)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	rs, cs := spans[0], spans[1]
+
+	hexID := func(n int) *regexp.Regexp { return regexp.MustCompile("^[0-9a-f]{" + strconv.Itoa(n) + "}$") }
+	if id, _ := rs["traceId"].(string); !hexID(32).MatchString(id) || id != root.TraceID() || cs["traceId"] != id {
+		t.Errorf("trace ids = %v and %v, want %s for both", rs["traceId"], cs["traceId"], root.TraceID())
+	}
+	if id, _ := rs["spanId"].(string); !hexID(16).MatchString(id) || cs["spanId"] == id {
+		t.Errorf("span ids = %v and %v", rs["spanId"], cs["spanId"])
+	}
+	if _, ok := rs["parentSpanId"]; ok {
+		t.Errorf("root span has a parent %v", rs["parentSpanId"])
+	}
+	if cs["parentSpanId"] != rs["spanId"] {
+		t.Errorf("child parent = %v, want %v", cs["parentSpanId"], rs["spanId"])
+	}
+
+	if rs["startTimeUnixNano"] != "1700000000000000500" || rs["endTimeUnixNano"] != "1700000002000000500" {
+		t.Errorf("root times = %v - %v, want nanoseconds as strings", rs["startTimeUnixNano"], rs["endTimeUnixNano"])
+	}
+	if rs["kind"] != float64(KindServer) || cs["kind"] != float64(KindClient) {
+		t.Errorf("kinds = %v and %v", rs["kind"], cs["kind"])
+	}
+
+	attrs := map[string]any{}
+	for _, a := range cs["attributes"].([]any) {
+		kv := a.(map[string]any)
+		attrs[kv["key"].(string)] = kv["value"]
+	}
+	for key, want := range map[string]string{
+		"sip.status_code":   `{"intValue":"486"}`, // int64 values are strings in OTLP/JSON
+		"net.peer.port":     `{"intValue":"5060"}`,
+		"sip.retransmitted": `{"boolValue":false}`,
+	} {
+		if got, _ := json.Marshal(attrs[key]); string(got) != want {
+			t.Errorf("attribute %s = %s, want %s", key, got, want)
+		}
+	}
+
+	if st, _ := rs["status"].(map[string]any); len(st) != 0 {
+		t.Errorf("root status = %v, want unset", st)
+	}
+	if st, _ := cs["status"].(map[string]any); st["code"] != float64(statusError) || st["message"] != "486 Busy Here" {
+		t.Errorf("child status = %v, want error with its message", st)
+	}
+}
+
+// a failing endpoint drops the batch, the next successful export recovers
+func TestExportDropAndRecover(t *testing.T) {
+	srv, reqs, _ := newCollector(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	enable(t, srv.URL)
+
+	batch := func() []*Span {
+		s := NewTrace("INVITE", KindServer, time.Now())
+		s.end = time.Now()
+		return []*Span{s, s.Child("core", KindClient, time.Now())}
+	}
+	dropped, exported := testutil.ToFloat64(Prometrics.DroppedSpans), testutil.ToFloat64(Prometrics.ExportedSpans)
+
+	export(batch())
+	export(batch())
+	<-reqs
+	<-reqs
+	if got := testutil.ToFloat64(Prometrics.DroppedSpans) - dropped; got != 4 || !failing {
+		t.Errorf("after two failures: dropped %v spans, failing %v, want 4 and true", got, failing)
+	}
+
+	export(batch())
+	<-reqs
+	if got := testutil.ToFloat64(Prometrics.ExportedSpans) - exported; got != 2 || failing {
+		t.Errorf("after recovery: exported %v spans, failing %v, want 2 and false", got, failing)
+	}
+	if got := testutil.ToFloat64(Prometrics.DroppedSpans) - dropped; got != 4 {
+		t.Errorf("dropped %v spans, want 4", got)
+	}
+}
+
+func TestExportDisabled(t *testing.T) {
+	srv, reqs, _ := newCollector(t)
+	enable(t, srv.URL)
+	s := NewTrace("INVITE", KindServer, time.Now())
+
+	ratio := 1.0
+	setConfig(Config{Endpoint: srv.URL, SampleRatio: &ratio})
+	if NewTrace("INVITE", KindServer, time.Now()) != nil {
+		t.Error("NewTrace returned a span while disabled")
+	}
+	export([]*Span{s})
+	select {
+	case <-reqs:
+		t.Error("exported while disabled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
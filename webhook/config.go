@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"siploadbalancer/events"
+)
+
+type Config struct {
+	URL         string        `json:"url"`
+	Events      []events.Type `json:"events"`      // all operational events when empty
+	Secret      string        `json:"secret"`      // signs the body with HMAC-SHA256 when set
+	MaxAttempts int           `json:"maxAttempts"` // deliveries tried per event, 1 means no retry
+	Timeout     int           `json:"timeout"`     // seconds per delivery attempt
+}
+
+const (
+	DefaultMaxAttempts = 4
+	DefaultTimeout     = 5 * time.Second
+	InitialBackoff     = time.Second
+	MaxBackoff         = time.Minute
+)
+
+// defaultEvents are the events worth alerting on, call events are too frequent
+var defaultEvents = []events.Type{
+	events.NodeUp, events.NodeDown, events.NodesDown,
+	events.LimiterSaturated, events.LimiterRecovered,
+	events.ConfigReloaded, events.ConfigRejected,
+}
+
+func parseConfig(data []byte) ([]Config, error) {
+	var in struct {
+		Webhooks []Config `json:"webhooks"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+	for i := range in.Webhooks {
+		wh := &in.Webhooks[i]
+		wh.setDefaults()
+		if err := wh.validate(); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %w", i, err)
+		}
+	}
+	return in.Webhooks, nil
+}
+
+func (wh *Config) setDefaults() {
+	if len(wh.Events) == 0 {
+		wh.Events = defaultEvents
+	}
+	if wh.MaxAttempts == 0 {
+		wh.MaxAttempts = DefaultMaxAttempts
+	}
+	if wh.Timeout == 0 {
+		wh.Timeout = int(DefaultTimeout / time.Second)
+	}
+}
+
+func (wh *Config) validate() error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url [%s] is invalid", wh.URL)
+	}
+	for _, t := range wh.Events {
+		if !t.IsValid() {
+			return fmt.Errorf("event [%s] is unknown", t)
+		}
+	}
+	if wh.MaxAttempts < 0 {
+		return fmt.Errorf("maxAttempts [%d] is invalid", wh.MaxAttempts)
+	}
+	if wh.Timeout < 0 {
+		return fmt.Errorf("timeout [%d] is invalid", wh.Timeout)
+	}
+	return nil
+}
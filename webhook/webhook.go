@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"siploadbalancer/events"
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+const (
+	EventHeader     = "X-SLB-Event"
+	DeliveryHeader  = "X-SLB-Delivery"
+	SignatureHeader = "X-SLB-Signature" // sha256=<hex HMAC-SHA256 of the body>
+)
+
+type hook struct {
+	cfg    Config
+	sub    *events.Subscription
+	client *http.Client
+	done   chan struct{}
+}
+
+var (
+	logger = logging.For("webhook")
+
+	hooks   []*hook
+	hooksMu sync.Mutex
+)
+
+// Start delivers events to the webhooks configured in data
+func Start(data []byte) {
+	cfgs, err := parseConfig(data)
+	if err != nil {
+		logging.Fatal(logger, "Invalid webhooks configuration", logging.Err(err))
+	}
+	setHooks(cfgs)
+	RegisterReloader("webhooks", reloadConfig)
+}
+
+func reloadConfig(data []byte) (func(), error) {
+	cfgs, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return func() { setHooks(cfgs) }, nil
+}
+
+// setHooks replaces the running webhooks, pending deliveries of the old ones are abandoned
+func setHooks(cfgs []Config) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	for _, h := range hooks {
+		h.stop()
+	}
+
+	hooks = make([]*hook, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		h := &hook{
+			cfg:    cfg,
+			sub:    events.Subscribe(events.DefaultBuffer, cfg.Events...),
+			client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+			done:   make(chan struct{}),
+		}
+		hooks = append(hooks, h)
+		go h.run()
+	}
+
+	if len(cfgs) > 0 {
+		logger.Info("Webhooks set", "count", len(cfgs))
+	}
+}
+
+func (h *hook) stop() {
+	close(h.done)
+	h.sub.Close()
+}
+
+func (h *hook) run() {
+	for ev := range h.sub.C {
+		h.deliver(ev)
+	}
+}
+
+// deliver posts ev, retrying with exponential backoff on network errors,
+// 429 and 5xx responses
+func (h *hook) deliver(ev events.Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error("Failed to encode event", "event", ev.Type, logging.Err(err))
+		return
+	}
+
+	backoff := InitialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(ev, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= h.cfg.MaxAttempts {
+			logger.Warn("Webhook failed to deliver event", "url", h.cfg.URL, "eventId", ev.ID, "event", ev.Type, "attempts", attempt, logging.Err(err))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-h.done:
+			return
+		}
+		backoff = min(2*backoff, MaxBackoff)
+	}
+}
+
+func (h *hook) post(ev events.Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", BUE)
+	req.Header.Set(EventHeader, string(ev.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(ev.ID, 10))
+	if h.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(h.cfg.Secret, body))
+	}
+
+	rsp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	rsp.Body.Close()
+
+	switch {
+	case rsp.StatusCode < 300:
+		return false, nil
+	case rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500:
+		return true, fmt.Errorf("%s", rsp.Status)
+	default:
+		return false, fmt.Errorf("%s", rsp.Status)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of body, receivers compare it with the signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"siploadbalancer/events"
+)
+
+type delivery struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// newReceiver answers deliveries with the given status codes in turn, then 200
+func newReceiver(t *testing.T, codes ...int) (*httptest.Server, <-chan delivery) {
+	ch := make(chan delivery, 16)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ch <- delivery{header: r.Header, body: body, at: time.Now()}
+
+		mu.Lock()
+		code := http.StatusOK
+		if len(codes) > 0 {
+			code, codes = codes[0], codes[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+func receive(t *testing.T, ch <-chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-ch:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery")
+		return delivery{}
+	}
+}
+
+func expectNone(t *testing.T, ch <-chan delivery, wait time.Duration) {
+	t.Helper()
+	select {
+	case d := <-ch:
+		t.Errorf("unexpected delivery of %s", d.header.Get(EventHeader))
+	case <-time.After(wait):
+	}
+}
+
+func newHook(url string, maxAttempts int) *hook {
+	return &hook{
+		cfg:    Config{URL: url, MaxAttempts: maxAttempts},
+		client: &http.Client{Timeout: time.Second},
+		done:   make(chan struct{}),
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	srv, ch := newReceiver(t)
+	setHooks([]Config{{URL: srv.URL, Events: []events.Type{events.NodeDown}, Secret: "s3cret", MaxAttempts: 1, Timeout: 1}})
+	t.Cleanup(func() { setHooks(nil) })
+
+	events.Publish(events.NodeDown, map[string]string{"server": "core-1"})
+	d := receive(t, ch)
+
+	var ev events.Event
+	if err := json.Unmarshal(d.body, &ev); err != nil {
+		t.Fatalf("body %q: %v", d.body, err)
+	}
+	if ev.Type != events.NodeDown || d.header.Get(EventHeader) != string(events.NodeDown) {
+		t.Errorf("event %q, header %q, want %q", ev.Type, d.header.Get(EventHeader), events.NodeDown)
+	}
+	if got := d.header.Get(DeliveryHeader); got != strconv.FormatUint(ev.ID, 10) {
+		t.Errorf("%s = %q, want %d", DeliveryHeader, got, ev.ID)
+	}
+	if got := d.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(d.body)
+	if got, want := d.header.Get(SignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+}
+
+func TestDeliverUnsigned(t *testing.T) {
+	srv, ch := newReceiver(t)
+	newHook(srv.URL, 1).deliver(events.Event{ID: 1, Type: events.NodeUp})
+	if got := receive(t, ch).header.Get(SignatureHeader); got != "" {
+		t.Errorf("%s = %q without a secret", SignatureHeader, got)
+	}
+}
+
+func TestDeliverFiltersEvents(t *testing.T) {
+	srv, ch := newReceiver(t)
+	setHooks([]Config{{URL: srv.URL, Events: []events.Type{events.NodeDown}, MaxAttempts: 1, Timeout: 1}})
+	t.Cleanup(func() { setHooks(nil) })
+
+	events.Publish(events.NodeUp, nil)
+	events.Publish(events.CallStart, nil)
+	events.Publish(events.NodeDown, nil)
+
+	if got := receive(t, ch).header.Get(EventHeader); got != string(events.NodeDown) {
+		t.Errorf("delivered %q, want only %q", got, events.NodeDown)
+	}
+	expectNone(t, ch, 200*time.Millisecond)
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	srv, ch := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	newHook(srv.URL, 3).deliver(events.Event{ID: 1, Type: events.NodeDown})
+
+	first, second, third := receive(t, ch), receive(t, ch), receive(t, ch)
+	if gap := second.at.Sub(first.at); gap < InitialBackoff {
+		t.Errorf("first retry after %v, want at least %v", gap, InitialBackoff)
+	}
+	if gap := third.at.Sub(second.at); gap < 2*InitialBackoff {
+		t.Errorf("second retry after %v, want at least %v", gap, 2*InitialBackoff)
+	}
+	if string(first.body) != string(third.body) || first.header.Get(DeliveryHeader) != third.header.Get(DeliveryHeader) {
+		t.Error("retries do not resend the same delivery")
+	}
+	expectNone(t, ch, 100*time.Millisecond)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	srv, ch := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	newHook(srv.URL, 2).deliver(events.Event{ID: 1, Type: events.NodeDown})
+	receive(t, ch)
+	receive(t, ch)
+	expectNone(t, ch, 100*time.Millisecond)
+}
+
+func TestDeliverNoRetryOn4xx(t *testing.T) {
+	srv, ch := newReceiver(t, http.StatusBadRequest)
+	newHook(srv.URL, 3).deliver(events.Event{ID: 1, Type: events.NodeDown})
+	receive(t, ch)
+	expectNone(t, ch, 100*time.Millisecond)
+}
+
+func TestDeliverStopsOnReload(t *testing.T) {
+	srv, ch := newReceiver(t, http.StatusServiceUnavailable)
+	h := newHook(srv.URL, 3)
+	done := make(chan struct{})
+	go func() {
+		h.deliver(events.Event{ID: 1, Type: events.NodeDown})
+		close(done)
+	}()
+	receive(t, ch)
+	close(h.done)
+	select {
+	case <-done:
+	case <-time.After(InitialBackoff / 2):
+		t.Fatal("delivery still waiting for its retry after the hook stopped")
+	}
+	expectNone(t, ch, 100*time.Millisecond)
+}
@@ -0,0 +1,112 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"siploadbalancer/sip"
+)
+
+func serveServers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sip.LoadBalancer.Nodes())
+}
+
+// nodeInfo returns the copy of sn to marshal, nil when there is none
+func nodeInfo(sn *sip.SipNode) any {
+	if sn == nil {
+		return nil
+	}
+	return sn.Info()
+}
+
+func addServer(w http.ResponseWriter, r *http.Request) {
+	var srvr sip.ServerData
+	if err := json.NewDecoder(r.Body).Decode(&srvr); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sn, err := sip.LoadBalancer.AddServer(srvr, isPersist(r))
+	if err != nil && !sip.IsNotPersisted(err) {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResult(w, http.StatusCreated, nodeInfo(sn), err)
+}
+
+func updateServer(w http.ResponseWriter, r *http.Request) {
+	var srvr sip.ServerData
+	if err := json.NewDecoder(r.Body).Decode(&srvr); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sn, err := sip.LoadBalancer.UpdateServer(r.PathValue("id"), srvr, isPersist(r))
+	if err != nil && !sip.IsNotPersisted(err) {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResult(w, http.StatusOK, nodeInfo(sn), err)
+}
+
+func removeServer(w http.ResponseWriter, r *http.Request) {
+	err := sip.LoadBalancer.RemoveServer(r.PathValue("id"), isPersist(r))
+	if err != nil && !sip.IsNotPersisted(err) {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResult(w, http.StatusOK, nil, err)
+}
+
+func setServerState(state sip.NodeState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sn, err := sip.LoadBalancer.SetServerState(r.PathValue("id"), state)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sn.Info())
+	}
+}
+
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	var st sip.Settings
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := sip.LoadBalancer.UpdateSettings(st, isPersist(r))
+	if err != nil && !sip.IsNotPersisted(err) {
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResult(w, http.StatusOK, sip.LoadBalancer.Config(), err)
+}
+
+func isPersist(r *http.Request) bool {
+	return r.URL.Query().Get("persist") == "true"
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sip.ErrSipNodeNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// writeAdminResult reports a change that was applied, noting if it could not
+// be written back to the configuration file
+func writeAdminResult(w http.ResponseWriter, status int, v any, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, status, v)
+}
@@ -0,0 +1,120 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/sip"
+)
+
+func TestRouteRoles(t *testing.T) {
+	withAPIKeys(t)
+
+	routes := []struct {
+		method, target string
+		required       Role
+	}{
+		{http.MethodGet, "/api/v1/stats", RoleReadOnly},
+		{http.MethodGet, "/api/v1/config", RoleReadOnly},
+		{http.MethodGet, "/api/v1/servers", RoleReadOnly},
+		{http.MethodGet, "/metrics", RoleReadOnly},
+		{http.MethodGet, "/api/v1/calls", RoleOperator},
+		{http.MethodGet, "/api/v1/cache", RoleOperator},
+		{http.MethodDelete, "/api/v1/calls/unknown", RoleOperator},
+		{http.MethodPost, "/api/v1/servers/unknown/disable", RoleOperator},
+		{http.MethodGet, "/api/v1/debug/filters", RoleOperator},
+		{http.MethodPost, "/api/v1/servers", RoleAdmin},
+		{http.MethodPut, "/api/v1/servers/unknown", RoleAdmin},
+		{http.MethodDelete, "/api/v1/servers/unknown", RoleAdmin},
+		{http.MethodPatch, "/api/v1/settings", RoleAdmin},
+	}
+	for _, rt := range routes {
+		for _, role := range []Role{RoleNone, RoleReadOnly, RoleOperator, RoleAdmin} {
+			w := serve(t, rt.method, rt.target, role, "")
+			switch {
+			case role == RoleNone && w.Code != http.StatusUnauthorized:
+				t.Errorf("%s %s without credentials: %d, want 401", rt.method, rt.target, w.Code)
+			case role != RoleNone && !role.Allows(rt.required) && w.Code != http.StatusForbidden:
+				t.Errorf("%s %s as %s: %d, want 403", rt.method, rt.target, role, w.Code)
+			case role.Allows(rt.required) && (w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden):
+				t.Errorf("%s %s as %s: %d, want it allowed", rt.method, rt.target, role, w.Code)
+			}
+		}
+	}
+
+	// probes do not authenticate
+	for _, target := range []string{"/healthz", "/readyz"} {
+		if w := serve(t, http.MethodGet, target, RoleNone, ""); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("GET %s without credentials: %d", target, w.Code)
+		}
+	}
+}
+
+func TestServersRoundTrip(t *testing.T) {
+	withAPIKeys(t)
+	core, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.Close()
+	port := core.LocalAddr().(*net.UDPAddr).Port
+
+	w := serve(t, http.MethodPost, "/api/v1/servers", RoleAdmin, fmt.Sprintf(`{"ipv4": "127.0.0.1", "port": %d, "description": "core-a", "weight": 1}`, port))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	var added sip.NodeInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &added); err != nil || added.Key == "" || added.Description != "core-a" {
+		t.Fatalf("POST returned %s (%v)", w.Body, err)
+	}
+	t.Cleanup(func() { sip.LoadBalancer.RemoveServer(added.Key, false) })
+
+	if w := serve(t, http.MethodPost, "/api/v1/servers", RoleAdmin, fmt.Sprintf(`{"ipv4": "127.0.0.1", "port": %d, "description": "core-b"}`, port)); w.Code != http.StatusBadRequest {
+		t.Errorf("POST of a duplicate address: %d, want 400", w.Code)
+	}
+
+	w = serve(t, http.MethodPut, "/api/v1/servers/"+added.Key, RoleAdmin, `{"description": "core-a2", "weight": 4}`)
+	var updated sip.NodeInfo
+	if json.Unmarshal(w.Body.Bytes(), &updated); w.Code != http.StatusOK || updated.Key != added.Key || updated.Description != "core-a2" || updated.Weight != 4 {
+		t.Errorf("PUT: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, http.MethodPut, "/api/v1/servers/core-a2", RoleAdmin, `{"ipv4": "127.0.0.1", "port": 1, "description": "core-a2"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT changing the address: %d, want 400", w.Code)
+	}
+
+	w = serve(t, http.MethodGet, "/api/v1/servers", RoleReadOnly, "")
+	var nodes []sip.NodeInfo
+	if json.Unmarshal(w.Body.Bytes(), &nodes); len(nodes) != 1 || nodes[0].Description != "core-a2" {
+		t.Errorf("GET: %s", w.Body)
+	}
+
+	if w := serve(t, http.MethodDelete, "/api/v1/servers/"+added.Key, RoleAdmin, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, http.MethodDelete, "/api/v1/servers/"+added.Key, RoleAdmin, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: %d, want 404", w.Code)
+	}
+}
+
+func TestAdminNotPersisted(t *testing.T) {
+	withAPIKeys(t)
+	prev := ConfigPath
+	ConfigPath = ""
+	t.Cleanup(func() { ConfigPath = prev })
+
+	w := serve(t, http.MethodPatch, "/api/v1/settings?persist=true", RoleAdmin, `{"hitWindow": 120}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("PATCH without a configuration file: %d, want 500", w.Code)
+	}
+	if got := sip.LoadBalancer.GetHitWindow(); got != 120 {
+		t.Errorf("hitWindow = %d, want the change applied anyway", got)
+	}
+
+	if w := serve(t, http.MethodPatch, "/api/v1/settings", RoleAdmin, `{"hitWindow": 0, "probingInterval": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with an invalid probingInterval: %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,189 @@
+package webserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleNone     Role = ""
+	RoleReadOnly Role = "readonly" // monitoring: stats, config, servers, metrics
+	RoleOperator Role = "operator" // readonly + call data and enable/disable/drain of servers
+	RoleAdmin    Role = "admin"    // operator + server CRUD and runtime settings
+
+	hashPrefix = "sha256:"
+)
+
+type (
+	authConfig struct {
+		Users         []userCredential   `json:"users"`
+		APIKeys       []apiKeyCredential `json:"apiKeys"`
+		AnonymousRole *Role              `json:"anonymousRole"`
+	}
+
+	userCredential struct {
+		Username string `json:"username"`
+		Password string `json:"password"` // plain text or "sha256:<hex digest>"
+		Role     Role   `json:"role"`
+	}
+
+	apiKeyCredential struct {
+		Name string `json:"name"`
+		Key  string `json:"key"` // plain text or "sha256:<hex digest>"
+		Role Role   `json:"role"`
+	}
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleReadOnly:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (r Role) Allows(required Role) bool {
+	return r.level() >= required.level()
+}
+
+func (ac *authConfig) validate() error {
+	for i, u := range ac.Users {
+		if u.Username == "" || u.Password == "" {
+			return fmt.Errorf("auth.users[%d]: username and password are required", i)
+		}
+		if u.Role.level() == 0 {
+			return fmt.Errorf("auth.users[%d]: role [%s] is unknown", i, u.Role)
+		}
+	}
+	for i, k := range ac.APIKeys {
+		if k.Key == "" {
+			return fmt.Errorf("auth.apiKeys[%d]: key is required", i)
+		}
+		if k.Role.level() == 0 {
+			return fmt.Errorf("auth.apiKeys[%d]: role [%s] is unknown", i, k.Role)
+		}
+	}
+	if ac.AnonymousRole != nil && *ac.AnonymousRole != RoleNone && ac.AnonymousRole.level() == 0 {
+		return fmt.Errorf("auth.anonymousRole [%s] is unknown", *ac.AnonymousRole)
+	}
+	return nil
+}
+
+// anonymousRole is readonly when no credentials are configured, so that
+// monitoring keeps working out of the box, and none otherwise
+func (ac *authConfig) anonymousRole() Role {
+	if ac.AnonymousRole != nil {
+		return *ac.AnonymousRole
+	}
+	if len(ac.Users) == 0 && len(ac.APIKeys) == 0 {
+		return RoleReadOnly
+	}
+	return RoleNone
+}
+
+// authenticate returns the role granted to the request credentials, ok is
+// false when credentials were presented but did not match.
+func (ac *authConfig) authenticate(r *http.Request) (role Role, ok bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return ac.matchAPIKey(key)
+	}
+
+	authz := r.Header.Get("Authorization")
+	if key, found := strings.CutPrefix(authz, "Bearer "); found {
+		return ac.matchAPIKey(key)
+	}
+	if username, password, found := r.BasicAuth(); found {
+		return ac.matchUser(username, password)
+	}
+	if authz != "" {
+		return RoleNone, false
+	}
+
+	return ac.anonymousRole(), true
+}
+
+// matchAPIKey and matchUser check every credential so that timing does not
+// reveal which one, if any, matched
+func (ac *authConfig) matchAPIKey(key string) (Role, bool) {
+	role := RoleNone
+	for _, k := range ac.APIKeys {
+		if secretEqual(key, k.Key) {
+			role = k.Role
+		}
+	}
+	return role, role != RoleNone
+}
+
+func (ac *authConfig) matchUser(username, password string) (Role, bool) {
+	role := RoleNone
+	for _, u := range ac.Users {
+		userOk := subtle.ConstantTimeCompare(digest(username), digest(u.Username))
+		passOk := boolToInt(secretEqual(password, u.Password))
+		if userOk&passOk == 1 {
+			role = u.Role
+		}
+	}
+	return role, role != RoleNone
+}
+
+func secretEqual(given, configured string) bool {
+	if hexdigest, ok := strings.CutPrefix(configured, hashPrefix); ok {
+		want, err := hex.DecodeString(hexdigest)
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare(digest(given), want) == 1
+	}
+	return subtle.ConstantTimeCompare(digest(given), digest(configured)) == 1
+}
+
+func digest(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// authorize guards h so that only requests granted at least the required role reach it
+func authorize(required Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ac := getConfig().Auth
+		role, ok := ac.authenticate(r)
+		if !ok || role == RoleNone {
+			w.Header().Set("WWW-Authenticate", `Basic realm="SipLoadBalancer", charset="UTF-8"`)
+			writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+			return
+		}
+		if !role.Allows(required) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("role [%s] is not allowed, [%s] required", role, required))
+			return
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+	}
+}
+
+type roleKey struct{}
+
+// requestRole returns the role authorize granted to r
+func requestRole(r *http.Request) Role {
+	role, _ := r.Context().Value(roleKey{}).(Role)
+	return role
+}
@@ -0,0 +1,147 @@
+package webserver
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	return hashPrefix + hex.EncodeToString(digest(s))
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role, required Role
+		want           bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleReadOnly, true},
+		{RoleOperator, RoleReadOnly, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleReadOnly, RoleOperator, false},
+		{RoleNone, RoleReadOnly, false},
+		{Role("root"), RoleReadOnly, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestSecretEqual(t *testing.T) {
+	tests := []struct {
+		given, configured string
+		want              bool
+	}{
+		{"s3cret", "s3cret", true},
+		{"s3cret", "s3cre", false},
+		{"", "s3cret", false},
+		{"s3cret", sha256Hex("s3cret"), true},
+		{"other", sha256Hex("s3cret"), false},
+		{"s3cret", hashPrefix + "not-hex", false},
+	}
+	for _, tt := range tests {
+		if got := secretEqual(tt.given, tt.configured); got != tt.want {
+			t.Errorf("secretEqual(%q, %q) = %v, want %v", tt.given, tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ac := authConfig{
+		Users: []userCredential{
+			{Username: "ops", Password: sha256Hex("pw"), Role: RoleOperator},
+		},
+		APIKeys: []apiKeyCredential{
+			{Name: "grafana", Key: "k-ro", Role: RoleReadOnly},
+			{Name: "deploy", Key: "k-admin", Role: RoleAdmin},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		header   func(r *http.Request)
+		wantRole Role
+		wantOk   bool
+	}{
+		{"anonymous", func(r *http.Request) {}, RoleNone, true},
+		{"api key header", func(r *http.Request) { r.Header.Set("X-API-Key", "k-admin") }, RoleAdmin, true},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer k-ro") }, RoleReadOnly, true},
+		{"wrong key", func(r *http.Request) { r.Header.Set("X-API-Key", "k-nope") }, RoleNone, false},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("ops", "pw") }, RoleOperator, true},
+		{"basic wrong password", func(r *http.Request) { r.SetBasicAuth("ops", "nope") }, RoleNone, false},
+		{"basic unknown user", func(r *http.Request) { r.SetBasicAuth("root", "pw") }, RoleNone, false},
+		{"unknown scheme", func(r *http.Request) { r.Header.Set("Authorization", "Digest x") }, RoleNone, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil)
+		tt.header(r)
+		role, ok := ac.authenticate(r)
+		if role != tt.wantRole || ok != tt.wantOk {
+			t.Errorf("%s: authenticate = (%q, %v), want (%q, %v)", tt.name, role, ok, tt.wantRole, tt.wantOk)
+		}
+	}
+}
+
+func TestAnonymousRole(t *testing.T) {
+	var ac authConfig
+	if got := ac.anonymousRole(); got != RoleReadOnly {
+		t.Errorf("without credentials: anonymousRole = %q, want %q", got, RoleReadOnly)
+	}
+
+	ac.APIKeys = []apiKeyCredential{{Key: "k", Role: RoleAdmin}}
+	if got := ac.anonymousRole(); got != RoleNone {
+		t.Errorf("with credentials: anonymousRole = %q, want none", got)
+	}
+
+	admin := RoleAdmin
+	ac.AnonymousRole = &admin
+	if got := ac.anonymousRole(); got != RoleAdmin {
+		t.Errorf("explicit: anonymousRole = %q, want %q", got, RoleAdmin)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	prev := getConfig()
+	t.Cleanup(func() { setConfig(prev) })
+	setConfig(apiConfig{Auth: authConfig{
+		APIKeys: []apiKeyCredential{
+			{Key: "k-ro", Role: RoleReadOnly},
+			{Key: "k-admin", Role: RoleAdmin},
+		},
+	}})
+
+	h := authorize(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if got := requestRole(r); got != RoleAdmin {
+			t.Errorf("requestRole = %q, want %q", got, RoleAdmin)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"k-nope", http.StatusUnauthorized},
+		{"k-ro", http.StatusForbidden},
+		{"k-admin", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/settings", nil)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("key %q: status %d, want %d", tt.key, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("key %q: no WWW-Authenticate challenge", tt.key)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"siploadbalancer/sip"
+)
+
+func serveCalls(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := sip.CallFilter{
+		Status: sip.Status(q.Get("status")),
+		Node:   q.Get("node"),
+		Cursor: q.Get("cursor"),
+	}
+
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("from must be an RFC 3339 timestamp"))
+			return
+		}
+		f.From = t
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+		f.Limit = n
+	}
+
+	page, err := sip.LoadBalancer.Calls(f)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func serveCall(w http.ResponseWriter, r *http.Request) {
+	ci, ok := sip.LoadBalancer.GetCall(r.PathValue("callID"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("call not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, ci)
+}
+
+func terminateCall(w http.ResponseWriter, r *http.Request) {
+	err := sip.LoadBalancer.TerminateCall(r.PathValue("callID"))
+	switch {
+	case errors.Is(err, sip.ErrCallNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, sip.ErrCallNotActive):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		ci, _ := sip.LoadBalancer.GetCall(r.PathValue("callID"))
+		writeJSON(w, http.StatusOK, ci)
+	}
+}
@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"siploadbalancer/capture"
+)
+
+func serveCaptures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, capture.List())
+}
+
+func serveCapture(w http.ResponseWriter, r *http.Request) {
+	info, ok := capture.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, capture.ErrNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func startCapture(w http.ResponseWriter, r *http.Request) {
+	var rqst capture.Request
+	if err := json.NewDecoder(r.Body).Decode(&rqst); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	info, err := capture.Begin(rqst)
+	switch {
+	case errors.Is(err, capture.ErrDisabled), errors.Is(err, capture.ErrTooMany):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusCreated, info)
+	}
+}
+
+func stopCapture(w http.ResponseWriter, r *http.Request) {
+	info, err := capture.End(r.PathValue("id"))
+	switch {
+	case errors.Is(err, capture.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, capture.ErrNotRunning):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+// downloadCapture serves the current file of a capture, rotated files stay in
+// the capture directory
+func downloadCapture(w http.ResponseWriter, r *http.Request) {
+	info, ok := capture.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, capture.ErrNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(info.Path)))
+	http.ServeFile(w, r, info.Path)
+}
@@ -0,0 +1,95 @@
+package webserver
+
+import (
+	"encoding/json"
+	"sync"
+
+	. "siploadbalancer/global"
+)
+
+type apiConfig struct {
+	Auth          authConfig `json:"auth"`
+	TLS           tlsConfig  `json:"tls"`
+	LocalhostOnly bool       `json:"localhostOnly"` // without TLS, serve on 127.0.0.1 rather than ipv4
+}
+
+var (
+	config   apiConfig
+	configMu sync.RWMutex
+)
+
+func parseConfig(data []byte) (apiConfig, error) {
+	var in struct {
+		API apiConfig `json:"api"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in.API, err
+	}
+	if err := in.API.Auth.validate(); err != nil {
+		return in.API, err
+	}
+	return in.API, in.API.TLS.validate()
+}
+
+func loadConfig(data []byte) error {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	if cfg.TLS.enabled() {
+		cert, pool, err := loadCertificates(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		certs.set(cfg.TLS, cert, pool)
+	}
+	setConfig(cfg)
+	RegisterReloader("api", reloadConfig)
+	return nil
+}
+
+// reloadConfig applies auth changes and new certificate paths. Switching
+// TLS on or off, or changing plainHttpPort or localhostOnly, requires a restart.
+func reloadConfig(data []byte) (func(), error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	running := getConfig().TLS
+	if running.enabled() != cfg.TLS.enabled() || running.PlainHttpPort != cfg.TLS.PlainHttpPort {
+		logger.Warn("Changes enabling/disabling TLS or to tls.plainHttpPort require a restart - Ignored")
+	}
+	if localhostOnly := getConfig().LocalhostOnly; localhostOnly != cfg.LocalhostOnly {
+		logger.Warn("Changes to api.localhostOnly require a restart - Ignored")
+		cfg.LocalhostOnly = localhostOnly
+	}
+	if !running.enabled() || !cfg.TLS.enabled() {
+		cfg.TLS = running
+		return func() { setConfig(cfg) }, nil
+	}
+
+	cert, pool, err := loadCertificates(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLS.PlainHttpPort = running.PlainHttpPort
+	return func() {
+		certs.set(cfg.TLS, cert, pool)
+		setConfig(cfg)
+	}, nil
+}
+
+func setConfig(cfg apiConfig) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	config = cfg
+}
+
+func getConfig() apiConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return config
+}
@@ -0,0 +1,32 @@
+package webserver
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+var dashboardTmpl = template.Must(template.ParseFS(dashboardFS, "dashboard/index.html"))
+
+// serveDashboard serves the single-page dashboard, which gets its data from the API
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := dashboardTmpl.Execute(&buf, BUE); err != nil {
+		logger.Error("Failed to render dashboard", logging.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logger.Debug("Failed to write response", logging.Err(err))
+	}
+}
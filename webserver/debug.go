@@ -0,0 +1,43 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"siploadbalancer/sip"
+)
+
+func serveDebugFilters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sip.LoadBalancer.DebugFilters())
+}
+
+func addDebugFilter(w http.ResponseWriter, r *http.Request) {
+	var df sip.DebugFilter
+	if err := json.NewDecoder(r.Body).Decode(&df); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	df, err := sip.LoadBalancer.AddDebugFilter(df)
+	switch {
+	case errors.Is(err, sip.ErrTooManyDebugFilters):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusCreated, df)
+	}
+}
+
+func removeDebugFilter(w http.ResponseWriter, r *http.Request) {
+	if err := sip.LoadBalancer.RemoveDebugFilter(r.PathValue("id")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveDebugTraces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sip.LoadBalancer.DebugTraces(r.URL.Query().Get("filter")))
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"siploadbalancer/events"
+	"siploadbalancer/logging"
+)
+
+const keepAliveInterval = 15 * time.Second
+
+// serveEvents streams events as Server-Sent Events. Call events carry call
+// details, so they are only sent to operators and admins.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	var types []events.Type
+	if qs := r.URL.Query().Get("types"); qs != "" {
+		for t := range strings.SplitSeq(qs, ",") {
+			typ := events.Type(strings.TrimSpace(t))
+			if !typ.IsValid() {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("event type [%s] is unknown, valid types are %s", typ, validTypes()))
+				return
+			}
+			types = append(types, typ)
+		}
+	}
+	withCalls := requestRole(r).Allows(RoleOperator)
+
+	sub := events.Subscribe(events.DefaultBuffer, types...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	var dropped uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !withCalls && strings.HasPrefix(string(ev.Type), "call.") {
+				continue
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		}
+
+		if d := sub.Dropped(); d != dropped {
+			fmt.Fprintf(w, ": %d events dropped\n\n", d-dropped)
+			dropped = d
+		}
+		flusher.Flush()
+	}
+}
+
+func validTypes() string {
+	names := make([]string, len(events.Types))
+	for i, t := range events.Types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+func writeEvent(w http.ResponseWriter, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error("Failed to encode event", "event", ev.Type, logging.Err(err))
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
@@ -0,0 +1,19 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeEventsUnknownType(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveEvents(w, httptest.NewRequest(http.MethodGet, "/api/v1/events?types=node.up,node.sideways", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "node.sideways") || !strings.Contains(body, "config.rejected") {
+		t.Errorf("body %q does not name the unknown type and the valid ones", body)
+	}
+}
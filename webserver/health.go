@@ -0,0 +1,20 @@
+package webserver
+
+import (
+	"net/http"
+
+	"siploadbalancer/sip"
+)
+
+// serveHealth answers 200 when every check passes, 503 otherwise. Probes do
+// not authenticate, so these endpoints are left open.
+func serveHealth(report func() sip.HealthReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hr := report()
+		status := http.StatusOK
+		if !hr.OK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, hr)
+	}
+}
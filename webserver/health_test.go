@@ -0,0 +1,103 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"siploadbalancer/sip"
+)
+
+// answerOptions replies 200 OK to every request sent to conn, as a healthy SIP server does to probes
+func answerOptions(conn *net.UDPConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var rsp strings.Builder
+		rsp.WriteString("SIP/2.0 200 OK\r\n")
+		for _, line := range strings.Split(string(buf[:n]), "\r\n")[1:] {
+			name, _, _ := strings.Cut(line, ":")
+			switch strings.ToLower(name) {
+			case "via", "from", "call-id", "cseq":
+				rsp.WriteString(line + "\r\n")
+			case "to":
+				rsp.WriteString(line + ";tag=core\r\n")
+			}
+		}
+		rsp.WriteString("Content-Length: 0\r\n\r\n")
+		conn.WriteToUDP([]byte(rsp.String()), addr)
+	}
+}
+
+// addTestServer adds a SIP server through the API until the end of the test
+func addTestServer(t *testing.T, description string, conn *net.UDPConn) string {
+	t.Helper()
+	body := fmt.Sprintf(`{"ipv4": "127.0.0.1", "port": %d, "description": %q, "weight": 1}`, conn.LocalAddr().(*net.UDPAddr).Port, description)
+	w := serve(t, http.MethodPost, "/api/v1/servers", RoleAdmin, body)
+	var added sip.NodeInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &added); w.Code != http.StatusCreated || err != nil {
+		t.Fatalf("POST %s: %d %s", description, w.Code, w.Body)
+	}
+	t.Cleanup(func() { sip.LoadBalancer.RemoveServer(added.Key, false) })
+	return added.Key
+}
+
+func listenCore(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReadinessProbes(t *testing.T) {
+	withAPIKeys(t)
+	t.Cleanup(func() { serve(t, http.MethodPatch, "/api/v1/settings", RoleAdmin, `{"minHealthyNodes": 1}`) })
+
+	expect := func(target string, want int) {
+		t.Helper()
+		if w := serve(t, http.MethodGet, target, RoleNone, ""); w.Code != want {
+			t.Errorf("GET %s: %d, want %d: %s", target, w.Code, want, w.Body)
+		}
+	}
+
+	expect("/healthz", http.StatusOK)
+	expect("/readyz", http.StatusServiceUnavailable) // no server
+
+	healthy := listenCore(t)
+	go answerOptions(healthy)
+	key := addTestServer(t, "core-a", healthy)
+	addTestServer(t, "core-b", listenCore(t)) // never answers its probes, stays dead
+
+	// the probe sent when the server is added brings it up
+	deadline := time.Now().Add(2 * time.Second)
+	for !sip.LoadBalancer.FindSipNode(key).IsAvailable() {
+		if time.Now().After(deadline) {
+			t.Fatal("core-a not up after its probe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect("/readyz", http.StatusOK)
+
+	if w := serve(t, http.MethodPatch, "/api/v1/settings", RoleAdmin, `{"minHealthyNodes": 2}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH minHealthyNodes: %d %s", w.Code, w.Body)
+	}
+	expect("/readyz", http.StatusServiceUnavailable) // core-b is dead
+	serve(t, http.MethodPatch, "/api/v1/settings", RoleAdmin, `{"minHealthyNodes": 1}`)
+	expect("/readyz", http.StatusOK)
+
+	serve(t, http.MethodPost, "/api/v1/servers/"+key+"/disable", RoleOperator, "")
+	expect("/readyz", http.StatusServiceUnavailable) // core-a is alive but disabled
+	serve(t, http.MethodPost, "/api/v1/servers/"+key+"/enable", RoleOperator, "")
+	expect("/readyz", http.StatusOK)
+
+	expect("/healthz", http.StatusOK)
+}
@@ -0,0 +1,51 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"siploadbalancer/history"
+)
+
+// serveStatsHistory serves the values of a metric over the last hour unless
+// from and to say otherwise
+func serveStatsHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	to := time.Now()
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("to must be an RFC 3339 timestamp"))
+			return
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour)
+	if s := q.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("from must be an RFC 3339 timestamp"))
+			return
+		}
+		from = t
+	}
+	var step time.Duration
+	if s := q.Get("step"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("step must be a positive number of seconds"))
+			return
+		}
+		step = time.Duration(n) * time.Second
+	}
+
+	h, err := history.Query(history.Metric(q.Get("metric")), from, to, step)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
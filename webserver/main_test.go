@@ -0,0 +1,63 @@
+package webserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"siploadbalancer/cl"
+	. "siploadbalancer/global"
+	"siploadbalancer/prometheus"
+	"siploadbalancer/sip"
+)
+
+func TestMain(m *testing.M) {
+	Prometrics = prometheus.NewMetrics()
+	CallLimiter = cl.NewCallLimiter(-1, cl.Settings{Mode: cl.ModeWindow, RejectCode: cl.DefaultRejectCode, MaxCallsRejectCode: cl.DefaultMaxCallsRejectCode}, Prometrics, &sync.WaitGroup{})
+
+	// a free port for the SIP listener of the balancer under test, which runs
+	// its workers so that /healthz, /readyz and server probes are real
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	sip.InitializeServer(fmt.Appendf(nil, `{"ipv4": "127.0.0.1", "sipUdpPort": %d, "httpPort": 9080, "loadbalancemode": "RoundRobin", "probingInterval": 60, "servers": []}`, port))
+	sip.StartSS()
+
+	os.Exit(m.Run())
+}
+
+// withAPIKeys configures one API key per role, named after it, until the end of the test
+func withAPIKeys(t *testing.T) {
+	prev := getConfig()
+	t.Cleanup(func() { setConfig(prev) })
+	setConfig(apiConfig{Auth: authConfig{APIKeys: []apiKeyCredential{
+		{Name: "ro", Key: "k-" + string(RoleReadOnly), Role: RoleReadOnly},
+		{Name: "op", Key: "k-" + string(RoleOperator), Role: RoleOperator},
+		{Name: "admin", Key: "k-" + string(RoleAdmin), Role: RoleAdmin},
+	}}})
+}
+
+// serve sends a request through the API routes with the key of role, none for RoleNone
+func serve(t *testing.T, method, target string, role Role, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, rd)
+	if role != RoleNone {
+		r.Header.Set("X-API-Key", "k-"+string(role))
+	}
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+	return w
+}
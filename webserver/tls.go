@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	. "siploadbalancer/global"
+	"siploadbalancer/logging"
+)
+
+type (
+	tlsConfig struct {
+		CertFile          string `json:"certFile"`
+		KeyFile           string `json:"keyFile"`
+		ClientCAFile      string `json:"clientCAFile"`      // enables client certificate (mTLS) verification
+		RequireClientCert bool   `json:"requireClientCert"` // reject clients without a valid certificate
+		PlainHttpPort     int    `json:"plainHttpPort"`     // optional plain HTTP listener on localhost only
+	}
+
+	// certStore holds the certificates served by the API, reloaded whenever
+	// their files change
+	certStore struct {
+		cfg       tlsConfig
+		cert      *tls.Certificate
+		clientCAs *x509.CertPool
+		modTimes  [3]time.Time
+		mu        sync.RWMutex
+	}
+)
+
+var certs = &certStore{}
+
+func (tc *tlsConfig) enabled() bool {
+	return tc.CertFile != "" || tc.KeyFile != ""
+}
+
+func (tc *tlsConfig) validate() error {
+	if !tc.enabled() {
+		return nil
+	}
+	if tc.CertFile == "" || tc.KeyFile == "" {
+		return errors.New("tls.certFile and tls.keyFile are both required")
+	}
+	if tc.RequireClientCert && tc.ClientCAFile == "" {
+		return errors.New("tls.requireClientCert needs tls.clientCAFile")
+	}
+	if tc.PlainHttpPort < 0 || tc.PlainHttpPort > 65535 {
+		return fmt.Errorf("tls.plainHttpPort [%d] is invalid", tc.PlainHttpPort)
+	}
+	return nil
+}
+
+func (tc *tlsConfig) files() [3]string {
+	return [3]string{tc.CertFile, tc.KeyFile, tc.ClientCAFile}
+}
+
+func loadCertificates(tc tlsConfig) (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tc.ClientCAFile == "" {
+		return &cert, nil, nil
+	}
+
+	pem, err := os.ReadFile(tc.ClientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, fmt.Errorf("no certificates found in %s", tc.ClientCAFile)
+	}
+	return &cert, pool, nil
+}
+
+func (cs *certStore) set(tc tlsConfig, cert *tls.Certificate, pool *x509.CertPool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.cfg = tc
+	cs.cert = cert
+	cs.clientCAs = pool
+	for i, f := range tc.files() {
+		cs.modTimes[i] = FileModTime(f)
+	}
+}
+
+// watch reloads the certificates when one of their files changes, a broken
+// file keeps the previous certificates in use
+func (cs *certStore) watch() {
+	ticker := time.NewTicker(ConfigWatchInterval)
+
+	WtGrp.Add(1)
+	go func() {
+		defer WtGrp.Done()
+		for range ticker.C {
+			cs.mu.RLock()
+			tc := cs.cfg
+			changed := false
+			for i, f := range tc.files() {
+				changed = changed || !FileModTime(f).Equal(cs.modTimes[i])
+			}
+			cs.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+			cert, pool, err := loadCertificates(tc)
+			if err != nil {
+				logger.Error("Failed to reload TLS certificates, keeping current ones", logging.Err(err))
+				continue
+			}
+			cs.set(tc, cert, pool)
+			logger.Info("TLS certificates reloaded")
+		}
+	}()
+}
+
+func (cs *certStore) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cs.mu.RLock()
+			defer cs.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cs.cert},
+			}
+			if cs.clientCAs != nil {
+				cfg.ClientCAs = cs.clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if cs.cfg.RequireClientCert {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"siploadbalancer/logging"
+	"siploadbalancer/sip"
+)
+
+const traceTimeFormat = "15:04:05.000"
+
+func serveCallTrace(w http.ResponseWriter, r *http.Request) {
+	ct, err := sip.LoadBalancer.GetCallTrace(r.PathValue("callID"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var body, contentType string
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, ct)
+		return
+	case "text":
+		body, contentType = traceText(ct), "text/plain; charset=utf-8"
+	case "mermaid":
+		body, contentType = traceMermaid(ct), "text/plain; charset=utf-8"
+	case "svg":
+		body, contentType = traceSVG(ct), "image/svg+xml"
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("format must be one of json, text, mermaid or svg"))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err = w.Write([]byte(body)); err != nil {
+		logger.Debug("Failed to write response", logging.Err(err))
+	}
+}
+
+func traceText(ct sip.CallTrace) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Call-ID: %s\nNode: %s (%s)\nPeer: %s\n\n", ct.CallID, ct.Node, ct.NodeAddr, ct.PeerAddr)
+	for _, rec := range ct.Records {
+		arrow := "<<< received from"
+		if rec.Direction == sip.TraceOut {
+			arrow = ">>> sent to"
+		}
+		fmt.Fprintf(&sb, "%s %s %s\n%s\n", rec.Time.Format("2006-01-02T15:04:05.000000Z07:00"), arrow, rec.Peer, rec.Message)
+		if !strings.HasSuffix(rec.Message, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// ladder maps every address in the trace to a column, the balancer in the middle
+type ladder struct {
+	names  []string
+	labels []string
+	index  map[string]int
+}
+
+func newLadder(ct sip.CallTrace) *ladder {
+	ld := &ladder{index: make(map[string]int)}
+	ld.add(ct.PeerAddr, "Peer")
+	ld.add(ct.LocalAddr, "SLB")
+	ld.add(ct.NodeAddr, ct.Node)
+	for _, rec := range ct.Records {
+		ld.add(rec.Peer, "Remote")
+	}
+	return ld
+}
+
+func (ld *ladder) add(addr, label string) {
+	if _, ok := ld.index[addr]; ok || addr == "" {
+		return
+	}
+	ld.index[addr] = len(ld.names)
+	ld.names = append(ld.names, addr)
+	ld.labels = append(ld.labels, label)
+}
+
+// arrow returns the source and destination columns of rec
+func (ld *ladder) arrow(ct sip.CallTrace, rec sip.TraceRecord) (int, int) {
+	local, remote := ld.index[ct.LocalAddr], ld.index[rec.Peer]
+	if rec.Direction == sip.TraceOut {
+		return local, remote
+	}
+	return remote, local
+}
+
+func traceSummary(message string) string {
+	startLine, _, _ := strings.Cut(message, "\r\n")
+	if rest, ok := strings.CutPrefix(startLine, "SIP/2.0 "); ok {
+		return rest
+	}
+	method, _, _ := strings.Cut(startLine, " ")
+	return method
+}
+
+func traceMermaid(ct sip.CallTrace) string {
+	ld := newLadder(ct)
+
+	var sb strings.Builder
+	sb.WriteString("sequenceDiagram\n")
+	for i, addr := range ld.names {
+		fmt.Fprintf(&sb, "    participant P%d as %s %s\n", i, mermaidText(ld.labels[i]), mermaidText(addr))
+	}
+	for _, rec := range ct.Records {
+		src, dst := ld.arrow(ct, rec)
+		fmt.Fprintf(&sb, "    P%d->>P%d: %s %s\n", src, dst, rec.Time.Format(traceTimeFormat), mermaidText(traceSummary(rec.Message)))
+	}
+	return sb.String()
+}
+
+// mermaidText keeps letters, digits and the punctuation of addresses and
+// status lines, and writes any other character as a Mermaid entity code, so
+// that a description or a reason phrase cannot end the statement or start another
+func mermaidText(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(" .:_/@,()[]", r):
+			sb.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			sb.WriteByte(' ')
+		default:
+			fmt.Fprintf(&sb, "#%d;", r)
+		}
+	}
+	return sb.String()
+}
+
+func traceSVG(ct sip.CallTrace) string {
+	const (
+		colWidth  = 240
+		rowHeight = 32
+		top       = 60
+		margin    = 120
+	)
+
+	ld := newLadder(ct)
+	width := margin*2 + colWidth*max(len(ld.names)-1, 1)
+	height := top + rowHeight*(len(ct.Records)+1)
+	x := func(col int) int { return margin + col*colWidth }
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", width, height)
+	sb.WriteString(`<defs><marker id="arrow" markerWidth="10" markerHeight="8" refX="10" refY="4" orient="auto"><path d="M0,0 L10,4 L0,8 z"/></marker></defs>` + "\n")
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+
+	for i, addr := range ld.names {
+		fmt.Fprintf(&sb, `<text x="%d" y="20" text-anchor="middle" font-weight="bold">%s</text>`+"\n", x(i), html.EscapeString(ld.labels[i]))
+		fmt.Fprintf(&sb, `<text x="%d" y="36" text-anchor="middle">%s</text>`+"\n", x(i), html.EscapeString(addr))
+		fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999" stroke-dasharray="4"/>`+"\n", x(i), top-16, x(i), height)
+	}
+
+	for n, rec := range ct.Records {
+		src, dst := ld.arrow(ct, rec)
+		y := top + rowHeight*n + rowHeight/2
+		fmt.Fprintf(&sb, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black" marker-end="url(#arrow)"/>`+"\n", x(src), y, x(dst), y)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n", (x(src)+x(dst))/2, y-4, html.EscapeString(traceSummary(rec.Message)))
+		fmt.Fprintf(&sb, `<text x="4" y="%d" fill="#666">%s</text>`+"\n", y+4, rec.Time.Format(traceTimeFormat))
+	}
+
+	sb.WriteString("</svg>\n")
+	return sb.String()
+}
@@ -0,0 +1,65 @@
+package webserver
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"siploadbalancer/sip"
+)
+
+func TestMermaidText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Peer", "Peer"},
+		{"192.0.2.1:5060", "192.0.2.1:5060"},
+		{"[2001:db8::1]:5060", "[2001:db8::1]:5060"},
+		{"180 Ringing", "180 Ringing"},
+		{"core-a", "core#45;a"},
+		{`a"b;c#d`, "a#34;b#59;c#35;d"},
+		{"x-->y", "x#45;#45;#62;y"},
+		{"line\nbreak", "line break"},
+		{"café", "caf#233;"},
+	}
+	for _, tt := range tests {
+		if got := mermaidText(tt.in); got != tt.want {
+			t.Errorf("mermaidText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTraceMermaidHostileNames(t *testing.T) {
+	at := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	ct := sip.CallTrace{
+		CallID:    "a84b4c76e66710",
+		LocalAddr: "127.0.0.1:5060",
+		PeerAddr:  "192.0.2.1:5060",
+		NodeAddr:  "10.0.0.1:5060",
+		Node:      "core\"; P0->>P1: injected\nparticipant X as evil %% comment",
+		Records: []sip.TraceRecord{
+			{Time: at, Direction: sip.TraceIn, Peer: "192.0.2.1:5060", Message: "INVITE sip:100@example.com SIP/2.0\r\n\r\n"},
+			{Time: at, Direction: sip.TraceOut, Peer: "10.0.0.1:5060", Message: "INVITE sip:100@example.com SIP/2.0\r\n\r\n"},
+			{Time: at, Direction: sip.TraceIn, Peer: "10.0.0.1:5060", Message: "SIP/2.0 486 Busy; call back -->later\nnote over P0: x\r\n\r\n"},
+		},
+	}
+
+	out := traceMermaid(ct)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 1+3+3 {
+		t.Fatalf("%d lines, want a header, 3 participants and 3 messages:\n%s", len(lines), out)
+	}
+
+	text := `([A-Za-z0-9 .:_/@,()\[\]]|#\d+;)*`
+	statement := regexp.MustCompile(`^(sequenceDiagram|    participant P\d as ` + text + `|    P\d->>P\d: ` + text + `)$`)
+	for _, line := range lines {
+		if !statement.MatchString(line) {
+			t.Errorf("unsafe statement %q", line)
+		}
+	}
+	if !strings.Contains(lines[3], "core#34;#59; P0#45;#62;#62;P1: injected participant X as evil #37;#37; comment") {
+		t.Errorf("node participant = %q", lines[3])
+	}
+	if !strings.HasSuffix(lines[6], "486 Busy#59; call back #45;#45;#62;later note over P0: x") {
+		t.Errorf("response = %q", lines[6])
+	}
+}
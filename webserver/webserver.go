@@ -3,61 +3,125 @@ package webserver
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"runtime"
+	"siploadbalancer/cl"
 	. "siploadbalancer/global"
+	"siploadbalancer/logging"
 	"siploadbalancer/sip"
+	"strconv"
 )
 
-func StartWS(ip net.IP, hp int) {
-	r := http.NewServeMux()
+var logger = logging.For("api")
 
-	r.HandleFunc("GET /api/v1/stats", serveStats)
-	r.HandleFunc("GET /api/v1/config", serveConfig)
-	r.HandleFunc("GET /api/v1/cache", serveCache)
-	r.Handle("GET /metrics", Prometrics.Handler())
-	r.HandleFunc("GET /", serveHome)
+func StartWS(ip net.IP, hp int, data []byte) {
+	if err := loadConfig(data); err != nil {
+		logging.Fatal(logger, "Invalid api configuration", logging.Err(err))
+	}
 
-	ws := fmt.Sprintf("%s:%d", ip, hp)
+	r := newRouter()
+	ws := listenAddr(ip, hp, getConfig())
+
+	tc := getConfig().TLS
+	if !tc.enabled() {
+		switch {
+		case ip.IsLoopback():
+		case getConfig().LocalhostOnly:
+			logger.Warn("API served on localhost only as set by api.localhostOnly, not on ipv4", "ipv4", ip.String())
+		default:
+			logger.Warn("API served over plain HTTP on the network, set api.tls to encrypt it or api.localhostOnly to keep it local", "ipv4", ip.String())
+		}
+		WtGrp.Add(1)
+		go func() {
+			defer WtGrp.Done()
+			logging.Fatal(logger, "API webserver stopped", logging.Err(http.ListenAndServe(ws, r)))
+		}()
+
+		logger.Info("API webserver listening", "addr", ws, "scheme", "http")
+		logger.Info("Prometheus metrics available", "url", fmt.Sprintf("http://%s/metrics", ws))
+		return
+	}
 
+	srv := &http.Server{Addr: ws, Handler: r, TLSConfig: certs.tlsConfig()}
 	WtGrp.Add(1)
 	go func() {
 		defer WtGrp.Done()
-		log.Fatal(http.ListenAndServe(ws, r))
+		logging.Fatal(logger, "API webserver stopped", logging.Err(srv.ListenAndServeTLS("", "")))
 	}()
+	certs.watch()
+	logger.Info("API webserver listening", "addr", ws, "scheme", "https")
+
+	if tc.PlainHttpPort > 0 {
+		lws := fmt.Sprintf("127.0.0.1:%d", tc.PlainHttpPort)
+		WtGrp.Add(1)
+		go func() {
+			defer WtGrp.Done()
+			logging.Fatal(logger, "API webserver stopped", logging.Err(http.ListenAndServe(lws, r)))
+		}()
+		logger.Info("API webserver listening", "addr", lws, "scheme", "http", "localhostOnly", true)
+	}
 
-	log.Println("Loading API Webserver...")
-	log.Printf("Success: HTTP %s\n", ws)
-
-	log.Printf("Prometheus metrics available at http://%s/metrics\n", ws)
+	logger.Info("Prometheus metrics available", "url", fmt.Sprintf("https://%s/metrics", ws))
 }
 
-func serveHome(w http.ResponseWriter, r *http.Request) {
-	_, err := w.Write(fmt.Appendf(nil, "<h1>%s API Webserver</h1>\n", BUE))
-	if err != nil {
-		log.Println(err)
+// listenAddr is ipv4 and httpPort, or 127.0.0.1 when plain HTTP is kept local
+func listenAddr(ip net.IP, hp int, cfg apiConfig) string {
+	if !cfg.TLS.enabled() && cfg.LocalhostOnly {
+		ip = net.IPv4(127, 0, 0, 1)
 	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(hp))
+}
+
+func newRouter() *http.ServeMux {
+	r := http.NewServeMux()
+
+	r.HandleFunc("GET /api/v1/stats", authorize(RoleReadOnly, serveStats))
+	r.HandleFunc("GET /api/v1/stats/history", authorize(RoleReadOnly, serveStatsHistory))
+	r.HandleFunc("GET /api/v1/config", authorize(RoleReadOnly, serveConfig))
+	r.HandleFunc("GET /api/v1/cache", authorize(RoleOperator, serveCache))
+	r.HandleFunc("GET /api/v1/calls", authorize(RoleOperator, serveCalls))
+	r.HandleFunc("GET /api/v1/calls/{callID}", authorize(RoleOperator, serveCall))
+	r.HandleFunc("DELETE /api/v1/calls/{callID}", authorize(RoleOperator, terminateCall))
+	r.HandleFunc("GET /api/v1/calls/{callID}/trace", authorize(RoleOperator, serveCallTrace))
+	r.HandleFunc("GET /api/v1/events", authorize(RoleReadOnly, serveEvents))
+	r.HandleFunc("GET /api/v1/captures", authorize(RoleOperator, serveCaptures))
+	r.HandleFunc("POST /api/v1/captures", authorize(RoleOperator, startCapture))
+	r.HandleFunc("GET /api/v1/captures/{id}", authorize(RoleOperator, serveCapture))
+	r.HandleFunc("DELETE /api/v1/captures/{id}", authorize(RoleOperator, stopCapture))
+	r.HandleFunc("GET /api/v1/captures/{id}/pcap", authorize(RoleOperator, downloadCapture))
+	r.HandleFunc("GET /api/v1/debug/filters", authorize(RoleOperator, serveDebugFilters))
+	r.HandleFunc("POST /api/v1/debug/filters", authorize(RoleOperator, addDebugFilter))
+	r.HandleFunc("DELETE /api/v1/debug/filters/{id}", authorize(RoleOperator, removeDebugFilter))
+	r.HandleFunc("GET /api/v1/debug/traces", authorize(RoleOperator, serveDebugTraces))
+	r.HandleFunc("GET /api/v1/servers", authorize(RoleReadOnly, serveServers))
+	r.HandleFunc("POST /api/v1/servers", authorize(RoleAdmin, addServer))
+	r.HandleFunc("PUT /api/v1/servers/{id}", authorize(RoleAdmin, updateServer))
+	r.HandleFunc("DELETE /api/v1/servers/{id}", authorize(RoleAdmin, removeServer))
+	r.HandleFunc("POST /api/v1/servers/{id}/enable", authorize(RoleOperator, setServerState(sip.NodeEnabled)))
+	r.HandleFunc("POST /api/v1/servers/{id}/disable", authorize(RoleOperator, setServerState(sip.NodeDisabled)))
+	r.HandleFunc("POST /api/v1/servers/{id}/drain", authorize(RoleOperator, setServerState(sip.NodeDraining)))
+	r.HandleFunc("PATCH /api/v1/settings", authorize(RoleAdmin, updateSettings))
+	r.HandleFunc("GET /healthz", serveHealth(sip.Health))
+	r.HandleFunc("GET /readyz", serveHealth(sip.Readiness))
+	r.HandleFunc("GET /metrics", authorize(RoleReadOnly, Prometrics.Handler().ServeHTTP))
+	r.HandleFunc("GET /{$}", authorize(RoleReadOnly, serveDashboard))
+
+	return r
 }
 
 func serveConfig(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	response, _ := json.Marshal(sip.LoadBalancer)
+	response, _ := json.Marshal(sip.LoadBalancer.Config())
 	_, err := w.Write(response)
 	if err != nil {
-		log.Println(err)
+		logger.Debug("Failed to write response", logging.Err(err))
 	}
 }
 
 func serveCache(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response, _ := json.Marshal(sip.LoadBalancer.CallsCache())
-	_, err := w.Write(response)
-	if err != nil {
-		log.Println(err)
-	}
+	writeJSON(w, http.StatusOK, sip.LoadBalancer.AllCalls())
 }
 
 func serveStats(w http.ResponseWriter, r *http.Request) {
@@ -77,6 +141,14 @@ func serveStats(w http.ResponseWriter, r *http.Request) {
 		System          uint64
 		GCCycles        uint32
 		CallsCacheCount int
+		Distribution    sip.Distribution
+		HitWindow       int // seconds over which the servers' Hits, Answers and Rejects are counted
+		Caps            int
+		MaxCaps         int
+		ActiveCalls     int // dialogues in progress
+		MaxCalls        int
+		Quotas          []cl.QuotaUsage
+		KPIs            sip.KPIReport
 	}{
 		CPUCount:        runtime.NumCPU(),
 		GoRoutinesCount: runtime.NumGoroutine(),
@@ -84,11 +156,39 @@ func serveStats(w http.ResponseWriter, r *http.Request) {
 		System:          BToMB(m.Sys),
 		GCCycles:        m.NumGC,
 		CallsCacheCount: sip.LoadBalancer.CallsCacheCount(),
+		Distribution:    sip.LoadBalancer.GetDistribution(),
+		HitWindow:       sip.LoadBalancer.GetHitWindow(),
+		Caps:            CallLimiter.Caps(),
+		MaxCaps:         CallLimiter.Rate(),
+		ActiveCalls:     CallLimiter.ActiveCalls(),
+		MaxCalls:        CallLimiter.MaxCalls(),
+		Quotas:          CallLimiter.QuotaUsage(),
+		KPIs:            sip.LoadBalancer.KPIs(),
 	}
 
 	response, _ := json.Marshal(data)
 	_, err := w.Write(response)
 	if err != nil {
-		log.Println(err)
+		logger.Debug("Failed to write response", logging.Err(err))
 	}
 }
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Failed to encode response", logging.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(response); err != nil {
+		logger.Debug("Failed to write response", logging.Err(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
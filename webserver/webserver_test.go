@@ -0,0 +1,26 @@
+package webserver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	tls := tlsConfig{CertFile: "server.crt", KeyFile: "server.key"}
+	tests := []struct {
+		ip   string
+		cfg  apiConfig
+		want string
+	}{
+		{"192.0.2.10", apiConfig{}, "192.0.2.10:9080"},
+		{"192.0.2.10", apiConfig{LocalhostOnly: true}, "127.0.0.1:9080"},
+		{"192.0.2.10", apiConfig{TLS: tls, LocalhostOnly: true}, "192.0.2.10:9080"}, // TLS is served on ipv4
+		{"127.0.0.1", apiConfig{}, "127.0.0.1:9080"},
+		{"0.0.0.0", apiConfig{}, "0.0.0.0:9080"},
+	}
+	for _, tt := range tests {
+		if got := listenAddr(net.ParseIP(tt.ip), 9080, tt.cfg); got != tt.want {
+			t.Errorf("listenAddr(%s, %+v) = %s, want %s", tt.ip, tt.cfg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package window
+
+import "time"
+
+// Counter counts events over a sliding window split in buckets. The count
+// drops a whole bucket at a time. It is not safe for concurrent use.
+type Counter struct {
+	width    time.Duration
+	buckets  []int64
+	total    int64     // sum of the buckets
+	head     int       // index of the current bucket
+	headTime time.Time // start of the current bucket
+}
+
+func New(size time.Duration, buckets int) *Counter {
+	buckets = max(buckets, 1)
+	return &Counter{
+		width:   max(size/time.Duration(buckets), time.Millisecond),
+		buckets: make([]int64, buckets),
+	}
+}
+
+// Size returns the length of the window
+func (c *Counter) Size() time.Duration {
+	return c.width * time.Duration(len(c.buckets))
+}
+
+func (c *Counter) Add(n int64) {
+	c.AddAt(time.Now(), n)
+}
+
+func (c *Counter) AddAt(t time.Time, n int64) {
+	c.advance(t)
+	c.buckets[c.head] += n
+	c.total += n
+}
+
+func (c *Counter) Sum() int64 {
+	return c.SumAt(time.Now())
+}
+
+func (c *Counter) SumAt(t time.Time) int64 {
+	c.advance(t)
+	return c.total
+}
+
+func (c *Counter) Reset() {
+	clear(c.buckets)
+	c.total = 0
+	c.headTime = time.Time{}
+}
+
+// advance moves the head to the bucket of t, clearing the buckets it passes
+func (c *Counter) advance(t time.Time) {
+	start := t.Truncate(c.width)
+	if c.headTime.IsZero() {
+		c.headTime = start
+		return
+	}
+	if !start.After(c.headTime) {
+		return
+	}
+
+	steps := int(start.Sub(c.headTime) / c.width)
+	if steps >= len(c.buckets) {
+		clear(c.buckets)
+		c.total = 0
+	} else {
+		for range steps {
+			c.head = (c.head + 1) % len(c.buckets)
+			c.total -= c.buckets[c.head]
+			c.buckets[c.head] = 0
+		}
+	}
+	c.headTime = start
+}
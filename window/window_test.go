@@ -0,0 +1,81 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterExpiry(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)
+	c := New(10*time.Second, 10) // 1s buckets
+
+	c.AddAt(t0, 1)
+	c.AddAt(t0.Add(500*time.Millisecond), 2)
+	c.AddAt(t0.Add(3*time.Second), 4)
+	c.AddAt(t0.Add(9*time.Second), 8)
+
+	tests := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{9 * time.Second, 15},
+		{9999 * time.Millisecond, 15},
+		{10 * time.Second, 12}, // the first bucket expires whole
+		{13 * time.Second, 8},
+		{18 * time.Second, 8},
+		{19 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := c.SumAt(t0.Add(tt.at)); got != tt.want {
+			t.Errorf("Sum after %v = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestCounterIdleLongerThanWindow(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)
+	c := New(time.Minute, 6)
+
+	c.AddAt(t0, 5)
+	c.AddAt(t0.Add(25*time.Second), 5)
+	if got := c.SumAt(t0.Add(time.Hour)); got != 0 {
+		t.Errorf("Sum after an idle hour = %d, want 0", got)
+	}
+
+	c.AddAt(t0.Add(time.Hour+time.Second), 3)
+	if got := c.SumAt(t0.Add(time.Hour + 30*time.Second)); got != 3 {
+		t.Errorf("Sum = %d, want 3", got)
+	}
+}
+
+func TestCounterIgnoresThePast(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)
+	c := New(10*time.Second, 10)
+
+	c.AddAt(t0.Add(5*time.Second), 1)
+	c.AddAt(t0, 1) // a clock step back counts in the current bucket
+	if got := c.SumAt(t0.Add(5 * time.Second)); got != 2 {
+		t.Errorf("Sum = %d, want 2", got)
+	}
+	if got := c.SumAt(t0.Add(15 * time.Second)); got != 0 {
+		t.Errorf("Sum = %d, want 0", got)
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)
+	c := New(10*time.Second, 10)
+
+	c.AddAt(t0, 7)
+	c.Reset()
+	if got := c.SumAt(t0); got != 0 {
+		t.Errorf("Sum after Reset = %d, want 0", got)
+	}
+	c.AddAt(t0.Add(time.Second), 2)
+	if got := c.SumAt(t0.Add(10 * time.Second)); got != 2 {
+		t.Errorf("Sum = %d, want 2", got)
+	}
+	if c.Size() != 10*time.Second {
+		t.Errorf("Size = %v", c.Size())
+	}
+}